@@ -0,0 +1,271 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"debug/buildinfo"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/microsoft/go/_util/internal/archive"
+)
+
+const description = `
+This command compares two Go distribution archives (zip or tar.gz) and reports
+which entries were added, removed, or changed. Pass the old and new archive
+paths as non-flag arguments.
+
+For each changed entry, the differences in mode, size, and SHA256 hash are
+shown. For Go binaries, the embedded build info (as shown by 'go version -m')
+and the GOEXPERIMENT setting are also compared.
+
+Example: compare two builds of the same release:
+
+  eng/run.ps1 archive-diff go1.23.1-1.linux-amd64.tar.gz go1.23.1-2.linux-amd64.tar.gz
+`
+
+func main() {
+	help := flag.Bool("h", false, "Print this help message.")
+	jsonOutput := flag.Bool("json", false, "Write the report as JSON rather than text.")
+	showUnchanged := flag.Bool("unchanged", false, "Include unchanged entries in the report.")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "%s\n", description)
+	}
+
+	flag.Parse()
+	if *help {
+		flag.Usage()
+		return
+	}
+	if flag.NArg() != 2 {
+		flag.Usage()
+		log.Fatal("Expected exactly two archives to compare.")
+	}
+
+	r, err := diffArchives(flag.Arg(0), flag.Arg(1), *showUnchanged)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(r)
+	} else {
+		err = r.writeText(os.Stdout)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// report is the result of comparing two archives.
+type report struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+
+	Added     []*entryInfo `json:"added,omitempty"`
+	Removed   []*entryInfo `json:"removed,omitempty"`
+	Changed   []*entryDiff `json:"changed,omitempty"`
+	Unchanged []*entryInfo `json:"unchanged,omitempty"`
+
+	UnchangedCount int `json:"unchangedCount"`
+}
+
+// entryInfo is the comparable summary of one archive entry.
+type entryInfo struct {
+	Name   string `json:"name"`
+	Mode   string `json:"mode"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+	// Linkname is the target of a tar symlink or hard link. A zip symlink's target is its content.
+	Linkname string `json:"linkname,omitempty"`
+
+	// BuildInfo is the 'go version -m' style build info, if the entry is a Go binary.
+	BuildInfo string `json:"buildInfo,omitempty"`
+	// GOEXPERIMENT is the GOEXPERIMENT build setting, if the entry is a Go binary.
+	GOEXPERIMENT string `json:"goexperiment,omitempty"`
+}
+
+// entryDiff describes an entry that exists in both archives but isn't identical.
+type entryDiff struct {
+	Name string     `json:"name"`
+	Old  *entryInfo `json:"old"`
+	New  *entryInfo `json:"new"`
+
+	// Differences lists each difference as a human-readable line.
+	Differences []string `json:"differences"`
+	// BuildInfoDiff lists build info lines that were removed ("-") and added ("+").
+	BuildInfoDiff []string `json:"buildInfoDiff,omitempty"`
+}
+
+func diffArchives(oldPath, newPath string, includeUnchanged bool) (*report, error) {
+	oldEntries, err := readEntries(oldPath)
+	if err != nil {
+		return nil, err
+	}
+	newEntries, err := readEntries(newPath)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &report{Old: oldPath, New: newPath}
+	for name, o := range oldEntries {
+		n, ok := newEntries[name]
+		if !ok {
+			r.Removed = append(r.Removed, o)
+			continue
+		}
+		if d := diffEntry(o, n); d != nil {
+			r.Changed = append(r.Changed, d)
+		} else {
+			r.UnchangedCount++
+			if includeUnchanged {
+				r.Unchanged = append(r.Unchanged, n)
+			}
+		}
+	}
+	for name, n := range newEntries {
+		if _, ok := oldEntries[name]; !ok {
+			r.Added = append(r.Added, n)
+		}
+	}
+
+	byName := func(a, b *entryInfo) int { return strings.Compare(a.Name, b.Name) }
+	slices.SortFunc(r.Added, byName)
+	slices.SortFunc(r.Removed, byName)
+	slices.SortFunc(r.Unchanged, byName)
+	slices.SortFunc(r.Changed, func(a, b *entryDiff) int { return strings.Compare(a.Name, b.Name) })
+	return r, nil
+}
+
+func readEntries(path string) (map[string]*entryInfo, error) {
+	a, err := archive.New(path)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]*entryInfo)
+	if err := a.Walk(func(e *archive.Entry, r io.Reader) error {
+		info := &entryInfo{
+			Name:     e.Name,
+			Mode:     e.Mode.String(),
+			Size:     e.Size,
+			Linkname: e.Linkname,
+		}
+		if r != nil {
+			// Read the whole entry: debug/buildinfo needs an io.ReaderAt. The largest entries in
+			// a Go distribution are tens of MB, so this is fine.
+			content, err := io.ReadAll(r)
+			if err != nil {
+				return fmt.Errorf("failed to read %q: %v", e.Name, err)
+			}
+			sum := sha256.Sum256(content)
+			info.SHA256 = hex.EncodeToString(sum[:])
+			if bi, err := buildinfo.Read(bytes.NewReader(content)); err == nil {
+				info.BuildInfo = bi.String()
+				for _, s := range bi.Settings {
+					if s.Key == "GOEXPERIMENT" {
+						info.GOEXPERIMENT = s.Value
+					}
+				}
+			}
+		}
+		if _, ok := entries[e.Name]; ok {
+			return fmt.Errorf("duplicate entry %q", e.Name)
+		}
+		entries[e.Name] = info
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to read %q: %v", path, err)
+	}
+	return entries, nil
+}
+
+// diffEntry compares two entries with the same name. Returns nil if they are the same.
+func diffEntry(o, n *entryInfo) *entryDiff {
+	d := &entryDiff{Name: o.Name, Old: o, New: n}
+	if o.Mode != n.Mode {
+		d.Differences = append(d.Differences, fmt.Sprintf("mode: %v -> %v", o.Mode, n.Mode))
+	}
+	if o.Size != n.Size {
+		d.Differences = append(d.Differences, fmt.Sprintf("size: %v -> %v", o.Size, n.Size))
+	}
+	if o.SHA256 != n.SHA256 {
+		d.Differences = append(d.Differences, fmt.Sprintf("sha256: %v -> %v", o.SHA256, n.SHA256))
+	}
+	if o.Linkname != n.Linkname {
+		d.Differences = append(d.Differences, fmt.Sprintf("linkname: %q -> %q", o.Linkname, n.Linkname))
+	}
+	if o.GOEXPERIMENT != n.GOEXPERIMENT {
+		d.Differences = append(d.Differences, fmt.Sprintf("GOEXPERIMENT: %q -> %q", o.GOEXPERIMENT, n.GOEXPERIMENT))
+	}
+	if o.BuildInfo != n.BuildInfo {
+		d.Differences = append(d.Differences, "build info differs")
+		d.BuildInfoDiff = diffLines(o.BuildInfo, n.BuildInfo)
+	}
+	if len(d.Differences) == 0 {
+		return nil
+	}
+	return d
+}
+
+// diffLines returns the lines only in o prefixed by "-" and the lines only in n prefixed by "+".
+// Build info lines are mostly unique key/value pairs, so this is more readable than an ordered
+// diff.
+func diffLines(o, n string) []string {
+	oLines := strings.Split(strings.TrimSpace(o), "\n")
+	nLines := strings.Split(strings.TrimSpace(n), "\n")
+	var result []string
+	for _, l := range oLines {
+		if !slices.Contains(nLines, l) {
+			result = append(result, "- "+l)
+		}
+	}
+	for _, l := range nLines {
+		if !slices.Contains(oLines, l) {
+			result = append(result, "+ "+l)
+		}
+	}
+	return result
+}
+
+func (r *report) writeText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %v\n", r.Old)
+	fmt.Fprintf(&b, "+++ %v\n", r.New)
+	for _, e := range r.Removed {
+		fmt.Fprintf(&b, "- %v (%v, %v bytes)\n", e.Name, e.Mode, e.Size)
+	}
+	for _, e := range r.Added {
+		fmt.Fprintf(&b, "+ %v (%v, %v bytes)\n", e.Name, e.Mode, e.Size)
+	}
+	for _, d := range r.Changed {
+		fmt.Fprintf(&b, "~ %v\n", d.Name)
+		for _, line := range d.Differences {
+			fmt.Fprintf(&b, "    %v\n", line)
+		}
+		for _, line := range d.BuildInfoDiff {
+			fmt.Fprintf(&b, "      %v\n", line)
+		}
+	}
+	for _, e := range r.Unchanged {
+		fmt.Fprintf(&b, "  %v\n", e.Name)
+	}
+	fmt.Fprintf(&b, "%v added, %v removed, %v changed, %v unchanged\n",
+		len(r.Added), len(r.Removed), len(r.Changed), r.UnchangedCount)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,258 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type testEntry struct {
+	name    string
+	mode    fs.FileMode
+	content []byte
+}
+
+func TestDiffArchives(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test that builds Go binaries in short mode")
+	}
+	dir := t.TempDir()
+	oldTool := buildTool(t, dir, "old", "")
+	newTool := buildTool(t, dir, "new", "staticlockranking")
+
+	oldEntries := []testEntry{
+		{"go/VERSION", 0o644, []byte("go1.23.1")},
+		{"go/README.md", 0o644, []byte("a")},
+		{"go/removed.txt", 0o644, []byte("removed")},
+		{"go/misc/run.sh", 0o644, []byte("#!/bin/sh")},
+		{"go/bin/tool", 0o755, oldTool},
+	}
+	newEntries := []testEntry{
+		{"go/VERSION", 0o644, []byte("go1.23.1")},
+		{"go/README.md", 0o644, []byte("bb")},
+		{"go/added.txt", 0o644, []byte("added")},
+		{"go/misc/run.sh", 0o755, []byte("#!/bin/sh")},
+		{"go/bin/tool", 0o755, newTool},
+	}
+
+	for _, ext := range []string{".zip", ".tar.gz"} {
+		t.Run(ext, func(t *testing.T) {
+			oldPath := filepath.Join(dir, "old"+ext)
+			newPath := filepath.Join(dir, "new"+ext)
+			writeTestArchive(t, oldPath, oldEntries)
+			writeTestArchive(t, newPath, newEntries)
+
+			r, err := diffArchives(oldPath, newPath, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := infoNames(r.Added), []string{"go/added.txt"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("added = %v, want %v", got, want)
+			}
+			if got, want := infoNames(r.Removed), []string{"go/removed.txt"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("removed = %v, want %v", got, want)
+			}
+			if got, want := infoNames(r.Unchanged), []string{"go/VERSION"}; !reflect.DeepEqual(got, want) || r.UnchangedCount != 1 {
+				t.Errorf("unchanged = %v (count %v), want %v", got, r.UnchangedCount, want)
+			}
+
+			changed := make(map[string]*entryDiff)
+			for _, d := range r.Changed {
+				changed[d.Name] = d
+			}
+			if len(changed) != 3 {
+				t.Fatalf("changed %v entries, want 3: %v", len(r.Changed), r.Changed)
+			}
+			if got, want := changed["go/misc/run.sh"].Differences, []string{"mode: -rw-r--r-- -> -rwxr-xr-x"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("run.sh differences = %q, want %q", got, want)
+			}
+			readme := changed["go/README.md"].Differences
+			if len(readme) != 2 || readme[0] != "size: 1 -> 2" || !strings.HasPrefix(readme[1], "sha256: ") {
+				t.Errorf("README.md differences = %q, want size and sha256", readme)
+			}
+			tool := changed["go/bin/tool"]
+			if !slices.Contains(tool.Differences, `GOEXPERIMENT: "" -> "staticlockranking"`) || !slices.Contains(tool.Differences, "build info differs") {
+				t.Errorf("tool differences = %q, want GOEXPERIMENT and build info", tool.Differences)
+			}
+			if !slices.Contains(tool.BuildInfoDiff, "+ build\tGOEXPERIMENT=staticlockranking") {
+				t.Errorf("tool build info diff = %q, want the added GOEXPERIMENT setting", tool.BuildInfoDiff)
+			}
+
+			var b strings.Builder
+			if err := r.writeText(&b); err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range []string{
+				"--- " + oldPath + "\n+++ " + newPath + "\n",
+				"- go/removed.txt (-rw-r--r--, 7 bytes)\n",
+				"+ go/added.txt (-rw-r--r--, 5 bytes)\n",
+				"~ go/misc/run.sh\n    mode: -rw-r--r-- -> -rwxr-xr-x\n",
+				"      + build\tGOEXPERIMENT=staticlockranking\n",
+				"  go/VERSION\n",
+				"1 added, 1 removed, 3 changed, 1 unchanged\n",
+			} {
+				if !strings.Contains(b.String(), want) {
+					t.Errorf("writeText output doesn't contain %q:\n%v", want, b.String())
+				}
+			}
+
+			data, err := json.Marshal(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded report
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(&decoded, r) {
+				t.Errorf("JSON round trip = %+v, want %+v", &decoded, r)
+			}
+		})
+	}
+}
+
+func TestDiffArchivesLinkname(t *testing.T) {
+	dir := t.TempDir()
+	writeLinks := func(path, target string) {
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		gw := gzip.NewWriter(f)
+		tw := tar.NewWriter(gw)
+		for _, h := range []*tar.Header{
+			{Typeflag: tar.TypeSymlink, Name: "go/bin/same", Linkname: "../pkg/tool/same", Mode: 0o777},
+			{Typeflag: tar.TypeSymlink, Name: "go/bin/link", Linkname: target, Mode: 0o777},
+		} {
+			if err := tw.WriteHeader(h); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := gw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldPath := filepath.Join(dir, "old.tar.gz")
+	newPath := filepath.Join(dir, "new.tar.gz")
+	writeLinks(oldPath, "../pkg/tool/old")
+	writeLinks(newPath, "../pkg/tool/new")
+
+	r, err := diffArchives(oldPath, newPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Changed) != 1 || r.UnchangedCount != 1 {
+		t.Fatalf("changed = %v, unchanged count = %v, want 1 each", r.Changed, r.UnchangedCount)
+	}
+	d := r.Changed[0]
+	if want := []string{`linkname: "../pkg/tool/old" -> "../pkg/tool/new"`}; d.Name != "go/bin/link" || !reflect.DeepEqual(d.Differences, want) {
+		t.Errorf("changed %v with differences %q, want go/bin/link with %q", d.Name, d.Differences, want)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	got := diffLines("a\nb\nc\n", "b\nc\nd\n")
+	want := []string{"- a", "+ d"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffLines = %q, want %q", got, want)
+	}
+	if got := diffLines("a\nb", "a\nb\n"); got != nil {
+		t.Errorf("diffLines of the same lines = %q, want nil", got)
+	}
+}
+
+// buildTool builds a small Go program with the given GOEXPERIMENT and returns the binary.
+func buildTool(t *testing.T, dir, name, experiment string) []byte {
+	t.Helper()
+	src := filepath.Join(dir, name+".go")
+	if err := os.WriteFile(src, []byte("package main\n\nfunc main() {}\n"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, name)
+	cmd := exec.Command("go", "build", "-trimpath", "-o", out, src)
+	cmd.Env = append(os.Environ(), "CGO_ENABLED=0", "GOEXPERIMENT="+experiment, "GOFLAGS=")
+	if b, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to build %v: %v\n%s", name, err, b)
+	}
+	content, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func writeTestArchive(t *testing.T, path string, entries []testEntry) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if strings.HasSuffix(path, ".zip") {
+		zw := zip.NewWriter(f)
+		for _, e := range entries {
+			h := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+			h.SetMode(e.mode)
+			w, err := zw.CreateHeader(h)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(e.content); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		gw := gzip.NewWriter(f)
+		tw := tar.NewWriter(gw)
+		for _, e := range entries {
+			if err := tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     e.name,
+				Mode:     int64(e.mode),
+				Size:     int64(len(e.content)),
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tw.Write(e.content); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := gw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func infoNames(infos []*entryInfo) []string {
+	var names []string
+	for _, e := range infos {
+		names = append(names, e.Name)
+	}
+	return names
+}
@@ -8,7 +8,6 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -18,6 +17,9 @@ import (
 	"github.com/microsoft/go-infra/patch"
 	"github.com/microsoft/go-infra/submodule"
 	"github.com/microsoft/go/_util/buildutil"
+	"github.com/microsoft/go/_util/internal/archive"
+	"github.com/microsoft/go/_util/internal/msi"
+	"github.com/microsoft/go/_util/internal/symstore"
 )
 
 const description = `
@@ -45,7 +47,8 @@ func main() {
 	flag.BoolVar(&o.JSON, "json", false, "Runs tests with -json flag to emit verbose results in JSON format. For use in CI.")
 	flag.BoolVar(&o.PackBuild, "packbuild", false, "Enable creating an archive of this build using upstream 'distpack' and placing it in eng/artifacts/bin.")
 	flag.BoolVar(&o.PackSource, "packsource", false, "Enable creating a source archive using upstream 'distpack' and placing it in eng/artifacts/bin.")
-	flag.BoolVar(&o.CreatePDB, "pdb", false, "Create PDB files for all the PE binaries in the bin and tool directories. The PE files are modified in place and PDBs are placed in eng/artifacts/symbols.")
+	flag.BoolVar(&o.PackInstaller, "packinstaller", false, "With -packbuild and a Windows target, also create an MSI installer from the zip using 'wixl' and place it in eng/artifacts/bin. BUILD_BUILDNUMBER is the MSI release number: an integer from 0 to 999, or a CI build number, which is ordered below any release.")
+	flag.BoolVar(&o.CreatePDB, "pdb", false, "Create PDB files for all the PE binaries in the bin and tool directories. The PE files are modified in place and PDBs are placed in eng/artifacts/symbols. With -packbuild, also create a symbol store bundle in eng/artifacts/bin.")
 
 	flag.BoolVar(
 		&o.Refresh, "refresh", false,
@@ -78,14 +81,15 @@ func main() {
 }
 
 type options struct {
-	SkipBuild  bool
-	Test       bool
-	JSON       bool
-	PackBuild  bool
-	PackSource bool
-	CreatePDB  bool
-	Refresh    bool
-	Experiment string
+	SkipBuild     bool
+	Test          bool
+	JSON          bool
+	PackBuild     bool
+	PackSource    bool
+	PackInstaller bool
+	CreatePDB     bool
+	Refresh       bool
+	Experiment    string
 
 	MaxMakeAttempts int
 }
@@ -94,13 +98,11 @@ func build(o *options) error {
 
 	scriptExtension := ".bash"
 	executableExtension := ""
-	archiveExtension := ".tar.gz"
 	shellPrefix := []string{"bash"}
 
 	if runtime.GOOS == "windows" {
 		scriptExtension = ".bat"
 		executableExtension = ".exe"
-		archiveExtension = ".zip"
 		shellPrefix = []string{"cmd.exe", "/c"}
 	}
 
@@ -138,6 +140,17 @@ func build(o *options) error {
 	}
 	fmt.Printf("---- Target platform: %v_%v\n", targetOS, targetArch)
 
+	// distpack picks the archive format based on the target, so a Windows archive cross-compiled
+	// on Linux is a zip.
+	archiveExtension := ".tar.gz"
+	if targetOS == "windows" {
+		archiveExtension = ".zip"
+	}
+
+	if o.PackInstaller && (!o.PackBuild || targetOS != "windows") {
+		return errors.New("-packinstaller requires -packbuild and a Windows target")
+	}
+
 	// Setting GOROOT explicitly in the environment has not been necessary since Go 1.9
 	// (https://go.dev/doc/go1.9#goroot), but a dev or build machine may still have it set. It
 	// interferes with attempts to run the built Go (such as when building the race runtime), so
@@ -255,6 +268,8 @@ func build(o *options) error {
 	}
 
 	goRootDir := filepath.Join(rootDir, "go")
+	// The PE binaries and the PDBs created for them, used to create a symbol store when packing.
+	var bins, pdbs []string
 	if o.CreatePDB {
 		if _, err := exec.LookPath("gopdb"); err != nil {
 			return fmt.Errorf("gopdb not found in PATH: %v", err)
@@ -276,7 +291,6 @@ func build(o *options) error {
 			return err
 		}
 
-		var bins []string
 		for _, dir := range []string{binDir, toolsDir} {
 			entries, err := os.ReadDir(dir)
 			if err != nil {
@@ -299,6 +313,7 @@ func build(o *options) error {
 			if err := runCmd(cmd); err != nil {
 				return fmt.Errorf("gopdb failed: %v", err)
 			}
+			pdbs = append(pdbs, out)
 		}
 	}
 
@@ -337,15 +352,15 @@ func build(o *options) error {
 		var packs []packCopy
 		// Insert the build ID to make sure the archive filename is unique. We might change
 		// patches but build the same submodule commit multiple times.
-		buildID := getBuildID()
+		buildID := buildutil.GetBuildID()
+		// distpack calls GOARCH=arm "arm" in its tar.gz filename, but the upstream release process
+		// changes it to "armv6l" on https://go.dev/dl/ to match the historical name. Do the same
+		// here.
+		brandingTargetArch := targetArch
+		if brandingTargetArch == "arm" {
+			brandingTargetArch = "armv6l"
+		}
 		if o.PackBuild {
-			// distpack calls GOARCH=arm "arm" in its tar.gz filename, but the upstream release
-			// process changes it to "armv6l" on https://go.dev/dl/ to match the historical name.
-			// Do the same here.
-			brandingTargetArch := targetArch
-			if brandingTargetArch == "arm" {
-				brandingTargetArch = "armv6l"
-			}
 			packs = append(packs, packCopy{
 				src: filepath.Join(distPackDir, version+"."+targetOS+"-"+targetArch+archiveExtension),
 				dst: filepath.Join(artifactsBinDir, version+"-"+buildID+"."+targetOS+"-"+brandingTargetArch+archiveExtension),
@@ -360,10 +375,43 @@ func build(o *options) error {
 		fmt.Printf("---- Copying distpack output to artifacts dir %v\n", artifactsBinDir)
 		for _, p := range packs {
 			fmt.Printf("---- Copying %q to %q...\n", p.src, p.dst)
-			if err := copyFile(p.dst, p.src); err != nil {
+			if err := archive.CopyFile(p.dst, p.src); err != nil {
+				return err
+			}
+		}
+		if o.CreatePDB && o.PackBuild {
+			// Lay out the PDBs in a symbol store and bundle it next to the archive, so the bundle
+			// is signed and published with it.
+			storeDir := filepath.Join(rootDir, "eng", "artifacts", "symstore")
+			if err := os.RemoveAll(storeDir); err != nil {
+				return err
+			}
+			bundlePath := filepath.Join(artifactsBinDir, version+"-"+buildID+"."+targetOS+"-"+brandingTargetArch+".symbols.tar")
+			fmt.Printf("---- Creating symbol store %q and bundle %q...\n", storeDir, bundlePath)
+			if _, err := symstore.Build(storeDir, pdbs, bins); err != nil {
+				return fmt.Errorf("failed to create symbol store: %v", err)
+			}
+			if err := symstore.WriteBundle(bundlePath, storeDir); err != nil {
 				return err
 			}
 		}
+		if o.PackInstaller {
+			zipPath := packs[0].dst
+			mo, err := msi.NewOptions(zipPath)
+			if err != nil {
+				return err
+			}
+			msiPath := filepath.Join(artifactsBinDir, mo.Filename())
+			workDir, err := os.MkdirTemp("", "msi-")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(workDir)
+			fmt.Printf("---- Creating installer %q from %q...\n", msiPath, zipPath)
+			if err := msi.Build(zipPath, msiPath, workDir, mo); err != nil {
+				return fmt.Errorf("failed to create installer: %v", err)
+			}
+		}
 	}
 
 	fmt.Printf("---- Build command complete.\n")
@@ -393,36 +441,6 @@ func writeDevelVersionFile(goRootDir, toolsDir string) (string, error) {
 	return fields[1], nil
 }
 
-// copyFile copies src to dst, creating dst's directory if necessary. Handles errors robustly,
-// see https://github.com/golang/go/blob/c3458e35f4/src/cmd/internal/archive/archive_test.go#L57
-// Doesn't copy file permissions.
-func copyFile(dst, src string) (err error) {
-	err = os.MkdirAll(filepath.Dir(dst), os.ModePerm)
-	if err != nil {
-		return err
-	}
-	var s, d *os.File
-	s, err = os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer s.Close()
-	d, err = os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer func() {
-		if e := d.Close(); err == nil {
-			err = e
-		}
-	}()
-	_, err = io.Copy(d, s)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
 func runCommandLine(commandLine ...string) error {
 	c := exec.Command(commandLine[0], commandLine[1:]...)
 	c.Stdout = os.Stdout
@@ -434,12 +452,3 @@ func runCmd(cmd *exec.Cmd) error {
 	fmt.Printf("---- Running command: %v\n", cmd.Args)
 	return cmd.Run()
 }
-
-// getBuildID returns BUILD_BUILDNUMBER if defined (e.g. a CI build). Otherwise, "dev".
-func getBuildID() string {
-	archiveVersion := os.Getenv("BUILD_BUILDNUMBER")
-	if archiveVersion == "" {
-		return "dev"
-	}
-	return archiveVersion
-}
@@ -4,18 +4,13 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
-	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"os"
-	"os/exec"
 	"regexp"
 	"strings"
-	"sync"
+	"time"
 )
 
 const description = `
@@ -32,10 +27,82 @@ env variables that start with that string and interprets their values as JSON sp
 part of the env var after the prefix is what the rule should be called in logs. If parsing is not
 successful, that rule is ignored. Cmdscan writes logs about what it finds.
 
+To specify many rules, pass a YAML or JSON file to '-rules'. The file contains a "rules" list, and
+each rule in it has a "name". Both '-envprefix' and '-rules' may be used at the same time.
+
+With '-strict', an invalid rule or unknown rule field is an error rather than being ignored. Use this
+when testing changes to the rules.
+
 When using an AzDO pipeline, SHOUT_CASE is recommended so AzDO's env var naming conversion doesn't
 change the result:
 https://docs.microsoft.com/en-us/azure/devops/pipelines/process/variables?view=azure-devops&tabs=yaml%2Cbatch#environment-variables
 
+The '-format' flag selects how issues are reported and how '-successvar' is set:
+
+  azdo:   AzDO "log issue" and "set variable" logging commands.
+  github: GitHub Actions "::warning::" and "::error::" workflow commands. '-successvar' sets a step
+          output by appending to the $GITHUB_OUTPUT file.
+  plain:  Readable text for local runs.
+  auto:   The default. Uses azdo if TF_BUILD is set, github if GITHUB_ACTIONS is set, otherwise plain.
+
+If a pattern has "file" and "line" named capture groups, azdo and github link the issue to that
+location. To also write each finding to a file as a line of JSON, pass the path to '-findings'.
+
+When the command exits, cmdscan prints a summary of the rules that matched: the number of matches,
+the first and last matching lines, and the URL. Pass '-summary' to also write it to a file. If any
+rule with "severity": "error" matched, cmdscan fails and doesn't set '-successvar', even if the
+command succeeded. Only matches during the last attempt to run the command count.
+
+If the command fails and a rule with "retry": true matched, cmdscan runs the command again, up to
+'-retries' times, waiting '-retry-delay' before the first retry and twice as long before each later
+one. This is for known transient failures, like "Access is denied" while a file is locked. The log
+and the summary record the rules that caused each retry.
+
+Cmdscan echoes the command's stdout and stderr to its own stdout and stderr. It reads both streams
+at the same time and timestamps each line, then merges the streams in timestamp order, with stdout
+first for lines read at the same time, and echoes and scans the lines one at a time, so the issues
+it reports are next to the line that caused them. To merge them, a line may be held back for up to
+20ms while the other stream is quiet. Interrupt and terminate signals are forwarded to the command.
+Cmdscan exits with the command's exit code, or 128 plus the signal number if a signal killed the
+command.
+
+Phase rules split the output into collapsible sections: each matching line starts a new section,
+named after the rule's message, and ends the previous one. The sections are AzDO "##[group]" or
+GitHub "::group::" sections, depending on '-format'. The summary lists the duration of each phase.
+Phase rules match one line, and don't count as issues. For example:
+
+  rules:
+    - name: BuildPhase
+      pattern: '^---- (?P<phase>Running command: .*|Building race runtime.*)'
+      message: '${phase}'
+      phase: true
+    - name: DistTestPhase
+      pattern: '^##### (?P<phase>.*)'
+      message: '${phase}'
+      phase: true
+
+With '-testjson', lines on stdout that are test2json events, like 'go test -json' and
+'dist test -json' write, are handled as tests rather than text: cmdscan echoes and scans the test
+output in the events, and reports an issue with the full output of each failed test (or package,
+if no test in it failed). If a rule matched the output of the test, the failure is a warning
+labeled as a known issue with the names of the rules. Otherwise, it is an error labeled as a new
+failure. The summary lists the failures.
+
+Cmdscan can redact secrets from the output before echoing and scanning it, replacing them with
+"***". '-redact-env' redacts the value of an env var, '-redact-pattern' redacts matches of a regex
+(or only its "secret" named group, if it has one), and '-redact-entropy' redacts tokens of 20 or
+more letters, digits, and "+/_-" that look random: they contain a letter and a digit, and their
+Shannon entropy is at least the given number of bits per character. The summary counts the
+redactions. For example:
+
+  -redact-env SYSTEM_ACCESSTOKEN -redact-pattern '(?i)bearer (?P<secret>\S+)' -redact-entropy 4.5
+
+To track issues across runs without a service like runfo, pass a file to '-history'. After the
+command exits, cmdscan appends a line of JSON to it for each rule that isn't a phase rule, with the
+time, the builder (GO_BUILDER_NAME), the commit (BUILD_SOURCEVERSION, GITHUB_SHA, or the git HEAD),
+the rule name, and its number of matches. Use "cmdscan history -history <file>" to show how often
+each rule matched, when it was first and last seen, and which rules haven't matched in a while.
+
 Use "--" to unambiguously separate the flag with the command to run.
 
 The format for a rule is a JSON object with regex string "pattern" and optionally a "url" string
@@ -43,32 +110,88 @@ that contains more information about the issue. For example:
 
   {"pattern": "(?i)Access is denied", "url": "https://github.com/microsoft/go/issues/241"}
 
+A rule may also have these optional fields:
+
+  "severity":       "warning" (default) or "error", the type of timeline issue to log.
+  "stream":         "stdout", "stderr", or "both" (default), the output to match against.
+  "maxOccurrences": the number of matches to log as issues. Later matches are only counted. The
+                    default, 0, means no limit.
+  "description":    a longer explanation of the issue, written to the cmdscan log on a match.
+  "lines":          the number of consecutive lines to match the pattern against, joined by "\n".
+                    Use this to find issues that span lines, like a panic followed by a specific
+                    stack frame. Lines that were part of a match aren't matched again by the rule.
+                    The default is 1.
+  "message":        the issue message, which may refer to named or numbered capture groups in the
+                    pattern like "${test}" or "$1". By default, the message is the matching line,
+                    or the matching text of a multi-line rule.
+  "phase":          true to make the rule mark the start of a phase of the command rather than an
+                    issue. See below.
+  "retry":          true to run the command again if it fails after the rule matched. See below.
+
+An example rules file:
+
+  rules:
+    - name: AccessDenied
+      pattern: '(?i)Access is denied'
+      url: https://github.com/microsoft/go/issues/241
+      stream: stderr
+      maxOccurrences: 5
+      description: >
+        A file is locked by another process, often antivirus. Retrying usually works.
+    - name: TestTimeout
+      pattern: 'panic: test timed out after \S+\n\s+running tests:\n\s+(?P<test>\S+)'
+      lines: 3
+      message: 'Timeout in ${test}'
+
 Example cmdscan call:
 
   pwsh eng/run.ps1 cmdscan -envprefix GoCmdscanRule -- pwsh eng/run.ps1 build -test
 `
 
-type rule struct {
-	Pattern string `json:"pattern"`
-	URL     string `json:"url"`
-}
-
-type filter struct {
-	// name is a simple description of the detected error that should be uniquely searchable so
-	// runfo can keep track of this issue with "contains" searches on each timeline element.
-	name string
-	// url is an optional URL that links to more information about this error.
-	url string
-	// regexp is the regex that is matched against each line of output to find an issue.
-	regexp *regexp.Regexp
-}
-
-var filters []filter
+var (
+	filters []filter
+	// outputs receive the findings. The first is the console output.
+	outputs []output
+	// redaction removes secrets from the output.
+	redaction redactor
+	// testJSON tracks the tests in a test2json stream on stdout, if '-testjson' is set.
+	testJSON *testRun
+)
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "history" {
+		runHistory(os.Args[2:])
+		return
+	}
+
 	help := flag.Bool("h", false, "Print this help message.")
 	prefix := flag.String("envprefix", "", "The env var prefix to use to find scan rules.")
-	successVar := flag.String("successvar", "", "The AzDO pipeline variable name to set to 'true' upon success.")
+	rulesPath := flag.String("rules", "", "A YAML or JSON file that defines scan rules.")
+	strict := flag.Bool("strict", false, "Fail if a rule is invalid rather than ignoring it.")
+	successVar := flag.String("successvar", "", "The pipeline variable name to set to 'true' upon success.")
+	format := flag.String("format", formatAuto, "The format of issues and variables: auto, azdo, github, or plain.")
+	findingsPath := flag.String("findings", "", "A file to write each finding to as a line of JSON.")
+	maxRetries := flag.Int("retries", 2, "The number of times to run the command again if it fails after a retry rule matched.")
+	retryDelay := flag.Duration("retry-delay", 10*time.Second, "The delay before the first retry. Each later retry waits twice as long.")
+	testJSONFlag := flag.Bool("testjson", false, "Report each failed test in test2json events on stdout, like 'dist test -json' writes.")
+	timestamps := flag.Bool("timestamps", false, "Prefix each line of output with the time cmdscan read it.")
+	flag.Func("redact-env", "The name of an env var whose value is a secret to redact from the output. May be repeated.", func(s string) error {
+		if v, ok := os.LookupEnv(s); ok {
+			redaction.addValue(v)
+		}
+		return nil
+	})
+	flag.Func("redact-pattern", "A regex that matches secrets to redact from the output. May be repeated.", func(s string) error {
+		exp, err := regexp.Compile(s)
+		if err != nil {
+			return err
+		}
+		redaction.patterns = append(redaction.patterns, exp)
+		return nil
+	})
+	flag.Float64Var(&redaction.minEntropy, "redact-entropy", 0, "Redact tokens with at least this many bits of entropy per character. 0 disables it. 4.5 is a good start.")
+	summaryPath := flag.String("summary", "", "A file to write the summary report to, as JSON if it ends in .json, otherwise Markdown.")
+	historyPath := flag.String("history", "", "A file to append the number of matches of each rule to, keyed by GO_BUILDER_NAME and commit.")
 
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), "Usage:\n")
@@ -81,14 +204,21 @@ func main() {
 		flag.Usage()
 		return
 	}
+	if flag.NArg() == 0 {
+		flag.Usage()
+		log.Fatal("No command specified.")
+	}
 
 	if *prefix != "" {
 		log.Printf("Searching for rules with env var prefix %v...\n", *prefix)
 		for _, e := range os.Environ() {
 			if envName, envValue, ok := strings.Cut(e, "="); ok {
 				if before, ruleName, ok := strings.Cut(envName, *prefix); ok && before == "" && ruleName != "" {
-					f, err := parseFilter(ruleName, envValue)
+					f, err := parseFilter(ruleName, envValue, *strict)
 					if err != nil {
+						if *strict {
+							log.Fatalf("Failed to parse rule %q: %v\n", envName, err)
+						}
 						log.Printf("Failed to parse rule %q: %v\n", envName, err)
 						continue
 					}
@@ -99,105 +229,158 @@ func main() {
 		}
 		log.Printf("Found %v rules defined by env vars.\n", len(filters))
 	}
+	if *rulesPath != "" {
+		fileFilters, err := loadRulesFile(*rulesPath, *strict)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		for _, f := range fileFilters {
+			log.Printf("Parsed rule %q: %q (%v)\n", f.name, f.regexp.String(), f.url)
+		}
+		log.Printf("Found %v rules defined in %q.\n", len(fileFilters), *rulesPath)
+		filters = append(filters, fileFilters...)
+	}
 
-	if err := run(); err != nil {
+	console, err := newOutput(*format, os.Stdout)
+	if err != nil {
 		log.Fatalln(err)
 	}
+	outputs = append(outputs, console)
+	if *findingsPath != "" {
+		f, err := os.Create(*findingsPath)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer f.Close()
+		outputs = append(outputs, newJSONLOutput(f))
+	}
 
-	if *successVar != "" {
-		fmt.Printf("##vso[task.setvariable variable=%v]true\n", *successVar)
+	if *testJSONFlag {
+		testJSON = newTestRun()
 	}
-}
 
-func parseFilter(name, envValue string) (*filter, error) {
-	var r rule
+	var result *runResult
+	var retries []*retry
+	for attempt := 1; ; attempt++ {
+		startAttempt(attempt)
+		result = run(flag.Args(), *timestamps)
+		if result.err == nil || result.signaled || attempt > *maxRetries {
+			break
+		}
+		rules := attemptMatches(func(f *filter) bool { return f.retry })
+		if len(rules) == 0 {
+			break
+		}
+		delay := *retryDelay << (attempt - 1)
+		retries = append(retries, &retry{
+			Attempt: attempt,
+			Error:   result.err.Error(),
+			Rules:   rules,
+			Delay:   delay.String(),
+		})
+		log.Printf("Attempt %v of %v failed: %v\n", attempt, *maxRetries+1, result.err)
+		log.Printf("Retrying in %v because retry rules matched: %v\n", delay, strings.Join(rules, ", "))
+		time.Sleep(delay)
+	}
 
-	if err := json.Unmarshal([]byte(envValue), &r); err != nil {
-		return nil, err
+	s := newSummary(retries)
+	if len(filters) > 0 || s.Redactions > 0 || testJSON != nil {
+		s.writeText(os.Stdout)
 	}
-	if r.Pattern == "" {
-		return nil, errors.New("rule defines no pattern")
+	if *summaryPath != "" {
+		if err := s.writeFile(*summaryPath); err != nil {
+			log.Fatalln(err)
+		}
 	}
-
-	exp, err := regexp.Compile(r.Pattern)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse rule regex: %v", err)
+	if *historyPath != "" {
+		if err := appendHistory(*historyPath, newHistoryRecords(time.Now(), os.Getenv("GO_BUILDER_NAME"), historyCommit())); err != nil {
+			log.Fatalf("Failed to write history: %v\n", err)
+		}
 	}
-
-	return &filter{
-		name:   name,
-		url:    r.URL,
-		regexp: exp,
-	}, nil
-}
-
-func run() error {
-	cmd := exec.Command(flag.Args()[0], flag.Args()[1:]...)
-	log.Printf("Running: %v\n", cmd)
-
-	// Wait for scans to complete before returning.
-	var wg sync.WaitGroup
-	wg.Add(2)
-	defer wg.Wait()
-
-	outPipeR, outPipeW, err := os.Pipe()
-	if err != nil {
-		return err
+	if result.err != nil {
+		log.Printf("Command failed: %v\n", result.err)
+		os.Exit(exitCode(result.err))
 	}
-	// Close the write side so the read side sees EOF.
-	// It's important that this happens before wg.Wait.
-	defer outPipeW.Close()
-
-	errPipeR, errPipeW, err := os.Pipe()
-	if err != nil {
-		return err
+	if result.scanErr != nil {
+		log.Fatalf("Command succeeded, but scanning its output failed: %v\n", result.scanErr)
 	}
-	defer errPipeW.Close()
-
-	cmd.Stdout = outPipeW
-	cmd.Stderr = errPipeW
-
-	if err := cmd.Start(); err != nil {
-		return err
+	if errorRules := attemptMatches(func(f *filter) bool { return f.severity == severityError }); len(errorRules) > 0 {
+		log.Fatalf("Failing because error severity rules matched: %v\n", strings.Join(errorRules, ", "))
 	}
 
-	go func() {
-		if err := scan(outPipeR, os.Stdout, os.Stdout); err != nil {
-			log.Fatalf("Failed to scan stdout pipe: %v\n", err)
-		}
-		outPipeR.Close()
-		wg.Done()
-	}()
-	go func() {
-		if err := scan(errPipeR, os.Stdout, os.Stderr); err != nil {
-			log.Fatalf("Failed to scan stderr pipe: %v\n", err)
+	if *successVar != "" {
+		if err := console.setVariable(*successVar, "true"); err != nil {
+			log.Fatalln(err)
 		}
-		errPipeR.Close()
-		wg.Done()
-	}()
+	}
+}
 
-	return cmd.Wait()
+// lineScanner matches the filters against the lines of one stream.
+type lineScanner struct {
+	stream string
+	// window is the most recent lines, up to the largest window of any filter.
+	window []string
+	// n is the number of lines scanned so far.
+	n int
+	// lastMatch is the line number of the last match of each filter. The lines up to the last
+	// match aren't matched again, so a multi-line match isn't reported again as the window moves.
+	lastMatch []int
 }
 
-func scan(r io.Reader, commands, echo *os.File) error {
-	s := bufio.NewScanner(r)
-	for s.Scan() {
-		fmt.Fprintf(echo, "%v\n", s.Text())
-		for _, f := range filters {
-			if f.regexp.MatchString(s.Text()) {
-				fmt.Fprintf(echo, "Found pattern '%v'\n", f.regexp)
-				fmt.Fprintf(commands, warn(&f, s.Text()))
-			}
+// phase returns the name of the phase that starts at line, if a phase rule matches it.
+func (ls *lineScanner) phase(line string) (string, bool) {
+	for i := range filters {
+		f := &filters[i]
+		if !f.phase || !f.matchesStream(ls.stream) {
+			continue
+		}
+		if fd, ok := f.match(line); ok {
+			return fd.Message, true
 		}
 	}
-	return s.Err()
+	return "", false
 }
 
-func warn(f *filter, line string) string {
-	var issueLink string
-	if f.url != "" {
-		issueLink = " (" + f.url + ")"
+func newLineScanner(stream string) *lineScanner {
+	size := 1
+	for _, f := range filters {
+		size = max(size, f.lines)
+	}
+	return &lineScanner{
+		stream:    stream,
+		window:    make([]string, 0, size),
+		lastMatch: make([]int, len(filters)),
 	}
+}
 
-	return fmt.Sprintf("##vso[task.logissue type=warning]%q%v: %v\n", f.name, issueLink, line)
+// add adds a line to the window and calls report for each filter that matches. Returns the first
+// error returned by report.
+func (ls *lineScanner) add(line string, report func(f *filter, fd *finding) error) error {
+	if len(ls.window) == cap(ls.window) {
+		ls.window = append(ls.window[:0], ls.window[1:]...)
+	}
+	ls.window = append(ls.window, line)
+	ls.n++
+	for i := range filters {
+		f := &filters[i]
+		if f.phase || !f.matchesStream(ls.stream) {
+			continue
+		}
+		text := line
+		if f.lines > 1 {
+			lines := min(f.lines, len(ls.window), ls.n-ls.lastMatch[i])
+			text = strings.Join(ls.window[len(ls.window)-lines:], "\n")
+		}
+		if fd, ok := f.match(text); ok {
+			ls.lastMatch[i] = ls.n
+			fd.Attempt = currentAttempt
+			fd.Stream = ls.stream
+			fd.LineNumber = ls.n
+			fd.Line = line
+			if err := report(f, fd); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
@@ -0,0 +1,261 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const historyDescription = `
+This command reads a history file written by cmdscan's '-history' flag and shows, for each rule,
+the number of runs that had the rule, the number of those runs it matched in, the total number of
+matches, and the first and last time it matched, with the commit and builder.
+
+Rules that had no matches in the last '-stale' runs that had the rule are listed as candidates for
+removal: the issue they track may be fixed.
+`
+
+// historyRecord is the result of one rule in one cmdscan run. It is the format of the lines in the
+// '-history' file. Each run appends a record for every rule, even if it didn't match, so the
+// history shows how long it has been since a rule matched.
+type historyRecord struct {
+	Time time.Time `json:"time"`
+	// Builder is the GO_BUILDER_NAME of the run, like "linux-amd64-longtest".
+	Builder string `json:"builder"`
+	Commit  string `json:"commit"`
+	Rule    string `json:"rule"`
+	// Matches counts all matches in the run, in all attempts.
+	Matches int `json:"matches"`
+}
+
+// newHistoryRecords returns a record for each rule that reports issues, for the run that ended at t.
+func newHistoryRecords(t time.Time, builder, commit string) []*historyRecord {
+	var records []*historyRecord
+	for i := range filters {
+		f := &filters[i]
+		if f.phase {
+			continue
+		}
+		records = append(records, &historyRecord{
+			Time:    t,
+			Builder: builder,
+			Commit:  commit,
+			Rule:    f.name,
+			Matches: f.occurrences,
+		})
+	}
+	return records
+}
+
+// historyCommit returns the commit being built, from the CI system or from git. Returns "" if it
+// can't be found.
+func historyCommit() string {
+	if c := cmp.Or(os.Getenv("BUILD_SOURCEVERSION"), os.Getenv("GITHUB_SHA")); c != "" {
+		return c
+	}
+	out, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// appendHistory appends the records to the history file at path, creating it if necessary. The
+// records are written with one write so runs sharing a file don't interleave their lines.
+func appendHistory(path string, records []*historyRecord) error {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o666)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readHistory reads the records in a history file, in the order they were written.
+func readHistory(r io.Reader) ([]*historyRecord, error) {
+	var records []*historyRecord
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1024*1024)
+	for n := 1; s.Scan(); n++ {
+		if strings.TrimSpace(s.Text()) == "" {
+			continue
+		}
+		var rec historyRecord
+		if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("line %v: %v", n, err)
+		}
+		records = append(records, &rec)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// ruleHistory is the history of one rule.
+type ruleHistory struct {
+	Rule string
+	// Runs is the number of runs that had the rule, and Matched is how many of them it matched in.
+	Runs    int
+	Matched int
+	Matches int
+	// First and Last are the first and last runs the rule matched in, or nil if it never matched.
+	First *historyRecord
+	Last  *historyRecord
+	// Stale is true if the rule had no matches in at least the last N runs, where N is the
+	// '-stale' value.
+	Stale bool
+}
+
+// summarizeHistory returns the history of each rule in records, sorted by rule name. Only records
+// from builder are counted, unless it is "". A rule is stale if it didn't match in its last stale
+// runs.
+func summarizeHistory(records []*historyRecord, builder string, stale int) []*ruleHistory {
+	records = slices.Clone(records)
+	slices.SortStableFunc(records, func(a, b *historyRecord) int { return a.Time.Compare(b.Time) })
+
+	byRule := make(map[string]*ruleHistory)
+	// sinceMatch is the number of runs since each rule last matched.
+	sinceMatch := make(map[string]int)
+	for _, r := range records {
+		if builder != "" && r.Builder != builder {
+			continue
+		}
+		h, ok := byRule[r.Rule]
+		if !ok {
+			h = &ruleHistory{Rule: r.Rule}
+			byRule[r.Rule] = h
+		}
+		h.Runs++
+		if r.Matches == 0 {
+			sinceMatch[r.Rule]++
+			continue
+		}
+		sinceMatch[r.Rule] = 0
+		h.Matched++
+		h.Matches += r.Matches
+		if h.First == nil {
+			h.First = r
+		}
+		h.Last = r
+	}
+
+	var rules []*ruleHistory
+	for name, h := range byRule {
+		h.Stale = stale > 0 && sinceMatch[name] >= stale
+		rules = append(rules, h)
+	}
+	slices.SortFunc(rules, func(a, b *ruleHistory) int { return strings.Compare(a.Rule, b.Rule) })
+	return rules
+}
+
+// writeHistory writes a table of the rules' histories, then the stale rules.
+func writeHistory(w io.Writer, rules []*ruleHistory, stale int) error {
+	if len(rules) == 0 {
+		_, err := fmt.Fprintf(w, "No rules in the history.\n")
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Rule\tRuns\tMatched\tMatches\tFirst seen\tLast seen\n")
+	for _, h := range rules {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\n", h.Rule, h.Runs, h.Matched, h.Matches, seen(h.First), seen(h.Last))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	var staleRules []string
+	for _, h := range rules {
+		if h.Stale {
+			staleRules = append(staleRules, h.Rule)
+		}
+	}
+	if len(staleRules) > 0 {
+		if _, err := fmt.Fprintf(w, "\nCandidates for removal, no matches in the last %v runs:\n", stale); err != nil {
+			return err
+		}
+		for _, name := range staleRules {
+			if _, err := fmt.Fprintf(w, "  %v\n", name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// seen describes the run a rule matched in.
+func seen(r *historyRecord) string {
+	if r == nil {
+		return "never"
+	}
+	s := r.Time.UTC().Format(time.DateTime)
+	if r.Commit != "" {
+		s += " " + r.Commit[:min(len(r.Commit), 12)]
+	}
+	if r.Builder != "" {
+		s += " (" + r.Builder + ")"
+	}
+	return s
+}
+
+func runHistory(args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	help := fs.Bool("h", false, "Print this help message.")
+	historyPath := fs.String("history", "", "The history file to read.")
+	builder := fs.String("builder", "", "Only count runs on this builder, a GO_BUILDER_NAME value.")
+	stale := fs.Int("stale", 20, "List rules with no matches in this many of their latest runs as candidates for removal. 0 disables it.")
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of cmdscan history:\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "%s\n", historyDescription)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if *help {
+		fs.Usage()
+		return
+	}
+	if *historyPath == "" {
+		fs.Usage()
+		log.Fatal("No '-history' file specified.")
+	}
+
+	f, err := os.Open(*historyPath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	records, err := readHistory(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("Failed to read %v: %v\n", *historyPath, err)
+	}
+	if err := writeHistory(os.Stdout, summarizeHistory(records, *builder, *stale), *stale); err != nil {
+		log.Fatalln(err)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	defer func(old []filter) { filters = old }(filters)
+	filters = nil
+	for _, r := range []rule{
+		{Name: "Denied", Pattern: `denied`},
+		{Name: "Old", Pattern: `old`},
+		{Name: "Phase", Pattern: `^#### (?P<phase>.*)`, Message: "${phase}", Phase: true},
+	} {
+		f, err := newFilter(r.Name, &r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filters = append(filters, *f)
+	}
+
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	// Each run matches "Denied" as many times as its index, and only the first matches "Old".
+	for i, builder := range []string{"linux-amd64", "windows-amd64", "linux-amd64", "linux-amd64"} {
+		filters[0].occurrences = i
+		filters[1].occurrences = 0
+		if i == 0 {
+			filters[1].occurrences = 1
+		}
+		records := newHistoryRecords(start.Add(time.Duration(i)*time.Hour), builder, "0123456789abcdef")
+		if len(records) != 2 {
+			t.Fatalf("newHistoryRecords returned %v records, want 2, without the phase rule", len(records))
+		}
+		if err := appendHistory(path, records); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := readHistory(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 8 {
+		t.Fatalf("readHistory returned %v records, want 8", len(records))
+	}
+
+	var b strings.Builder
+	if err := writeHistory(&b, summarizeHistory(records, "", 3), 3); err != nil {
+		t.Fatal(err)
+	}
+	want := `Rule    Runs  Matched  Matches  First seen                                        Last seen
+Denied  4     3        6        2026-10-01 13:00:00 0123456789ab (windows-amd64)  2026-10-01 15:00:00 0123456789ab (linux-amd64)
+Old     4     1        1        2026-10-01 12:00:00 0123456789ab (linux-amd64)    2026-10-01 12:00:00 0123456789ab (linux-amd64)
+
+Candidates for removal, no matches in the last 3 runs:
+  Old
+`
+	if got := b.String(); got != want {
+		t.Errorf("writeHistory:\n%v\nwant:\n%v", got, want)
+	}
+
+	// On linux-amd64 alone, "Old" has only missed 2 runs.
+	rules := summarizeHistory(records, "linux-amd64", 3)
+	if len(rules) != 2 {
+		t.Fatalf("summarizeHistory returned %v rules, want 2", len(rules))
+	}
+	if h := rules[0]; h.Rule != "Denied" || h.Runs != 3 || h.Matched != 2 || h.Matches != 5 || h.First.Builder != "linux-amd64" {
+		t.Errorf("Denied on linux-amd64 = %+v", h)
+	}
+	if h := rules[1]; h.Rule != "Old" || h.Stale {
+		t.Errorf("Old on linux-amd64 = %+v, want not stale", h)
+	}
+}
+
+func TestReadHistoryError(t *testing.T) {
+	_, err := readHistory(strings.NewReader(`{"rule": "A", "matches": 1}` + "\n\nnot json\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "line 3: ") {
+		t.Errorf("readHistory error = %v, want an error for line 3", err)
+	}
+}
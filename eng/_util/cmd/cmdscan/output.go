@@ -0,0 +1,254 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// finding is a rule match. It is also the format of the records in the '-findings' file.
+type finding struct {
+	Time     time.Time `json:"time"`
+	Rule     string    `json:"rule"`
+	Severity string    `json:"severity"`
+	URL      string    `json:"url,omitempty"`
+	Message  string    `json:"message"`
+	// Attempt is the attempt to run the command the match was found in, starting at 1.
+	Attempt int `json:"attempt"`
+	// Stream is the stream the match was found in, "stdout" or "stderr".
+	Stream string `json:"stream"`
+	// LineNumber is the line number in Stream of the last line of the match, and Line is its text.
+	LineNumber int    `json:"lineNumber"`
+	Line       string `json:"line"`
+	// SourceFile and SourceLine are from the "file" and "line" capture groups of the pattern, if
+	// present. CI systems use them to link the issue to the code.
+	SourceFile string `json:"sourceFile,omitempty"`
+	SourceLine string `json:"sourceLine,omitempty"`
+	// Details is more information, on multiple lines, like the output of a failed test.
+	Details string `json:"details,omitempty"`
+	// KnownIssues are the rules that matched the output of a failed test.
+	KnownIssues []string `json:"knownIssues,omitempty"`
+}
+
+// text returns the message followed by the details, if any.
+func (f *finding) text() string {
+	if f.Details == "" {
+		return f.Message
+	}
+	return f.Message + "\n" + f.Details
+}
+
+// output reports findings and variables in a format understood by a CI system or a dev.
+type output interface {
+	// issue reports a finding.
+	issue(f *finding) error
+	// setVariable makes a variable available to later steps of the job.
+	setVariable(name, value string) error
+	// startGroup starts a collapsible section of the log. Sections can't be nested.
+	startGroup(name string) error
+	// endGroup ends the section started by startGroup.
+	endGroup() error
+}
+
+const (
+	formatAuto   = "auto"
+	formatAzDO   = "azdo"
+	formatGitHub = "github"
+	formatPlain  = "plain"
+)
+
+// newOutput returns the output for a '-format' value. "auto" detects the CI system from the
+// environment, and falls back to plain text.
+func newOutput(format string, w io.Writer) (output, error) {
+	if format == formatAuto {
+		switch {
+		case strings.EqualFold(os.Getenv("TF_BUILD"), "true"):
+			format = formatAzDO
+		case os.Getenv("GITHUB_ACTIONS") == "true":
+			format = formatGitHub
+		default:
+			format = formatPlain
+		}
+	}
+	switch format {
+	case formatAzDO:
+		return azdoOutput{w}, nil
+	case formatGitHub:
+		return githubOutput{w: w, outputPath: os.Getenv("GITHUB_OUTPUT")}, nil
+	case formatPlain:
+		return plainOutput{w}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q, must be %q, %q, %q, or %q", format, formatAuto, formatAzDO, formatGitHub, formatPlain)
+}
+
+// azdoOutput uses AzDO logging commands:
+// https://learn.microsoft.com/en-us/azure/devops/pipelines/scripts/logging-commands
+type azdoOutput struct {
+	w io.Writer
+}
+
+func (o azdoOutput) issue(f *finding) error {
+	var props string
+	if f.SourceFile != "" {
+		props += ";sourcepath=" + azdoEscapeProperty(f.SourceFile)
+		if f.SourceLine != "" {
+			props += ";linenumber=" + azdoEscapeProperty(f.SourceLine)
+		}
+	}
+	_, err := fmt.Fprintf(o.w, "##vso[task.logissue type=%v%v]%q%v: %v\n", f.Severity, props, f.Rule, issueLink(f), azdoEscapeData(f.text()))
+	return err
+}
+
+func (o azdoOutput) setVariable(name, value string) error {
+	_, err := fmt.Fprintf(o.w, "##vso[task.setvariable variable=%v]%v\n", name, azdoEscapeData(value))
+	return err
+}
+
+func (o azdoOutput) startGroup(name string) error {
+	_, err := fmt.Fprintf(o.w, "##[group]%v\n", name)
+	return err
+}
+
+func (o azdoOutput) endGroup() error {
+	_, err := fmt.Fprintf(o.w, "##[endgroup]\n")
+	return err
+}
+
+func azdoEscapeData(s string) string {
+	return strings.NewReplacer("%", "%AZP25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+func azdoEscapeProperty(s string) string {
+	return strings.NewReplacer("%", "%AZP25", "\r", "%0D", "\n", "%0A", "]", "%5D", ";", "%3B").Replace(s)
+}
+
+// githubOutput uses GitHub Actions workflow commands:
+// https://docs.github.com/en/actions/writing-workflows/choosing-what-your-workflow-does/workflow-commands-for-github-actions
+type githubOutput struct {
+	w io.Writer
+	// outputPath is the file that sets step outputs, $GITHUB_OUTPUT.
+	outputPath string
+}
+
+func (o githubOutput) issue(f *finding) error {
+	props := []string{"title=" + githubEscapeProperty(f.Rule)}
+	if f.SourceFile != "" {
+		props = append(props, "file="+githubEscapeProperty(f.SourceFile))
+		if f.SourceLine != "" {
+			props = append(props, "line="+githubEscapeProperty(f.SourceLine))
+		}
+	}
+	_, err := fmt.Fprintf(o.w, "::%v %v::%v\n", f.Severity, strings.Join(props, ","), githubEscapeData(fmt.Sprintf("%q%v: %v", f.Rule, issueLink(f), f.text())))
+	return err
+}
+
+// setVariable sets a step output, available to later steps as "steps.<id>.outputs.<name>".
+func (o githubOutput) setVariable(name, value string) error {
+	if o.outputPath == "" {
+		return fmt.Errorf("unable to set step output %q: GITHUB_OUTPUT is not set", name)
+	}
+	f, err := os.OpenFile(o.outputPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o666)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%v=%v\n", name, value); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (o githubOutput) startGroup(name string) error {
+	_, err := fmt.Fprintf(o.w, "::group::%v\n", githubEscapeData(name))
+	return err
+}
+
+func (o githubOutput) endGroup() error {
+	_, err := fmt.Fprintf(o.w, "::endgroup::\n")
+	return err
+}
+
+func githubEscapeData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+func githubEscapeProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+// plainOutput writes findings as plain text, for local runs.
+type plainOutput struct {
+	w io.Writer
+}
+
+func (o plainOutput) issue(f *finding) error {
+	var source string
+	if f.SourceFile != "" {
+		source = f.SourceFile
+		if f.SourceLine != "" {
+			source += ":" + f.SourceLine
+		}
+		source += ": "
+	}
+	if _, err := fmt.Fprintf(o.w, "cmdscan %v: %v%q%v: %v\n", f.Severity, source, f.Rule, issueLink(f), f.Message); err != nil {
+		return err
+	}
+	if f.Details != "" {
+		_, err := fmt.Fprintf(o.w, "    %v\n", strings.ReplaceAll(f.Details, "\n", "\n    "))
+		return err
+	}
+	return nil
+}
+
+func (o plainOutput) setVariable(name, value string) error {
+	_, err := fmt.Fprintf(o.w, "cmdscan: %v=%v\n", name, value)
+	return err
+}
+
+func (o plainOutput) startGroup(name string) error {
+	_, err := fmt.Fprintf(o.w, "cmdscan phase: %v\n", name)
+	return err
+}
+
+func (o plainOutput) endGroup() error {
+	return nil
+}
+
+// jsonlOutput writes each finding as a line of JSON, for other tools to process.
+type jsonlOutput struct {
+	enc *json.Encoder
+}
+
+func newJSONLOutput(w io.Writer) jsonlOutput {
+	return jsonlOutput{json.NewEncoder(w)}
+}
+
+func (o jsonlOutput) issue(f *finding) error {
+	return o.enc.Encode(f)
+}
+
+// setVariable is a no-op: the findings file is only for findings.
+func (o jsonlOutput) setVariable(name, value string) error {
+	return nil
+}
+
+func (o jsonlOutput) startGroup(name string) error {
+	return nil
+}
+
+func (o jsonlOutput) endGroup() error {
+	return nil
+}
+
+func issueLink(f *finding) string {
+	if f.URL == "" {
+		return ""
+	}
+	return " (" + f.URL + ")"
+}
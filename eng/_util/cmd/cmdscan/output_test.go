@@ -0,0 +1,93 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOutputs(t *testing.T) {
+	f, err := newFilter("Vet", &rule{Pattern: `(?P<file>\S+\.go):(?P<line>\d+):\d+: (?P<msg>.*)`, Message: "${msg}", URL: "https://example.com", Severity: severityError})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, ok := f.match("src/a.go:12:3: 100% wrong, really")
+	if !ok {
+		t.Fatal("no match")
+	}
+	if fd.SourceFile != "src/a.go" || fd.SourceLine != "12" {
+		t.Errorf("got source %q:%q, want src/a.go:12", fd.SourceFile, fd.SourceLine)
+	}
+
+	tests := []struct {
+		format, want string
+	}{
+		{formatAzDO, `##vso[task.logissue type=error;sourcepath=src/a.go;linenumber=12]"Vet" (https://example.com): 100%AZP25 wrong, really` + "\n"},
+		{formatGitHub, `::error title=Vet,file=src/a.go,line=12::"Vet" (https://example.com): 100%25 wrong, really` + "\n"},
+		{formatPlain, `cmdscan error: src/a.go:12: "Vet" (https://example.com): 100% wrong, really` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var b strings.Builder
+			o, err := newOutput(tt.format, &b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := o.issue(fd); err != nil {
+				t.Fatal(err)
+			}
+			if got := b.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutputAuto(t *testing.T) {
+	t.Setenv("TF_BUILD", "")
+	t.Setenv("GITHUB_ACTIONS", "")
+	if o, _ := newOutput(formatAuto, nil); o != (plainOutput{}) {
+		t.Errorf("got %#v, want plain", o)
+	}
+	t.Setenv("GITHUB_ACTIONS", "true")
+	if _, ok := mustOutput(t, formatAuto).(githubOutput); !ok {
+		t.Error("want github output with GITHUB_ACTIONS=true")
+	}
+	t.Setenv("TF_BUILD", "True")
+	if _, ok := mustOutput(t, formatAuto).(azdoOutput); !ok {
+		t.Error("want azdo output with TF_BUILD=True")
+	}
+	if _, err := newOutput("teamcity", nil); err == nil {
+		t.Error("want error for unknown format")
+	}
+}
+
+func TestGitHubSetVariable(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "output")
+	t.Setenv("GITHUB_OUTPUT", p)
+	o := mustOutput(t, formatGitHub)
+	for _, name := range []string{"A", "B"} {
+		if err := o.setVariable(name, "true"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "A=true\nB=true\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func mustOutput(t *testing.T, format string) output {
+	o, err := newOutput(format, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
@@ -0,0 +1,56 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import "time"
+
+// phase is a part of the command's output that starts with a line matched by a phase rule and
+// ends at the next phase or when the command exits.
+type phase struct {
+	Name    string    `json:"name"`
+	Attempt int       `json:"attempt"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+}
+
+func (p *phase) duration() time.Duration {
+	return p.End.Sub(p.Start)
+}
+
+var (
+	// phases are the phases found so far, in order.
+	phases []*phase
+	// openPhase is the phase in progress, if any.
+	openPhase *phase
+)
+
+// startPhase ends the phase in progress, if any, and starts a group for a new phase in each output.
+func startPhase(name string, t time.Time) error {
+	if err := endPhase(t); err != nil {
+		return err
+	}
+	openPhase = &phase{Name: name, Attempt: currentAttempt, Start: t}
+	phases = append(phases, openPhase)
+	for _, o := range outputs {
+		if err := o.startGroup(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// endPhase ends the phase in progress, if any.
+func endPhase(t time.Time) error {
+	if openPhase == nil {
+		return nil
+	}
+	openPhase.End = t
+	openPhase = nil
+	for _, o := range outputs {
+		if err := o.endGroup(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// redacted replaces each secret found in the output.
+const redacted = "***"
+
+// minSecretLen is the shortest env var value that is redacted. Shorter values, like "1" or "true",
+// are more likely to be flags than secrets, and would redact much of the output.
+const minSecretLen = 4
+
+// redactor removes secrets from lines of output before cmdscan echoes or scans them.
+type redactor struct {
+	// values are secrets to replace wherever they appear, longest first.
+	values []string
+	// patterns find secrets. If a pattern has a "secret" capture group, only that part of the match
+	// is replaced.
+	patterns []*regexp.Regexp
+	// minEntropy is the Shannon entropy, in bits per character, above which a token is considered a
+	// secret. 0 disables the check.
+	minEntropy float64
+
+	// count is the number of secrets replaced so far.
+	count int
+}
+
+// addValue adds a secret value, ignoring values too short to be secrets.
+func (r *redactor) addValue(v string) {
+	v = strings.TrimSpace(v)
+	if len(v) < minSecretLen || slices.Contains(r.values, v) {
+		return
+	}
+	r.values = append(r.values, v)
+	// Replace longer values first, in case one secret contains another.
+	slices.SortFunc(r.values, func(a, b string) int { return len(b) - len(a) })
+}
+
+// token matches a run of characters that may be an API key, password, or other token. Tokens
+// made of several parts, like JWTs, are checked part by part.
+var token = regexp.MustCompile(`[A-Za-z0-9+/_\-]{20,}=*`)
+
+// redact returns line with each secret replaced by "***".
+func (r *redactor) redact(line string) string {
+	for _, v := range r.values {
+		if n := strings.Count(line, v); n > 0 {
+			r.count += n
+			line = strings.ReplaceAll(line, v, redacted)
+		}
+	}
+	for _, p := range r.patterns {
+		line = r.replace(line, p)
+	}
+	if r.minEntropy > 0 {
+		line = token.ReplaceAllStringFunc(line, func(t string) string {
+			if !looksRandom(t, r.minEntropy) {
+				return t
+			}
+			r.count++
+			return redacted
+		})
+	}
+	return line
+}
+
+// replace replaces each match of p in line, or only the "secret" group of each match if p has one.
+func (r *redactor) replace(line string, p *regexp.Regexp) string {
+	g := max(p.SubexpIndex("secret"), 0)
+	var b strings.Builder
+	last := 0
+	for _, loc := range p.FindAllStringSubmatchIndex(line, -1) {
+		start, end := loc[2*g], loc[2*g+1]
+		if start < 0 || start == end {
+			continue
+		}
+		b.WriteString(line[last:start])
+		b.WriteString(redacted)
+		last = end
+		r.count++
+	}
+	if last == 0 {
+		return line
+	}
+	b.WriteString(line[last:])
+	return b.String()
+}
+
+// looksRandom returns whether t has at least minEntropy bits of entropy per character and contains
+// both letters and digits, which long words and identifiers rarely do.
+func looksRandom(t string, minEntropy float64) bool {
+	if !strings.ContainsAny(t, "0123456789") || !strings.ContainsFunc(t, isLetter) {
+		return false
+	}
+	return entropy(t) >= minEntropy
+}
+
+func isLetter(r rune) bool {
+	return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z'
+}
+
+// entropy returns the Shannon entropy of the characters in s, in bits per character.
+func entropy(s string) float64 {
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+	var e float64
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / float64(len(s))
+		e -= p * math.Log2(p)
+	}
+	return e
+}
@@ -0,0 +1,41 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	var r redactor
+	r.addValue("hunter22")
+	r.addValue("hunter22-long")
+	r.addValue("1") // Too short to redact.
+	r.patterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)bearer (?P<secret>\S+)`),
+		regexp.MustCompile(`ghp_[A-Za-z0-9]{10}`),
+	}
+	r.minEntropy = 4.5
+
+	tests := []struct{ in, want string }{
+		{"password=hunter22-long, again hunter22", "password=***, again ***"},
+		{"Authorization: Bearer abc.def; bearer xyz", "Authorization: Bearer *** bearer ***"},
+		{"token ghp_0123456789 end", "token *** end"},
+		{"key=Zx8pQ2rT5vW9yB3nM6kL1jH4gF7dS0aE", "key=***"},
+		// Not random enough: commit hashes, paths, and long words.
+		{"commit 2b28fac0b2e1c4d9e5f6a7b8c9d0e1f2a3b4c5d6", "commit 2b28fac0b2e1c4d9e5f6a7b8c9d0e1f2a3b4c5d6"},
+		{"ok  	github.com/microsoft/go/_util/cmd/cmdscan	0.006s", "ok  	github.com/microsoft/go/_util/cmd/cmdscan	0.006s"},
+		{"TestRedactSecretsFromOutputWithoutDigits", "TestRedactSecretsFromOutputWithoutDigits"},
+		{"1 is fine", "1 is fine"},
+	}
+	for _, tt := range tests {
+		if got := r.redact(tt.in); got != tt.want {
+			t.Errorf("redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	if r.count != 6 {
+		t.Errorf("count = %v, want 6", r.count)
+	}
+}
@@ -0,0 +1,293 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type rule struct {
+	// Name is only used in rule files. Env var rules are named after the env var.
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	URL     string `json:"url"`
+	// Severity is "warning" (default) or "error".
+	Severity string `json:"severity"`
+	// Stream is "stdout", "stderr", or "both" (default).
+	Stream string `json:"stream"`
+	// MaxOccurrences is the number of matches to report. Later matches are only counted. 0 means
+	// no limit.
+	MaxOccurrences int `json:"maxOccurrences"`
+	// Description explains the issue in the cmdscan log when the rule matches.
+	Description string `json:"description"`
+	// Lines is the number of consecutive lines the pattern is matched against, joined by "\n".
+	// The default is 1.
+	Lines int `json:"lines"`
+	// Retry makes cmdscan run the command again if it fails after a match.
+	Retry bool `json:"retry"`
+	// Phase makes the rule mark the start of a phase of the command rather than an issue. The phase
+	// is named after the message.
+	Phase bool `json:"phase"`
+	// Message is a template for the issue message that may refer to the pattern's capture groups,
+	// like "$1" or "${test}". By default, the message is the matching line, or the matching text
+	// for multi-line rules.
+	Message string `json:"message"`
+}
+
+// rulesFile is the content of a file passed to "-rules".
+type rulesFile struct {
+	Rules []json.RawMessage `json:"rules"`
+}
+
+// maxLines limits the window of a multi-line rule, to limit the memory and time spent matching.
+const maxLines = 1000
+
+const (
+	severityWarning = "warning"
+	severityError   = "error"
+
+	streamStdout = "stdout"
+	streamStderr = "stderr"
+	streamBoth   = "both"
+)
+
+type filter struct {
+	// name is a simple description of the detected error that should be uniquely searchable so
+	// runfo can keep track of this issue with "contains" searches on each timeline element.
+	name string
+	// url is an optional URL that links to more information about this error.
+	url string
+	// regexp is the regex that is matched against each line of output to find an issue.
+	regexp *regexp.Regexp
+
+	severity       string
+	stream         string
+	maxOccurrences int
+	description    string
+	lines          int
+	message        string
+	retry          bool
+	phase          bool
+
+	// occurrences is the number of matches so far, and attemptOccurrences is the number of matches
+	// in the current attempt to run the command.
+	occurrences, attemptOccurrences int
+	// first and last are the first and last matches.
+	first, last *finding
+}
+
+// record counts a match and returns whether to report it, which is false if the rule has already
+// been reported maxOccurrences times.
+func (f *filter) record(fd *finding) bool {
+	f.occurrences++
+	f.attemptOccurrences++
+	if f.first == nil {
+		f.first = fd
+	}
+	f.last = fd
+	return f.maxOccurrences == 0 || f.occurrences <= f.maxOccurrences
+}
+
+// matchesStream returns whether the filter applies to the given stream, "stdout" or "stderr".
+func (f *filter) matchesStream(stream string) bool {
+	return f.stream == streamBoth || f.stream == stream
+}
+
+func parseFilter(name, envValue string, strict bool) (*filter, error) {
+	var r rule
+	if err := decodeJSON([]byte(envValue), &r, strict); err != nil {
+		return nil, err
+	}
+	return newFilter(name, &r)
+}
+
+func newFilter(name string, r *rule) (*filter, error) {
+	if r.Pattern == "" {
+		return nil, errors.New("rule defines no pattern")
+	}
+
+	exp, err := regexp.Compile(r.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse rule regex: %v", err)
+	}
+
+	f := &filter{
+		name:           name,
+		url:            r.URL,
+		regexp:         exp,
+		severity:       r.Severity,
+		stream:         r.Stream,
+		maxOccurrences: r.MaxOccurrences,
+		description:    r.Description,
+		lines:          r.Lines,
+		message:        r.Message,
+		retry:          r.Retry,
+		phase:          r.Phase,
+	}
+	switch f.severity {
+	case "":
+		f.severity = severityWarning
+	case severityWarning, severityError:
+	default:
+		return nil, fmt.Errorf("invalid severity %q, must be %q or %q", f.severity, severityWarning, severityError)
+	}
+	switch f.stream {
+	case "":
+		f.stream = streamBoth
+	case streamStdout, streamStderr, streamBoth:
+	default:
+		return nil, fmt.Errorf("invalid stream %q, must be %q, %q, or %q", f.stream, streamStdout, streamStderr, streamBoth)
+	}
+	if f.maxOccurrences < 0 {
+		return nil, fmt.Errorf("invalid maxOccurrences %v, must not be negative", f.maxOccurrences)
+	}
+	switch {
+	case f.lines == 0:
+		f.lines = 1
+	case f.lines < 0 || f.lines > maxLines:
+		return nil, fmt.Errorf("invalid lines %v, must be between 1 and %v", f.lines, maxLines)
+	}
+	if f.phase && f.lines != 1 {
+		return nil, fmt.Errorf("phase rules must match one line, found lines %v", f.lines)
+	}
+	if err := checkTemplate(f.message, exp); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// templateRef matches a reference to a capture group in a message template. See regexp.Expand.
+var templateRef = regexp.MustCompile(`\$(?:\{(\w+)\}|(\w+))`)
+
+// checkTemplate returns an error if the message template t refers to a group that isn't in exp,
+// which regexp.Expand would silently replace with an empty string.
+func checkTemplate(t string, exp *regexp.Regexp) error {
+	for _, m := range templateRef.FindAllStringSubmatch(strings.ReplaceAll(t, "$$", ""), -1) {
+		name := m[1] + m[2]
+		if n, err := strconv.Atoi(name); err == nil {
+			if n > exp.NumSubexp() {
+				return fmt.Errorf("message refers to group %v, but the pattern has %v groups", n, exp.NumSubexp())
+			}
+		} else if exp.SubexpIndex(name) < 0 {
+			return fmt.Errorf("message refers to group %q, which is not in the pattern", name)
+		}
+	}
+	return nil
+}
+
+// match returns a finding if the filter's pattern matches text, a line or, for multi-line rules,
+// the lines of the window joined by "\n". The caller fills in where the match was found.
+func (f *filter) match(text string) (*finding, bool) {
+	loc := f.regexp.FindStringSubmatchIndex(text)
+	if loc == nil {
+		return nil, false
+	}
+	var message string
+	switch {
+	case f.message != "":
+		message = string(f.regexp.ExpandString(nil, f.message, text, loc))
+	case f.lines == 1:
+		message = text
+	default:
+		message = text[loc[0]:loc[1]]
+	}
+	return &finding{
+		Rule:     f.name,
+		Severity: f.severity,
+		URL:      f.url,
+		// An issue message must be on one line.
+		Message:    strings.ReplaceAll(strings.TrimSpace(message), "\n", " | "),
+		SourceFile: group(f.regexp, "file", text, loc),
+		SourceLine: group(f.regexp, "line", text, loc),
+	}, true
+}
+
+// group returns the text matched by the named capture group, or "" if there is no such group.
+func group(exp *regexp.Regexp, name, text string, loc []int) string {
+	i := exp.SubexpIndex(name)
+	if i < 0 || loc[2*i] < 0 {
+		return ""
+	}
+	return text[loc[2*i]:loc[2*i+1]]
+}
+
+// loadRulesFile reads the rules in a YAML or JSON file. Returns an error if the file can't be
+// parsed. An invalid rule is logged and skipped, unless strict is true, in which case it is also an
+// error. In strict mode, unknown fields are errors too, to catch typos.
+func loadRulesFile(path string, strict bool) ([]filter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yml" || ext == ".yaml" {
+		v, err := parseYAML(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse rules file %q: %v", path, err)
+		}
+		// Use the same decoding (and strictness) as JSON files.
+		if data, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+	var rf rulesFile
+	if err := decodeJSON(data, &rf, strict); err != nil {
+		return nil, fmt.Errorf("failed to parse rules file %q: %v", path, err)
+	}
+
+	var filters []filter
+	names := make(map[string]struct{})
+	for i, raw := range rf.Rules {
+		f, err := parseFileRule(raw, strict)
+		if err == nil {
+			if _, ok := names[f.name]; ok {
+				err = fmt.Errorf("duplicate rule name %q", f.name)
+			}
+		}
+		if err != nil {
+			err = fmt.Errorf("rule %v in %q: %v", i, path, err)
+			if strict {
+				return nil, err
+			}
+			log.Printf("Failed to parse %v\n", err)
+			continue
+		}
+		names[f.name] = struct{}{}
+		filters = append(filters, *f)
+	}
+	return filters, nil
+}
+
+func parseFileRule(raw json.RawMessage, strict bool) (*filter, error) {
+	var r rule
+	if err := decodeJSON(raw, &r, strict); err != nil {
+		return nil, err
+	}
+	if r.Name == "" {
+		return nil, errors.New("rule defines no name")
+	}
+	return newFilter(r.Name, &r)
+}
+
+func decodeJSON(data []byte, v any, strict bool) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	if strict {
+		d.DisallowUnknownFields()
+	}
+	if err := d.Decode(v); err != nil {
+		return err
+	}
+	if d.More() {
+		return errors.New("unexpected content after JSON value")
+	}
+	return nil
+}
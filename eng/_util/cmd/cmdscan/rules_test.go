@@ -0,0 +1,195 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name, in string
+		want     any
+	}{
+		{"scalars", "a: x\nb: 'it''s # not a comment'\nc: \"q\\tq\"\nd: 3\ne: true\nf: ~ # comment\n", map[string]any{
+			"a": "x", "b": "it's # not a comment", "c": "q\tq", "d": 3.0, "e": true, "f": nil,
+		}},
+		{"sequence of maps", "---\nrules:\n  - name: a\n    n: 1\n  # comment\n  - name: b\n", map[string]any{
+			"rules": []any{map[string]any{"name": "a", "n": 1.0}, map[string]any{"name": "b"}},
+		}},
+		{"sequence at key indentation", "rules:\n- a\n- b\nnext: c\n", map[string]any{
+			"rules": []any{"a", "b"}, "next": "c",
+		}},
+		{"nested sequence", "- - a\n  - b\n- c\n", []any{[]any{"a", "b"}, "c"}},
+		{"literal block", "a: |\n  line 1\n    line 2\n\nb: x\n", map[string]any{"a": "line 1\n  line 2\n", "b": "x"}},
+		{"folded block", "- >-\n  one\n  two\n\n  three\n", []any{"one two\nthree"}},
+		{"flow", "a: [1, \"x\"]\nb: {}\n", map[string]any{"a": []any{1.0, "x"}, "b": map[string]any{}}},
+		{"regex", `p: '(?i)\d+: Access is denied'`, map[string]any{"p": `(?i)\d+: Access is denied`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseYAML([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct{ name, in, wantErr string }{
+		{"duplicate", "a: 1\na: 2\n", "line 2: duplicate key"},
+		{"indentation", "a: 1\n  b: 2\n", "line 2: unexpected indentation"},
+		{"anchor", "a: &x 1\n", "line 1: unsupported"},
+		{"unclosed", "a: 'x\n", "line 1: invalid single-quoted"},
+		{"tab", "a:\n\tb: 1\n", "line 2: tabs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseYAML([]byte(tt.in))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadRulesFile(t *testing.T) {
+	const yamlRules = `
+rules:
+  - name: AccessDenied
+    pattern: '(?i)Access is denied'
+    url: https://github.com/microsoft/go/issues/241
+    severity: error
+    stream: stderr
+    maxOccurrences: 2
+    description: >
+      Locked file.
+  - name: Bad
+    pattern: '('
+  - name: Typo
+    patern: x
+`
+	dir := t.TempDir()
+	p := filepath.Join(dir, "rules.yml")
+	if err := os.WriteFile(p, []byte(yamlRules), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	filters, err := loadRulesFile(p, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filters) != 1 {
+		t.Fatalf("got %v filters, want 1", len(filters))
+	}
+	f := filters[0]
+	if f.name != "AccessDenied" || f.severity != severityError || f.stream != streamStderr ||
+		f.maxOccurrences != 2 || f.description != "Locked file.\n" || !f.regexp.MatchString("ACCESS IS DENIED") {
+		t.Errorf("unexpected filter: %#v", f)
+	}
+	if !f.matchesStream(streamStderr) || f.matchesStream(streamStdout) {
+		t.Error("stream: stderr filter matches the wrong streams")
+	}
+
+	if _, err := loadRulesFile(p, true); err == nil || !strings.Contains(err.Error(), "rule 1") {
+		t.Errorf("strict: got error %v, want error about rule 1", err)
+	}
+
+	jsonPath := filepath.Join(dir, "rules.json")
+	if err := os.WriteFile(jsonPath, []byte(`{"rules": [{"name": "A", "pattern": "a", "severity": "fatal"}, {"name": "B", "pattern": "b"}]}`), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	filters, err = loadRulesFile(jsonPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filters) != 1 || filters[0].name != "B" || filters[0].severity != severityWarning || filters[0].stream != streamBoth {
+		t.Errorf("unexpected filters: %#v", filters)
+	}
+	if _, err := loadRulesFile(jsonPath, true); err == nil || !strings.Contains(err.Error(), "invalid severity") {
+		t.Errorf("strict: got error %v, want invalid severity error", err)
+	}
+}
+
+func TestParseFilterStrict(t *testing.T) {
+	const env = `{"pattern": "x", "URL": "https://example.com", "extra": 1}`
+	if _, err := parseFilter("Rule", env, false); err != nil {
+		t.Errorf("non-strict: %v", err)
+	}
+	if _, err := parseFilter("Rule", env, true); err == nil {
+		t.Error("strict: expected error for unknown field")
+	}
+}
+
+func TestLineScanner(t *testing.T) {
+	newTestFilter := func(r rule) filter {
+		f, err := newFilter(r.Name, &r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *f
+	}
+	defer func(old []filter) { filters = old }(filters)
+	filters = []filter{
+		newTestFilter(rule{Name: "Denied", Pattern: `(?i)access is denied`}),
+		newTestFilter(rule{
+			Name:    "Timeout",
+			Pattern: `panic: test timed out after \S+\n\s+running tests:\n\s+(?P<test>\S+)`,
+			Lines:   3,
+			Message: "Timeout in ${test}",
+		}),
+		newTestFilter(rule{Name: "Pair", Pattern: `a\nb`, Lines: 2}),
+		newTestFilter(rule{Name: "Stderr", Pattern: `x`, Stream: streamStderr}),
+	}
+
+	output := []string{
+		"ok",
+		"Access is denied.",
+		"panic: test timed out after 3m0s",
+		"\trunning tests:",
+		"\t\tTestFoo (3m0s)",
+		"a",
+		"b",
+		"b",
+		"x",
+	}
+	var got []string
+	ls := newLineScanner(streamStdout)
+	for _, line := range output {
+		if err := ls.add(line, func(f *filter, fd *finding) error {
+			got = append(got, fmt.Sprintf("%v:%v: %v", fd.Rule, fd.LineNumber, fd.Message))
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{
+		"Denied:2: Access is denied.",
+		"Timeout:5: Timeout in TestFoo",
+		"Pair:7: a | b",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCheckTemplate(t *testing.T) {
+	if _, err := newFilter("R", &rule{Pattern: `(?P<pkg>\S+) (\d+)`, Message: "$pkg ${2} $$3"}); err != nil {
+		t.Error(err)
+	}
+	for _, m := range []string{"${test}", "$3"} {
+		if _, err := newFilter("R", &rule{Pattern: `(?P<pkg>\S+) (\d+)`, Message: m}); err == nil {
+			t.Errorf("message %q: expected error", m)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// outputLine is a line of output from the command.
+type outputLine struct {
+	stream string
+	text   string
+	// time is when cmdscan read the line.
+	time time.Time
+}
+
+// runResult is the result of running the command once.
+type runResult struct {
+	// err is the error from running the command, such as an *exec.ExitError.
+	err error
+	// scanErr is the first error reading or reporting the output, if any. After such an error,
+	// cmdscan keeps echoing the output so the command can finish.
+	scanErr error
+	// signaled is true if cmdscan received a signal and forwarded it to the command.
+	signaled bool
+}
+
+// run runs the command args, echoing and scanning its output.
+//
+// Two goroutines read stdout and stderr, timestamp each line, and send it to this goroutine, which
+// merges the streams in timestamp order (see lineMerger) and echoes and scans the lines one at a
+// time. This means the echo, the findings, and the line timestamps are all in the same order, and
+// reporting needs no locks.
+func run(args []string, timestamps bool) *runResult {
+	cmd := exec.Command(args[0], args[1:]...)
+	log.Printf("Running: %v\n", redaction.redact(cmd.String()))
+
+	outPipeR, outPipeW, err := os.Pipe()
+	if err != nil {
+		return &runResult{err: err}
+	}
+	errPipeR, errPipeW, err := os.Pipe()
+	if err != nil {
+		outPipeR.Close()
+		outPipeW.Close()
+		return &runResult{err: err}
+	}
+	cmd.Stdout = outPipeW
+	cmd.Stderr = errPipeW
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	err = cmd.Start()
+	// The command has its own copies of the write sides. Close ours so the read sides see EOF when
+	// the command exits.
+	outPipeW.Close()
+	errPipeW.Close()
+	if err != nil {
+		outPipeR.Close()
+		errPipeR.Close()
+		return &runResult{err: err}
+	}
+
+	// Buffer the lines so a reader timestamps each line as soon as it's written, even while this
+	// goroutine is busy echoing.
+	lines := make(chan outputLine, 256)
+	readErrs := make(chan readErr, 2)
+	for _, p := range []struct {
+		r      *os.File
+		stream string
+	}{
+		{outPipeR, streamStdout},
+		{errPipeR, streamStderr},
+	} {
+		go func() {
+			err := readLines(p.r, p.stream, lines)
+			p.r.Close()
+			if err != nil {
+				err = fmt.Errorf("failed to read %v: %v", p.stream, err)
+			}
+			readErrs <- readErr{p.stream, err}
+		}()
+	}
+
+	var r runResult
+	scanners := map[string]*lineScanner{
+		streamStdout: newLineScanner(streamStdout),
+		streamStderr: newLineScanner(streamStderr),
+	}
+	fail := func(err error) {
+		if r.scanErr == nil {
+			r.scanErr = err
+			log.Printf("Scan failed, only echoing output from now on: %v\n", err)
+		}
+	}
+	m := newLineMerger(streamStdout, streamStderr)
+	for !m.done() {
+		var wait <-chan time.Time
+		if d, ok := m.wait(time.Now()); ok {
+			wait = time.After(d)
+		}
+		select {
+		case l := <-lines:
+			m.add(l)
+		case e := <-readErrs:
+			// The reader sent all its lines before its error, but they may still be buffered.
+			for drained := false; !drained; {
+				select {
+				case l := <-lines:
+					m.add(l)
+				default:
+					drained = true
+				}
+			}
+			m.close(e.stream)
+			if e.err != nil {
+				fail(e.err)
+			}
+		case sig := <-sigs:
+			r.signaled = true
+			log.Printf("Received %v, forwarding it to the command.\n", sig)
+			if err := cmd.Process.Signal(sig); err != nil {
+				log.Printf("Failed to forward %v: %v\n", sig, err)
+			}
+		case <-wait:
+		}
+		for {
+			l, ok := m.next(time.Now())
+			if !ok {
+				break
+			}
+			if err := handleLine(scanners[l.stream], l, timestamps, r.scanErr == nil); err != nil {
+				fail(err)
+			}
+		}
+	}
+	if err := endPhase(time.Now()); err != nil {
+		fail(err)
+	}
+	r.err = cmd.Wait()
+	return &r
+}
+
+// readErr is the result of reading a stream to the end.
+type readErr struct {
+	stream string
+	err    error
+}
+
+// mergeWindow is how long lineMerger holds a line back while another stream is quiet, in case that
+// stream has a line read at about the same time that belongs first. Readers send each line right
+// after they read it, so a line arrives well within this time.
+const mergeWindow = 20 * time.Millisecond
+
+// lineMerger merges the lines of several streams into one sequence, ordered by the time each line
+// was read. Lines read at the same time are ordered by stream, in the order given to
+// newLineMerger, and the lines of each stream keep their order.
+//
+// The readers run independently, so the line with the earliest timestamp isn't always the first to
+// arrive. The merger releases a line once every other open stream has a later line pending, or
+// once the line is older than mergeWindow.
+type lineMerger struct {
+	streams []string
+	pending map[string][]outputLine
+	open    map[string]bool
+}
+
+func newLineMerger(streams ...string) *lineMerger {
+	m := &lineMerger{
+		streams: streams,
+		pending: make(map[string][]outputLine),
+		open:    make(map[string]bool),
+	}
+	for _, s := range streams {
+		m.open[s] = true
+	}
+	return m
+}
+
+// add adds a line read from l.stream.
+func (m *lineMerger) add(l outputLine) {
+	m.pending[l.stream] = append(m.pending[l.stream], l)
+}
+
+// close records that all the lines of stream have been added.
+func (m *lineMerger) close(stream string) {
+	m.open[stream] = false
+}
+
+// done reports whether every stream is closed and every line has been released.
+func (m *lineMerger) done() bool {
+	for _, s := range m.streams {
+		if m.open[s] || len(m.pending[s]) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// first returns the stream of the earliest pending line, or "" if there is none.
+func (m *lineMerger) first() string {
+	first := ""
+	for _, s := range m.streams {
+		if len(m.pending[s]) == 0 {
+			continue
+		}
+		if first == "" || m.pending[s][0].time.Before(m.pending[first][0].time) {
+			first = s
+		}
+	}
+	return first
+}
+
+// next removes and returns the earliest pending line, if it can be released at now.
+func (m *lineMerger) next(now time.Time) (outputLine, bool) {
+	first := m.first()
+	if first == "" {
+		return outputLine{}, false
+	}
+	l := m.pending[first][0]
+	if now.Sub(l.time) < mergeWindow {
+		for _, s := range m.streams {
+			if s != first && m.open[s] && len(m.pending[s]) == 0 {
+				return outputLine{}, false
+			}
+		}
+	}
+	m.pending[first] = m.pending[first][1:]
+	return l, true
+}
+
+// wait returns how long after now the earliest pending line can be released, if there is one.
+func (m *lineMerger) wait(now time.Time) (time.Duration, bool) {
+	first := m.first()
+	if first == "" {
+		return 0, false
+	}
+	return max(0, mergeWindow-now.Sub(m.pending[first][0].time)), true
+}
+
+// readLines sends each line read from r to lines, without the line ending.
+func readLines(r io.Reader, stream string, lines chan<- outputLine) error {
+	br := bufio.NewReader(r)
+	for {
+		text, err := br.ReadString('\n')
+		if text != "" {
+			if strings.HasSuffix(text, "\n") {
+				text = strings.TrimSuffix(text[:len(text)-1], "\r")
+			}
+			lines <- outputLine{stream: stream, text: text, time: time.Now()}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handleLine redacts secrets from a line, echoes it to the stream it came from and, if scan is
+// true, starts phases and reports the findings.
+func handleLine(ls *lineScanner, l outputLine, timestamps, scan bool) error {
+	// In test2json mode, handle the test output in events rather than the JSON.
+	var test *testKey
+	if e, ok := parseStdoutTestEvent(l); ok {
+		e.Output = redaction.redact(e.Output)
+		if e.Output == "" {
+			return reportTestEvent(e, ls, l, scan)
+		}
+		k := testJSON.output(e)
+		test = &k
+		l.text = strings.TrimSuffix(strings.TrimSuffix(e.Output, "\n"), "\r")
+	} else {
+		l.text = redaction.redact(l.text)
+	}
+	// Start the phase before echoing, so the line that starts it is in its section.
+	if scan {
+		if name, ok := ls.phase(l.text); ok {
+			if err := startPhase(name, l.time); err != nil {
+				return err
+			}
+		}
+	}
+	echo := os.Stdout
+	if l.stream == streamStderr {
+		echo = os.Stderr
+	}
+	if timestamps {
+		fmt.Fprintf(echo, "%v %v\n", l.time.Format("2006-01-02T15:04:05.000Z07:00"), l.text)
+	} else {
+		fmt.Fprintf(echo, "%v\n", l.text)
+	}
+	if !scan {
+		return nil
+	}
+	return ls.add(l.text, func(f *filter, fd *finding) error {
+		fd.Time = l.time
+		if test != nil {
+			testJSON.matched(*test, f)
+		}
+		if !f.record(fd) {
+			if f.occurrences == f.maxOccurrences+1 {
+				fmt.Fprintf(echo, "Found pattern '%v' more than %v times, only counting further matches\n", f.regexp, f.maxOccurrences)
+			}
+			return nil
+		}
+		fmt.Fprintf(echo, "Found pattern '%v'\n", f.regexp)
+		if f.description != "" {
+			fmt.Fprintf(echo, "%v: %v\n", f.name, strings.TrimSpace(f.description))
+		}
+		for _, o := range outputs {
+			if err := o.issue(fd); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// parseStdoutTestEvent parses l as a test2json event if '-testjson' is set and l is from stdout.
+func parseStdoutTestEvent(l outputLine) (*testEvent, bool) {
+	if testJSON == nil || l.stream != streamStdout {
+		return nil, false
+	}
+	return parseTestEvent(l.text)
+}
+
+// reportTestEvent reports the failure of a test or package, if e is one.
+func reportTestEvent(e *testEvent, ls *lineScanner, l outputLine, scan bool) error {
+	fd, ok := testJSON.done(e)
+	if !ok || !scan {
+		return nil
+	}
+	fd.Time = l.time
+	fd.Attempt = currentAttempt
+	fd.Stream = ls.stream
+	fd.LineNumber = ls.n
+	for _, o := range outputs {
+		if err := o.issue(fd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exitCode returns the exit code to use for the command's error: the command's own exit code, or
+// 128 plus the signal number if a signal killed it, like a shell. Returns 1 if the command didn't
+// run.
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 1
+	}
+	if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return exitErr.ExitCode()
+}
@@ -0,0 +1,129 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHelperProcess isn't a real test. It's the command run by TestRun.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GO_CMDSCAN_HELPER_PROCESS") != "1" {
+		t.Skip("helper process")
+	}
+	// Wait between the streams so the order is known.
+	fmt.Fprintln(os.Stdout, "out 1")
+	time.Sleep(100 * time.Millisecond)
+	fmt.Fprint(os.Stderr, "Access is denied\r\n")
+	time.Sleep(100 * time.Millisecond)
+	fmt.Fprint(os.Stdout, "out 2 without newline")
+	os.Exit(3)
+}
+
+func TestRun(t *testing.T) {
+	t.Setenv("GO_CMDSCAN_HELPER_PROCESS", "1")
+	defer func(old []filter, oldOutputs []output) { filters, outputs = old, oldOutputs }(filters, outputs)
+	filters = nil
+	for _, r := range []rule{
+		{Name: "Denied", Pattern: `^Access is denied$`},
+		{Name: "Out", Pattern: `^out \d`, Stream: streamStdout},
+	} {
+		f, err := newFilter(r.Name, &r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filters = append(filters, *f)
+	}
+	var b strings.Builder
+	outputs = []output{plainOutput{&b}}
+
+	r := run([]string{os.Args[0], "-test.run=^TestHelperProcess$"}, false)
+	if r.scanErr != nil {
+		t.Fatal(r.scanErr)
+	}
+	if got := exitCode(r.err); got != 3 {
+		t.Errorf("exitCode() = %v, want 3 (err: %v)", got, r.err)
+	}
+
+	got := strings.Split(strings.TrimSpace(b.String()), "\n")
+	want := []string{
+		`cmdscan warning: "Out": out 1`,
+		`cmdscan warning: "Denied": Access is denied`,
+		`cmdscan warning: "Out": out 2 without newline`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLineMerger(t *testing.T) {
+	start := time.Now()
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+	line := func(stream, text string, ms int) outputLine {
+		return outputLine{stream: stream, text: text, time: at(ms)}
+	}
+	m := newLineMerger(streamStdout, streamStderr)
+	var got []string
+	release := func(ms int) {
+		for {
+			l, ok := m.next(at(ms))
+			if !ok {
+				return
+			}
+			got = append(got, l.text)
+		}
+	}
+	check := func(want ...string) {
+		t.Helper()
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+
+	// A stderr line arrives first, but a stdout line read earlier arrives within the window.
+	m.add(line(streamStderr, "err 1", 2))
+	release(3)
+	check()
+	if d, ok := m.wait(at(3)); !ok || d != mergeWindow-time.Millisecond {
+		t.Errorf("wait() = %v, %v, want %v, true", d, ok, mergeWindow-time.Millisecond)
+	}
+	m.add(line(streamStdout, "out 1", 1))
+	m.add(line(streamStdout, "out 2", 2))
+	release(3)
+	// "out 2" was read at the same time as "err 1", so it's first. "err 1" is held back because
+	// stdout may still have an earlier line.
+	check("out 1", "out 2")
+
+	// Once the window has passed, the line is released without waiting for stdout.
+	release(2 + int(mergeWindow/time.Millisecond))
+	check("out 1", "out 2", "err 1")
+
+	// A closed stream doesn't hold lines back.
+	m.add(line(streamStdout, "out 3", 100))
+	m.close(streamStderr)
+	if m.done() {
+		t.Error("done() = true with a pending line")
+	}
+	release(100)
+	check("out 1", "out 2", "err 1", "out 3")
+	m.close(streamStdout)
+	if !m.done() {
+		t.Error("done() = false, want true")
+	}
+}
+
+func TestExitCodeNotRun(t *testing.T) {
+	r := run([]string{"cmdscan-command-that-does-not-exist"}, false)
+	if r.err == nil {
+		t.Fatal("expected error")
+	}
+	if got := exitCode(r.err); got != 1 {
+		t.Errorf("exitCode() = %v, want 1", got)
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// summary is the report of a cmdscan run.
+type summary struct {
+	Rules   []*ruleSummary `json:"rules"`
+	Retries []*retry       `json:"retries,omitempty"`
+	Phases  []*phase       `json:"phases,omitempty"`
+	// TestFailures are the failed tests found with '-testjson'.
+	TestFailures []*finding `json:"testFailures,omitempty"`
+	// Redactions is the number of secrets redacted from the output and the command line.
+	Redactions int `json:"redactions"`
+}
+
+// retry records why the command was run again.
+type retry struct {
+	// Attempt is the attempt that failed, starting at 1.
+	Attempt int    `json:"attempt"`
+	Error   string `json:"error"`
+	// Rules are the retry rules that matched during the attempt.
+	Rules []string `json:"rules"`
+	// Delay is the time waited before the retry, like "10s".
+	Delay string `json:"delay"`
+}
+
+// ruleSummary is the result of a rule that matched at least once.
+type ruleSummary struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	URL      string `json:"url,omitempty"`
+	// Matches counts all matches, including those past the rule's maxOccurrences.
+	Matches int      `json:"matches"`
+	First   *finding `json:"first"`
+	Last    *finding `json:"last"`
+}
+
+// newSummary summarizes the matches of the filters and the retries. It must not be called while
+// scanning.
+func newSummary(retries []*retry) *summary {
+	s := &summary{Rules: []*ruleSummary{}, Retries: retries, Phases: phases, Redactions: redaction.count}
+	if testJSON != nil {
+		s.TestFailures = testJSON.failures
+	}
+	for i := range filters {
+		f := &filters[i]
+		if f.occurrences == 0 {
+			continue
+		}
+		s.Rules = append(s.Rules, &ruleSummary{
+			Rule:     f.name,
+			Severity: f.severity,
+			URL:      f.url,
+			Matches:  f.occurrences,
+			First:    f.first,
+			Last:     f.last,
+		})
+	}
+	return s
+}
+
+// attemptMatches returns the names of the rules that matched during the current attempt and
+// satisfy include. It must not be called while scanning.
+func attemptMatches(include func(f *filter) bool) []string {
+	var names []string
+	for i := range filters {
+		if f := &filters[i]; f.attemptOccurrences > 0 && include(f) {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// currentAttempt is the attempt to run the command, starting at 1.
+var currentAttempt int
+
+// startAttempt resets the counts of the current attempt.
+func startAttempt(attempt int) {
+	currentAttempt = attempt
+	if testJSON != nil {
+		testJSON.reset()
+	}
+	for i := range filters {
+		filters[i].attemptOccurrences = 0
+	}
+}
+
+// writeText writes the summary for the console.
+func (s *summary) writeText(w io.Writer) {
+	if s.Redactions > 0 {
+		fmt.Fprintf(w, "cmdscan redacted %v secrets.\n", s.Redactions)
+	}
+	for _, r := range s.Retries {
+		fmt.Fprintf(w, "cmdscan retried after attempt %v failed (%v) because rules matched: %v\n", r.Attempt, r.Error, strings.Join(r.Rules, ", "))
+	}
+	if len(s.Phases) > 0 {
+		fmt.Fprintf(w, "cmdscan phases:\n")
+		for _, p := range s.Phases {
+			fmt.Fprintf(w, "  %8v  %v\n", p.duration().Round(time.Millisecond), s.phaseName(p))
+		}
+	}
+	if len(s.TestFailures) > 0 {
+		fmt.Fprintf(w, "cmdscan test failures: %v new, %v known issues.\n", s.newTestFailures(), len(s.TestFailures)-s.newTestFailures())
+		for _, f := range s.TestFailures {
+			fmt.Fprintf(w, "  %v\n", f.Message)
+		}
+	}
+	if len(s.Rules) == 0 {
+		fmt.Fprintf(w, "cmdscan summary: no rules matched.\n")
+		return
+	}
+	fmt.Fprintf(w, "cmdscan summary: %v rules matched.\n", len(s.Rules))
+	for _, r := range s.Rules {
+		fmt.Fprintf(w, "  %q (%v, %v matches)", r.Rule, r.Severity, r.Matches)
+		if r.URL != "" {
+			fmt.Fprintf(w, " %v", r.URL)
+		}
+		fmt.Fprintf(w, "\n    first: %v: %v\n", s.location(r.First), r.First.Line)
+		if r.Matches > 1 {
+			fmt.Fprintf(w, "    last:  %v: %v\n", s.location(r.Last), r.Last.Line)
+		}
+	}
+}
+
+// writeMarkdown writes the summary as a Markdown table, for CI systems that show a summary page.
+func (s *summary) writeMarkdown(w io.Writer) {
+	fmt.Fprintf(w, "# cmdscan summary\n\n")
+	if s.Redactions > 0 {
+		fmt.Fprintf(w, "Redacted %v secrets.\n\n", s.Redactions)
+	}
+	if len(s.Retries) > 0 {
+		fmt.Fprintf(w, "## Retries\n\n")
+		for _, r := range s.Retries {
+			fmt.Fprintf(w, "- Attempt %v failed (%v). Rules matched: %v. Retried after %v.\n", r.Attempt, markdownEscape(r.Error), markdownEscape(strings.Join(r.Rules, ", ")), r.Delay)
+		}
+		fmt.Fprintf(w, "\n")
+	}
+	if len(s.Phases) > 0 {
+		fmt.Fprintf(w, "## Phases\n\n")
+		fmt.Fprintf(w, "| Phase | Duration |\n")
+		fmt.Fprintf(w, "| --- | --- |\n")
+		for _, p := range s.Phases {
+			fmt.Fprintf(w, "| %v | %v |\n", markdownEscape(s.phaseName(p)), p.duration().Round(time.Millisecond))
+		}
+		fmt.Fprintf(w, "\n")
+	}
+	if len(s.TestFailures) > 0 {
+		fmt.Fprintf(w, "## Test failures\n\n")
+		for _, f := range s.TestFailures {
+			fmt.Fprintf(w, "- %v\n", markdownEscape(f.Message))
+		}
+		fmt.Fprintf(w, "\n")
+	}
+	if len(s.Retries) > 0 || len(s.Phases) > 0 || len(s.TestFailures) > 0 {
+		fmt.Fprintf(w, "## Rules\n\n")
+	}
+	if len(s.Rules) == 0 {
+		fmt.Fprintf(w, "No rules matched.\n")
+		return
+	}
+	fmt.Fprintf(w, "| Rule | Severity | Matches | First | Last |\n")
+	fmt.Fprintf(w, "| --- | --- | --- | --- | --- |\n")
+	for _, r := range s.Rules {
+		name := markdownEscape(r.Rule)
+		if r.URL != "" {
+			name = "[" + name + "](" + r.URL + ")"
+		}
+		fmt.Fprintf(w, "| %v | %v | %v | %v | %v |\n", name, r.Severity, r.Matches, s.markdownLine(r.First), s.markdownLine(r.Last))
+	}
+}
+
+// newTestFailures returns the number of test failures that aren't known issues.
+func (s *summary) newTestFailures() int {
+	n := 0
+	for _, f := range s.TestFailures {
+		if len(f.KnownIssues) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// phaseName returns the name of the phase, including the attempt if there were retries.
+func (s *summary) phaseName(p *phase) string {
+	if len(s.Retries) > 0 {
+		return fmt.Sprintf("attempt %v: %v", p.Attempt, p.Name)
+	}
+	return p.Name
+}
+
+// location returns where the finding is in the output, including the attempt if there were
+// retries.
+func (s *summary) location(f *finding) string {
+	l := fmt.Sprintf("%v:%v", f.Stream, f.LineNumber)
+	if len(s.Retries) > 0 {
+		l = fmt.Sprintf("attempt %v %v", f.Attempt, l)
+	}
+	return l
+}
+
+func (s *summary) markdownLine(f *finding) string {
+	return fmt.Sprintf("%v: `%v`", s.location(f), strings.ReplaceAll(markdownEscape(f.Line), "`", "'"))
+}
+
+func markdownEscape(s string) string {
+	return strings.ReplaceAll(s, "|", `\|`)
+}
+
+// writeFile writes the summary to path as JSON if it ends in ".json", otherwise as Markdown.
+func (s *summary) writeFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(s)
+	} else {
+		s.writeMarkdown(f)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,127 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSummary(t *testing.T) {
+	defer func(old []filter) { filters = old }(filters)
+	filters = nil
+	for _, r := range []rule{
+		{Name: "Denied", Pattern: `denied`, URL: "https://example.com/1"},
+		{Name: "Fatal", Pattern: `fatal \| x`, Severity: severityError},
+		{Name: "Unused", Pattern: `unused`},
+	} {
+		f, err := newFilter(r.Name, &r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filters = append(filters, *f)
+	}
+	startAttempt(1)
+	ls := newLineScanner(streamStderr)
+	for _, line := range []string{"denied 1", "ok", "fatal | x", "denied 2", "denied 3"} {
+		if err := ls.add(line, func(f *filter, fd *finding) error {
+			f.record(fd)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := newSummary([]*retry{{Attempt: 1, Error: "exit status 1", Rules: []string{"Denied"}, Delay: "10s"}})
+	isError := func(f *filter) bool { return f.severity == severityError }
+	if got, want := attemptMatches(isError), []string{"Fatal"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("attemptMatches(isError) = %v, want %v", got, want)
+	}
+	startAttempt(2)
+	if got := attemptMatches(isError); got != nil {
+		t.Errorf("after startAttempt, attemptMatches(isError) = %v, want nil", got)
+	}
+
+	var b strings.Builder
+	s.writeText(&b)
+	want := `cmdscan retried after attempt 1 failed (exit status 1) because rules matched: Denied
+cmdscan summary: 2 rules matched.
+  "Denied" (warning, 3 matches) https://example.com/1
+    first: attempt 1 stderr:1: denied 1
+    last:  attempt 1 stderr:5: denied 3
+  "Fatal" (error, 1 matches)
+    first: attempt 1 stderr:3: fatal | x
+`
+	if got := b.String(); got != want {
+		t.Errorf("writeText:\n%v\nwant:\n%v", got, want)
+	}
+
+	b.Reset()
+	s.writeMarkdown(&b)
+	if got := b.String(); !strings.Contains(got, "| [Denied](https://example.com/1) | warning | 3 | attempt 1 stderr:1: `denied 1` | attempt 1 stderr:5: `denied 3` |\n") ||
+		!strings.Contains(got, "attempt 1 stderr:3: `fatal \\| x`") {
+		t.Errorf("writeMarkdown: unexpected result:\n%v", got)
+	}
+}
+
+func TestPhases(t *testing.T) {
+	defer func(old []filter, oldOutputs []output, oldPhases []*phase) {
+		filters, outputs, phases = old, oldOutputs, oldPhases
+	}(filters, outputs, phases)
+	phases = nil
+	f, err := newFilter("Phase", &rule{Pattern: `^##### (?P<phase>.*)`, Message: "${phase}", Phase: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filters = []filter{*f}
+	var b strings.Builder
+	outputs = []output{azdoOutput{&b}}
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	ls := newLineScanner(streamStdout)
+	for i, line := range []string{"setup", "##### Building packages", "ok", "##### Testing packages", "PASS"} {
+		if name, ok := ls.phase(line); ok {
+			if err := startPhase(name, start.Add(time.Duration(i)*time.Second)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		b.WriteString(line + "\n")
+	}
+	if err := endPhase(start.Add(10 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `setup
+##[group]Building packages
+##### Building packages
+ok
+##[endgroup]
+##[group]Testing packages
+##### Testing packages
+PASS
+##[endgroup]
+`
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%v\nwant:\n%v", got, want)
+	}
+
+	b.Reset()
+	newSummary(nil).writeText(&b)
+	want = `cmdscan phases:
+        2s  Building packages
+        7s  Testing packages
+cmdscan summary: no rules matched.
+`
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestPhaseRuleLines(t *testing.T) {
+	if _, err := newFilter("Phase", &rule{Pattern: `x`, Phase: true, Lines: 2}); err == nil {
+		t.Error("expected error for multi-line phase rule")
+	}
+}
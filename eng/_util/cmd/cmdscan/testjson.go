@@ -0,0 +1,154 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// testFailureRule is the rule name of test failure findings, so they can be found with a
+// "contains" search like other issues.
+const testFailureRule = "TestFailure"
+
+// testEvent is a test2json event. See "go doc cmd/test2json".
+type testEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Output  string
+	// ImportPath is set instead of Package by build events.
+	ImportPath string
+}
+
+// parseTestEvent parses a line of output as a test2json event.
+func parseTestEvent(line string) (*testEvent, bool) {
+	if !strings.HasPrefix(line, "{") {
+		return nil, false
+	}
+	var e testEvent
+	if err := json.Unmarshal([]byte(line), &e); err != nil || e.Action == "" {
+		return nil, false
+	}
+	if e.Package == "" {
+		e.Package = e.ImportPath
+	}
+	return &e, true
+}
+
+// testKey identifies a test. A Test of "" is the package itself.
+type testKey struct {
+	pkg, test string
+}
+
+func (k testKey) String() string {
+	if k.test == "" {
+		return k.pkg
+	}
+	return k.pkg + " " + k.test
+}
+
+// testState is the output of a test that hasn't finished, and the rules it matched.
+type testState struct {
+	output strings.Builder
+	rules  []*filter
+}
+
+// testRun tracks the tests in a test2json stream to report each failed test with its output.
+type testRun struct {
+	tests map[testKey]*testState
+	// failedChildren are the tests and packages with a failed subtest or test, which is reported
+	// instead of the parent.
+	failedChildren map[testKey]bool
+	// failures are the failures found so far.
+	failures []*finding
+}
+
+func newTestRun() *testRun {
+	return &testRun{
+		tests:          make(map[testKey]*testState),
+		failedChildren: make(map[testKey]bool),
+	}
+}
+
+// reset forgets the tests in progress, for a new attempt to run the command. The failures are kept.
+func (r *testRun) reset() {
+	clear(r.tests)
+	clear(r.failedChildren)
+}
+
+func (r *testRun) state(k testKey) *testState {
+	s, ok := r.tests[k]
+	if !ok {
+		s = &testState{}
+		r.tests[k] = s
+	}
+	return s
+}
+
+// output records the output of an event. Returns the key of the test it belongs to.
+func (r *testRun) output(e *testEvent) testKey {
+	k := testKey{e.Package, e.Test}
+	r.state(k).output.WriteString(e.Output)
+	return k
+}
+
+// matched records that a rule matched the output of a test.
+func (r *testRun) matched(k testKey, f *filter) {
+	s := r.state(k)
+	for _, m := range s.rules {
+		if m == f {
+			return
+		}
+	}
+	s.rules = append(s.rules, f)
+}
+
+// done handles an event that may end a test or package. If it is a failure that should be
+// reported, returns a finding for it. A failure is reported as a known issue if any non-phase
+// rule matched its output, otherwise as a new failure with error severity.
+func (r *testRun) done(e *testEvent) (*finding, bool) {
+	switch e.Action {
+	case "pass", "skip", "fail", "build-fail":
+	default:
+		return nil, false
+	}
+	k := testKey{e.Package, e.Test}
+	s := r.state(k)
+	delete(r.tests, k)
+	if e.Action != "fail" && e.Action != "build-fail" {
+		return nil, false
+	}
+	// Mark each parent, and the package, as having a failed child.
+	if k.test != "" {
+		r.failedChildren[testKey{pkg: k.pkg}] = true
+		for i := strings.LastIndex(k.test, "/"); i >= 0; i = strings.LastIndex(k.test[:i], "/") {
+			r.failedChildren[testKey{k.pkg, k.test[:i]}] = true
+		}
+	}
+	if r.failedChildren[k] {
+		delete(r.failedChildren, k)
+		return nil, false
+	}
+
+	fd := &finding{
+		Rule:     testFailureRule,
+		Severity: severityError,
+		Message:  "FAIL " + k.String() + ", new failure",
+		Details:  strings.TrimRight(s.output.String(), "\n"),
+	}
+	if len(s.rules) > 0 {
+		var names []string
+		for _, f := range s.rules {
+			fd.KnownIssues = append(fd.KnownIssues, f.name)
+			names = append(names, fmt.Sprintf("%q", f.name))
+		}
+		fd.Severity = severityWarning
+		fd.URL = s.rules[0].url
+		fd.Message = "FAIL " + k.String() + ", known issue " + strings.Join(names, ", ")
+	}
+	r.failures = append(r.failures, fd)
+	return fd, true
+}
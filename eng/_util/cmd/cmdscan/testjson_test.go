@@ -0,0 +1,102 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTestJSON(t *testing.T) {
+	defer func(old []filter, oldOutputs []output, oldTestJSON *testRun) {
+		filters, outputs, testJSON = old, oldOutputs, oldTestJSON
+	}(filters, outputs, testJSON)
+	f, err := newFilter("Flaky", &rule{Pattern: `connection reset`, URL: "https://example.com/1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filters = []filter{*f}
+	var got []*finding
+	outputs = []output{recordOutput{&got}}
+	testJSON = newTestRun()
+
+	events := []testEvent{
+		{Action: "start", Package: "p"},
+		{Action: "run", Package: "p", Test: "TestOK"},
+		{Action: "output", Package: "p", Test: "TestOK", Output: "=== RUN   TestOK\n"},
+		{Action: "pass", Package: "p", Test: "TestOK"},
+		{Action: "run", Package: "p", Test: "TestNew"},
+		{Action: "output", Package: "p", Test: "TestNew", Output: "=== RUN   TestNew\n"},
+		{Action: "output", Package: "p", Test: "TestNew", Output: "    x_test.go:1: boom\n"},
+		{Action: "fail", Package: "p", Test: "TestNew"},
+		{Action: "run", Package: "p", Test: "TestParent"},
+		{Action: "run", Package: "p", Test: "TestParent/Sub"},
+		{Action: "output", Package: "p", Test: "TestParent/Sub", Output: "    read: connection reset\n"},
+		{Action: "fail", Package: "p", Test: "TestParent/Sub"},
+		{Action: "fail", Package: "p", Test: "TestParent"},
+		{Action: "output", Package: "p", Output: "FAIL\n"},
+		{Action: "fail", Package: "p"},
+		{Action: "output", Package: "q", Output: "panic: test timed out\n"},
+		{Action: "fail", Package: "q"},
+	}
+	ls := newLineScanner(streamStdout)
+	for _, e := range events {
+		b, err := json.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := handleLine(ls, outputLine{stream: streamStdout, text: string(b), time: time.Now()}, false, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type result struct {
+		rule, severity, message, details string
+		knownIssues                      []string
+	}
+	var results []result
+	for _, fd := range got {
+		results = append(results, result{fd.Rule, fd.Severity, fd.Message, fd.Details, fd.KnownIssues})
+	}
+	want := []result{
+		{testFailureRule, severityError, "FAIL p TestNew, new failure", "=== RUN   TestNew\n    x_test.go:1: boom", nil},
+		{"Flaky", severityWarning, "read: connection reset", "", nil},
+		{testFailureRule, severityWarning, `FAIL p TestParent/Sub, known issue "Flaky"`, "    read: connection reset", []string{"Flaky"}},
+		{testFailureRule, severityError, "FAIL q, new failure", "panic: test timed out", nil},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("got:\n%q\nwant:\n%q", results, want)
+	}
+	if len(testJSON.failures) != 3 {
+		t.Errorf("got %v failures in summary, want 3", len(testJSON.failures))
+	}
+}
+
+func TestParseTestEvent(t *testing.T) {
+	for _, line := range []string{"{not json", `{"Foo": 1}`, "PASS"} {
+		if _, ok := parseTestEvent(line); ok {
+			t.Errorf("parseTestEvent(%q) succeeded, want failure", line)
+		}
+	}
+	e, ok := parseTestEvent(`{"ImportPath":"p [p.test]","Action":"build-fail"}`)
+	if !ok || e.Package != "p [p.test]" {
+		t.Errorf("got %#v, want build-fail event for package", e)
+	}
+}
+
+// recordOutput records the issues it's given.
+type recordOutput struct {
+	findings *[]*finding
+}
+
+func (o recordOutput) issue(f *finding) error {
+	*o.findings = append(*o.findings, f)
+	return nil
+}
+
+func (recordOutput) setVariable(name, value string) error { return nil }
+func (recordOutput) startGroup(name string) error         { return nil }
+func (recordOutput) endGroup() error                      { return nil }
@@ -0,0 +1,358 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseYAML parses the subset of YAML used by rule files into the same values encoding/json
+// produces when unmarshaling into an "any": map[string]any, []any, string, bool, float64, and nil.
+//
+// cmdscan runs in pipelines that only allow minimal dependencies, so this is a small parser rather
+// than a YAML library. It supports block mappings and sequences, plain and quoted scalars, "|" and
+// ">" block scalars, comments, and flow collections written as JSON. It doesn't support anchors,
+// tags, or multiple documents.
+func parseYAML(data []byte) (any, error) {
+	p := &yamlParser{lines: strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")}
+	if indent, text, ok, err := p.peek(); err != nil {
+		return nil, err
+	} else if ok && indent == 0 && text == "---" {
+		p.i++
+	}
+	v, err := p.parseBlock(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, text, ok, err := p.peek(); err != nil {
+		return nil, err
+	} else if ok {
+		return nil, p.errorf("unexpected content %q", text)
+	}
+	return v, nil
+}
+
+type yamlParser struct {
+	lines []string
+	// i is the index of the next line to parse.
+	i int
+}
+
+func (p *yamlParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %v: %v", p.i+1, fmt.Sprintf(format, args...))
+}
+
+// peek skips blank and comment lines and returns the indentation and content of the next line,
+// without its comment. Returns false at the end of the input.
+func (p *yamlParser) peek() (indent int, text string, ok bool, err error) {
+	for ; p.i < len(p.lines); p.i++ {
+		line := p.lines[p.i]
+		trimmed := strings.TrimLeft(line, " ")
+		indent = len(line) - len(trimmed)
+		text = strings.TrimRight(stripComment(trimmed), " \t")
+		if text == "" {
+			continue
+		}
+		if text[0] == '\t' {
+			return 0, "", false, p.errorf("tabs are not allowed in indentation")
+		}
+		return indent, text, true, nil
+	}
+	return 0, "", false, nil
+}
+
+// parseBlock parses the mapping, sequence, or scalar starting at the next line, which must be
+// indented at least minIndent. Returns nil if there is no such line.
+func (p *yamlParser) parseBlock(minIndent int) (any, error) {
+	indent, text, ok, err := p.peek()
+	if err != nil || !ok || indent < minIndent {
+		return nil, err
+	}
+	if isSeqItem(text) {
+		return p.parseSeq(indent)
+	}
+	if _, _, ok := cutKey(text); ok {
+		return p.parseMap(indent)
+	}
+	p.i++
+	v, err := parseScalar(text)
+	if err != nil {
+		return nil, fmt.Errorf("line %v: %v", p.i, err)
+	}
+	return v, nil
+}
+
+func (p *yamlParser) parseSeq(indent int) ([]any, error) {
+	s := []any{}
+	for {
+		i, text, ok, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if !ok || i < indent || !isSeqItem(text) {
+			if ok && i > indent {
+				return nil, p.errorf("unexpected indentation")
+			}
+			return s, nil
+		}
+		if i > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+		// Replace the "-" with a space so the item's content is parsed as a block indented
+		// further than the sequence.
+		line := p.lines[p.i]
+		p.lines[p.i] = line[:indent] + " " + line[indent+1:]
+		v, err := p.parseValue(indent, strings.TrimLeft(text[1:], " "))
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, v)
+	}
+}
+
+func (p *yamlParser) parseMap(indent int) (map[string]any, error) {
+	m := make(map[string]any)
+	for {
+		i, text, ok, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if !ok || i < indent {
+			return m, nil
+		}
+		if i > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+		k, rest, ok := cutKey(text)
+		if !ok {
+			return nil, p.errorf("expected \"key: value\", found %q", text)
+		}
+		key, err := parseKey(k)
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		if _, ok := m[key]; ok {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		p.i++
+		var v any
+		if rest == "" {
+			// A sequence may be at the same indentation as its key.
+			if i, text, ok, err := p.peek(); err != nil {
+				return nil, err
+			} else if ok && i == indent && isSeqItem(text) {
+				v, err = p.parseSeq(indent)
+				if err != nil {
+					return nil, err
+				}
+				m[key] = v
+				continue
+			}
+			v, err = p.parseBlock(indent + 1)
+		} else {
+			v, err = p.parseInline(indent, rest)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+}
+
+// parseValue parses a sequence item whose content starts on the current line, which is indented
+// by indent.
+func (p *yamlParser) parseValue(indent int, text string) (any, error) {
+	if text == "" {
+		p.i++
+		return p.parseBlock(indent + 1)
+	}
+	if _, _, ok := cutKey(text); ok || isSeqItem(text) {
+		return p.parseBlock(indent + 1)
+	}
+	p.i++
+	return p.parseInline(indent, text)
+}
+
+// parseInline parses a value that follows a key or "-" on a line that has already been consumed.
+func (p *yamlParser) parseInline(indent int, text string) (any, error) {
+	if text[0] == '|' || text[0] == '>' {
+		return p.parseBlockScalar(indent, text)
+	}
+	v, err := parseScalar(text)
+	if err != nil {
+		return nil, fmt.Errorf("line %v: %v", p.i, err)
+	}
+	return v, nil
+}
+
+// parseBlockScalar parses the lines of a literal ("|") or folded (">") scalar, which must be
+// indented more than indent. A "-" after the indicator strips the final line break.
+func (p *yamlParser) parseBlockScalar(indent int, header string) (string, error) {
+	folded := header[0] == '>'
+	strip := false
+	switch header[1:] {
+	case "":
+	case "-":
+		strip = true
+	default:
+		return "", fmt.Errorf("line %v: unsupported block scalar header %q", p.i, header)
+	}
+	var lines []string
+	blockIndent := -1
+	for ; p.i < len(p.lines); p.i++ {
+		line := strings.TrimRight(p.lines[p.i], " \t")
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" {
+			lines = append(lines, "")
+			continue
+		}
+		i := len(line) - len(trimmed)
+		if blockIndent < 0 {
+			if i <= indent {
+				break
+			}
+			blockIndent = i
+		}
+		if i < blockIndent {
+			break
+		}
+		lines = append(lines, line[blockIndent:])
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	var b strings.Builder
+	for i, l := range lines {
+		if i > 0 {
+			if folded && l != "" && lines[i-1] != "" {
+				b.WriteByte(' ')
+			} else if !folded || l != "" {
+				b.WriteByte('\n')
+			}
+		}
+		b.WriteString(l)
+	}
+	if !strip && len(lines) > 0 {
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
+func isSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// cutKey splits "key: value" into the key and the value. The key may be quoted.
+func cutKey(text string) (key, value string, ok bool) {
+	start := 0
+	if text[0] == '"' || text[0] == '\'' {
+		end := quotedEnd(text)
+		if end < 0 {
+			return "", "", false
+		}
+		start = end
+	}
+	for i := start; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			if i == 0 || text[0] == '[' || text[0] == '{' {
+				return "", "", false
+			}
+			return strings.TrimRight(text[:i], " "), strings.TrimLeft(text[i+1:], " "), true
+		}
+	}
+	return "", "", false
+}
+
+func parseKey(k string) (string, error) {
+	v, err := parseScalar(k)
+	if err != nil {
+		return "", err
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return k, nil
+}
+
+// parseScalar parses a quoted or plain scalar, or a flow collection written as JSON. Plain
+// scalars are resolved like the YAML core schema: null, booleans, and numbers.
+func parseScalar(text string) (any, error) {
+	switch text[0] {
+	case '"':
+		if quotedEnd(text) != len(text) {
+			return nil, fmt.Errorf("invalid double-quoted string %s", text)
+		}
+		s, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid double-quoted string %s: %v", text, err)
+		}
+		return s, nil
+	case '\'':
+		if quotedEnd(text) != len(text) {
+			return nil, fmt.Errorf("invalid single-quoted string %s", text)
+		}
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	case '[', '{':
+		var v any
+		if err := json.Unmarshal([]byte(text), &v); err != nil {
+			return nil, fmt.Errorf("flow collections must be valid JSON: %v", err)
+		}
+		return v, nil
+	case '&', '*', '!', '%', '@', '`':
+		return nil, fmt.Errorf("unsupported YAML syntax %q", text)
+	}
+	switch text {
+	case "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return float64(i), nil
+	}
+	if f, err := strconv.ParseFloat(text, 64); err == nil && !strings.ContainsAny(text, "xXpP_") {
+		return f, nil
+	}
+	return text, nil
+}
+
+// quotedEnd returns the index after the closing quote of the quoted string at the start of text,
+// or -1 if it isn't closed.
+func quotedEnd(text string) int {
+	q := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case q == '"' && text[i] == '\\':
+			i++
+		case text[i] == q && q == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == q:
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// stripComment removes a "#" comment from a line, ignoring "#" inside quoted strings and "#" that
+// isn't at the start of the line or after a space.
+func stripComment(line string) string {
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case (c == '"' || c == '\'') && (i == 0 || line[i-1] == ' ' || line[i-1] == '-'):
+			end := quotedEnd(line[i:])
+			if end < 0 {
+				return line
+			}
+			i += end - 1
+		case c == '#' && (i == 0 || line[i-1] == ' '):
+			return line[:i]
+		}
+	}
+	return line
+}
@@ -0,0 +1,90 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/microsoft/go/_util/internal/linuxpkg"
+)
+
+const description = `
+This command creates RPM and DEB packages from Linux Go distribution archives.
+Pass the tar.gz archives as non-flag arguments.
+
+Each package installs the "go" directory of the archive to the install dir and
+registers "go" and "gofmt" in /usr/bin using the alternatives system. Packages
+are created in pure Go, so this works on any platform without rpmbuild or
+dpkg-deb.
+
+Next to each package, a "<package>.manifest.json" file is written that
+describes the package and the archive it was created from.
+
+The packages are created unsigned. Use the sign command to add a detached
+signature or embed a signature into the package.
+
+Example: create packages for a build:
+
+  eng/run.ps1 linuxpkg -o eng/signing/tosign go1.23.1-1.linux-amd64.tar.gz
+`
+
+func main() {
+	help := flag.Bool("h", false, "Print this help message.")
+	outDir := flag.String("o", ".", "Directory to write packages to.")
+	formats := flag.String("formats", "rpm,deb", "Comma-separated list of package formats to create.")
+	installDir := flag.String("install-dir", linuxpkg.DefaultInstallDir, "Absolute path to install the Go distribution to.")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "%s\n", description)
+	}
+
+	flag.Parse()
+	if *help {
+		flag.Usage()
+		return
+	}
+	if flag.NArg() == 0 {
+		flag.Usage()
+		log.Fatal("No archives specified.")
+	}
+
+	var fs []linuxpkg.Format
+	for _, f := range strings.Split(*formats, ",") {
+		format, err := linuxpkg.FormatOf("." + strings.TrimSpace(f))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fs = append(fs, format)
+	}
+
+	if err := os.MkdirAll(*outDir, 0o777); err != nil {
+		log.Fatal(err)
+	}
+	for _, src := range flag.Args() {
+		m, err := linuxpkg.NewMetadata(src)
+		if err != nil {
+			log.Fatal(err)
+		}
+		m.InstallDir = *installDir
+		for _, format := range fs {
+			dst := filepath.Join(*outDir, m.Filename(format))
+			log.Printf("Creating %q from %q", dst, src)
+			manifest, err := linuxpkg.Build(format, src, dst, m)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := manifest.WriteFile(dst + ".manifest.json"); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+}
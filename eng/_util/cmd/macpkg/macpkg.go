@@ -0,0 +1,75 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/microsoft/go/_util/internal/macpkg"
+)
+
+const description = `
+This command creates macOS installer packages (.pkg) from macOS Go distribution
+tar.gz archives. Pass the archives as non-flag arguments.
+
+The package is created in pure Go, so it can be created on Linux. It installs
+the Go distribution to -install-dir and, unless '-path=false' is passed, adds
+a file to /etc/paths.d so the Go bin directory is on PATH in new shells.
+
+Build the package from the signed tar.gz: the binaries must already be
+hardened for the package to be notarized. The package itself is then signed
+and notarized by the sign command, which recognizes go*.pkg files.
+
+Example: create a package for a build:
+
+  eng/run.ps1 macpkg -o eng/signing/tosign eng/signing/signed/go1.23.1-1.darwin-arm64.tar.gz
+`
+
+func main() {
+	help := flag.Bool("h", false, "Print this help message.")
+	outDir := flag.String("o", ".", "Directory to write packages to.")
+	installDir := flag.String("install-dir", macpkg.DefaultInstallDir, "Absolute path to install the Go distribution to.")
+	addToPath := flag.Bool("path", true, "Add the Go bin directory to PATH using /etc/paths.d.")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "%s\n", description)
+	}
+
+	flag.Parse()
+	if *help {
+		flag.Usage()
+		return
+	}
+	if flag.NArg() == 0 {
+		flag.Usage()
+		log.Fatal("No archives specified.")
+	}
+
+	if err := os.MkdirAll(*outDir, 0o777); err != nil {
+		log.Fatal(err)
+	}
+	for _, src := range flag.Args() {
+		o, err := macpkg.NewOptions(src)
+		if err != nil {
+			log.Fatal(err)
+		}
+		o.InstallDir = *installDir
+		if !*addToPath {
+			o.PathsFile = ""
+		}
+
+		dst := filepath.Join(*outDir, o.Filename())
+		log.Printf("Creating %q from %q", dst, src)
+		if err := macpkg.Build(src, dst, o); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/microsoft/go/_util/internal/archive"
+	"github.com/microsoft/go/_util/internal/msi"
+)
+
+const description = `
+This command creates Windows Installer packages (MSI) from Windows Go
+distribution zip archives. Pass the zip archives as non-flag arguments.
+
+The MSI is compiled by wixl, from msitools, so it can be created on Linux. wixl
+must be on PATH. Use '-wxs' to only write the WiX source, without wixl.
+
+The installer installs to the INSTALLDIR property, which defaults to the
+-install-dir path under Program Files. Unless '-path=false' is passed, the
+installer has an "AddToPath" feature that adds the Go bin directory to the
+system PATH. Both can be changed at install time:
+
+  msiexec /i go1.23.1-1.windows-amd64.msi INSTALLDIR=C:\go REMOVE=AddToPath
+
+Build the MSI from the signed zip: the MSI itself is then signed by the sign
+command, which recognizes go*.msi files.
+
+Example: create an MSI for a build:
+
+  eng/run.ps1 msi -o eng/signing/tosign eng/signing/signed/go1.23.1-1.windows-amd64.zip
+`
+
+func main() {
+	help := flag.Bool("h", false, "Print this help message.")
+	outDir := flag.String("o", ".", "Directory to write installers to.")
+	installDir := flag.String("install-dir", msi.DefaultInstallDir, "Default install dir, relative to Program Files.")
+	addToPath := flag.Bool("path", true, "Include the feature that adds the Go bin directory to the system PATH.")
+	wxsOnly := flag.Bool("wxs", false, "Only write the WiX source (.wxs) and extracted files to the output dir, without running wixl.")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "%s\n", description)
+	}
+
+	flag.Parse()
+	if *help {
+		flag.Usage()
+		return
+	}
+	if flag.NArg() == 0 {
+		flag.Usage()
+		log.Fatal("No archives specified.")
+	}
+
+	if err := os.MkdirAll(*outDir, 0o777); err != nil {
+		log.Fatal(err)
+	}
+	for _, src := range flag.Args() {
+		o, err := msi.NewOptions(src)
+		if err != nil {
+			log.Fatal(err)
+		}
+		o.InstallDir = *installDir
+		o.AddToPath = *addToPath
+
+		dst := filepath.Join(*outDir, o.Filename())
+		if *wxsOnly {
+			wxsPath := strings.TrimSuffix(dst, ".msi") + ".wxs"
+			filesDir, err := filepath.Abs(strings.TrimSuffix(dst, ".msi") + ".files")
+			if err != nil {
+				log.Fatal(err)
+			}
+			a, err := archive.New(src)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("Extracting %q to %q", src, filesDir)
+			if _, err := a.Extract(filesDir, func(e *archive.Entry) bool { return e.IsRegular() }); err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("Writing %q", wxsPath)
+			if err := archive.WithFileCreate(wxsPath, func(f *os.File) error {
+				return msi.WriteWXS(f, src, filesDir, o)
+			}); err != nil {
+				log.Fatal(err)
+			}
+			continue
+		}
+
+		workDir, err := os.MkdirTemp("", "msi-"+filepath.Base(src))
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Creating %q from %q", dst, src)
+		err = msi.Build(src, dst, workDir, o)
+		os.RemoveAll(workDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/ed25519"
+	"debug/buildinfo"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/microsoft/go-infra/patch"
+	"github.com/microsoft/go/_util/buildutil"
+	"github.com/microsoft/go/_util/internal/archive"
+	"github.com/microsoft/go/_util/internal/provenance"
+	"github.com/microsoft/go/_util/internal/signer"
+)
+
+const description = `
+This command creates SLSA v1 build provenance for each Go archive passed as a
+non-flag argument: an in-toto statement in a DSSE envelope, written next to the
+archive with ".intoto.jsonl" added to its name.
+
+The statement records the builder ID, the commit of this repo, the Go submodule
+commit, the hash of each patch, the GOEXPERIMENT the toolchain was built with,
+and the build ID (BUILD_BUILDNUMBER, or "dev"). Run it from the root of the
+repo that built the archives.
+
+The statement is signed with '-key', a local Ed25519 private key, or by running
+'-sign-command', which reads the message to sign from stdin and writes the
+signature to stdout. Without either, the envelope is unsigned.
+
+Use "provenance verify -pub <key> <archive>..." to check archives against their
+attestations offline.
+`
+
+const verifyDescription = `
+This command checks each archive passed as a non-flag argument against its
+attestation: the attestation must be signed by a '-pub' key and list the archive
+as a subject with matching digests. By default, the attestation is the archive
+path with ".intoto.jsonl" added.
+`
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		runVerify(os.Args[2:])
+		return
+	}
+
+	help := flag.Bool("h", false, "Print this help message.")
+	outDir := flag.String("o", "", "Directory to write attestations to. By default, each is written next to its archive.")
+	keyPath := flag.String("key", "", "Ed25519 private key PEM file to sign with.")
+	signCommand := flag.String("sign-command", "", "Space-separated command line that signs stdin and writes the signature to stdout.")
+	keyID := flag.String("key-id", "", "The key ID of the key used by '-sign-command'.")
+	builderID := flag.String("builder-id", defaultBuilderID(), "URI that identifies the build platform.")
+	experiment := flag.String("experiment", os.Getenv("GOEXPERIMENT"), "GOEXPERIMENT to record if it can't be read from the archive's go binary.")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "%s\n", description)
+	}
+
+	flag.Parse()
+	if *help {
+		flag.Usage()
+		return
+	}
+	if flag.NArg() == 0 {
+		flag.Usage()
+		log.Fatal("No archives specified.")
+	}
+
+	var signers []signer.Signer
+	if *keyPath != "" {
+		s, err := signer.LoadKeySigner(*keyPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		signers = append(signers, s)
+	}
+	if *signCommand != "" {
+		if *keyID == "" {
+			log.Fatal("'-sign-command' requires '-key-id'.")
+		}
+		signers = append(signers, &signer.CommandSigner{ID: *keyID, Args: strings.Fields(*signCommand)})
+	}
+	if len(signers) == 0 {
+		log.Println("No '-key' or '-sign-command' specified: attestations are unsigned.")
+	}
+
+	o, err := buildOptions(*builderID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, p := range flag.Args() {
+		ao := *o
+		ao.GOEXPERIMENT = *experiment
+		if e, ok, err := archiveExperiment(p); err != nil {
+			log.Fatal(err)
+		} else if ok {
+			ao.GOEXPERIMENT = e
+		}
+		s, err := provenance.New(p, &ao)
+		if err != nil {
+			log.Fatal(err)
+		}
+		env, err := provenance.Sign(s, signers...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dst := p + provenance.Ext
+		if *outDir != "" {
+			dst = filepath.Join(*outDir, filepath.Base(dst))
+		}
+		if err := env.Write(dst); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Wrote attestation %q\n", dst)
+	}
+}
+
+// buildOptions collects the information about the build from the repo and the environment.
+func buildOptions(builderID string) (*provenance.Options, error) {
+	rootDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	config, err := patch.FindAncestorConfig(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	rootDir = config.RootDir
+	o := &provenance.Options{
+		BuilderID:    builderID,
+		InvocationID: invocationID(),
+		BuildID:      buildutil.GetBuildID(),
+		SourceURI:    cmp.Or(os.Getenv("BUILD_REPOSITORY_URI"), "https://github.com/microsoft/go"),
+		FinishedOn:   time.Now(),
+	}
+	if o.SourceCommit, err = git(rootDir, "rev-parse", "HEAD"); err != nil {
+		return nil, err
+	}
+	// The commit recorded in the repo, not the commit checked out in the submodule, which has the
+	// patches applied in the index or as commits.
+	if o.SubmoduleCommit, err = git(rootDir, "rev-parse", "HEAD:"+config.SubmoduleDir); err != nil {
+		log.Printf("Unable to find the submodule commit, omitting it: %v", err)
+		o.SubmoduleCommit = ""
+	}
+	o.SubmoduleURI, err = git(rootDir, "config", "-f", ".gitmodules", "submodule."+config.SubmoduleDir+".url")
+	if err != nil {
+		return nil, err
+	}
+	if o.Patches, err = provenance.Patches(filepath.Join(rootDir, config.PatchesDir)); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// defaultBuilderID identifies the AzDO pipeline definition, or returns "local".
+func defaultBuilderID() string {
+	collection, project, definition := os.Getenv("SYSTEM_COLLECTIONURI"), os.Getenv("SYSTEM_TEAMPROJECT"), os.Getenv("SYSTEM_DEFINITIONID")
+	if collection == "" || project == "" || definition == "" {
+		return "local"
+	}
+	return strings.TrimSuffix(collection, "/") + "/" + project + "/_build?definitionId=" + definition
+}
+
+// invocationID identifies the AzDO build, or returns "" if not running in AzDO.
+func invocationID() string {
+	collection, project, build := os.Getenv("SYSTEM_COLLECTIONURI"), os.Getenv("SYSTEM_TEAMPROJECT"), os.Getenv("BUILD_BUILDID")
+	if collection == "" || project == "" || build == "" {
+		return ""
+	}
+	return strings.TrimSuffix(collection, "/") + "/" + project + "/_build/results?buildId=" + build
+}
+
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("git %v failed: %v: %s", strings.Join(args, " "), err, bytes.TrimSpace(exitErr.Stderr))
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+var errFound = errors.New("found")
+
+// archiveExperiment reads the GOEXPERIMENT build setting of the go binary in the archive at p.
+// Returns false if p isn't a zip or tar.gz archive or has no go binary.
+func archiveExperiment(p string) (experiment string, ok bool, err error) {
+	if _, err := archive.FormatOf(p); err != nil {
+		return "", false, nil
+	}
+	a, err := archive.New(p)
+	if err != nil {
+		return "", false, err
+	}
+	err = a.Walk(func(e *archive.Entry, r io.Reader) error {
+		if e.Name != "go/bin/go" && e.Name != "go/bin/go.exe" {
+			return nil
+		}
+		// debug/buildinfo needs an io.ReaderAt.
+		content, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		bi, err := buildinfo.Read(bytes.NewReader(content))
+		if err != nil {
+			return fmt.Errorf("failed to read build info of %q in %q: %v", e.Name, p, err)
+		}
+		for _, s := range bi.Settings {
+			if s.Key == "GOEXPERIMENT" {
+				experiment = s.Value
+			}
+		}
+		ok = true
+		return errFound
+	})
+	if err != nil && err != errFound {
+		return "", false, err
+	}
+	return experiment, ok, nil
+}
+
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	help := fs.Bool("h", false, "Print this help message.")
+	attestation := fs.String("attestation", "", "Attestation file to check. Only valid with one archive.")
+	var pubPaths []string
+	fs.Func("pub", "Trusted Ed25519 public key PEM file. May be repeated.", func(s string) error {
+		pubPaths = append(pubPaths, s)
+		return nil
+	})
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of provenance verify:\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "%s\n", verifyDescription)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if *help {
+		fs.Usage()
+		return
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		log.Fatal("No archives specified.")
+	}
+	if *attestation != "" && fs.NArg() != 1 {
+		log.Fatal("'-attestation' requires exactly one archive.")
+	}
+	if len(pubPaths) == 0 {
+		log.Fatal("No '-pub' keys specified.")
+	}
+	var keys []ed25519.PublicKey
+	for _, p := range pubPaths {
+		k, err := signer.LoadPublicKey(p)
+		if err != nil {
+			log.Fatal(err)
+		}
+		keys = append(keys, k)
+	}
+
+	ok := true
+	for _, p := range fs.Args() {
+		ap := cmp.Or(*attestation, p+provenance.Ext)
+		env, err := provenance.Read(ap)
+		if err == nil {
+			var s *provenance.Statement
+			if s, err = provenance.VerifyArchive(p, env, keys); err == nil {
+				fmt.Printf("%v: OK, built by %v, build %v\n", p, s.Predicate.RunDetails.Builder.ID, s.Predicate.BuildDefinition.ExternalParameters.BuildID)
+				continue
+			}
+		}
+		fmt.Printf("%v: FAILED: %v\n", p, err)
+		ok = false
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
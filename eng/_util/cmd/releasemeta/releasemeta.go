@@ -0,0 +1,152 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/microsoft/go/_util/internal/checksum"
+	"github.com/microsoft/go/_util/internal/signer"
+	"github.com/microsoft/go/_util/releasemeta"
+)
+
+const description = `
+This command creates signed release metadata: a JSON document listing every file
+in a release with its length and hashes, a version, and an expiry. The format is
+modeled on TUF targets metadata. Pass the release files as non-flag arguments.
+
+The metadata is written to the '-o' dir as "targets.json" and as the consistent
+snapshot "<version>.targets.json". The version must increase each time metadata
+is published for a release channel, so clients can reject an older document.
+
+Use '-generate-key' to create an Ed25519 key pair for local testing.
+
+With '-verify', checks the given metadata file with the '-pub' keys, then checks
+each file passed as an argument against it. The command fails if the metadata or
+any file is invalid. The github.com/microsoft/go/_util/releasemeta package
+implements the same checks for use by installers.
+`
+
+func main() {
+	help := flag.Bool("h", false, "Print this help message.")
+	outDir := flag.String("o", ".", "Directory to write the metadata files to.")
+	version := flag.Int("version", 0, "[Required when creating] Metadata version. Must be at least 1.")
+	expires := flag.Duration("expires", 90*24*time.Hour, "How long the metadata is valid after it's created.")
+	keyPath := flag.String("key", "", "[Required when creating] Ed25519 private key PEM file to sign with.")
+	algorithms := flag.String("algorithms", "sha256,sha512", "Comma-separated hash algorithms to list for each file. Options: sha256, sha384, sha512, blake2b.")
+	generateKey := flag.String("generate-key", "", "Create a key pair at '<prefix>.key' and '<prefix>.pub', then exit.")
+
+	verify := flag.String("verify", "", "Verify this metadata file instead of creating metadata.")
+	var pubPaths []string
+	flag.Func("pub", "Trusted Ed25519 public key PEM file to verify with. May be repeated.", func(s string) error {
+		pubPaths = append(pubPaths, s)
+		return nil
+	})
+	threshold := flag.Int("threshold", 1, "Number of trusted keys that must have signed the metadata.")
+	minVersion := flag.Int("min-version", 0, "Lowest accepted metadata version.")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "%s\n", description)
+	}
+
+	flag.Parse()
+	if *help {
+		flag.Usage()
+		return
+	}
+
+	if *generateKey != "" {
+		if err := signer.GenerateKey(*generateKey+".key", *generateKey+".pub"); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Wrote %v.key and %v.pub\n", *generateKey, *generateKey)
+		return
+	}
+
+	if *verify != "" {
+		if err := runVerify(*verify, pubPaths, *threshold, *minVersion, flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		log.Fatal("No files specified.")
+	}
+	if *keyPath == "" {
+		flag.Usage()
+		log.Fatal("No '-key' specified.")
+	}
+	var algs []checksum.Algorithm
+	for _, s := range strings.Split(*algorithms, ",") {
+		a, err := checksum.ParseAlgorithm(strings.TrimSpace(s))
+		if err != nil {
+			log.Fatal(err)
+		}
+		algs = append(algs, a)
+	}
+	s, err := signer.LoadKeySigner(*keyPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	t, err := releasemeta.New(flag.Args(), *version, time.Now().Add(*expires), algs...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	m, err := releasemeta.Sign(t, s)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := m.Write(*outDir); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runVerify(path string, pubPaths []string, threshold, minVersion int, files []string) error {
+	if len(pubPaths) == 0 {
+		return fmt.Errorf("no '-pub' keys specified")
+	}
+	v := releasemeta.Verifier{Threshold: threshold, MinVersion: minVersion}
+	for _, p := range pubPaths {
+		k, err := signer.LoadPublicKey(p)
+		if err != nil {
+			return err
+		}
+		v.Keys = append(v.Keys, k)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	t, err := v.Verify(data)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%v: OK, version %v, %v files, expires %v\n", path, t.Version, len(t.Targets), t.Expires)
+
+	ok := true
+	for _, f := range files {
+		if err := t.VerifyFile(filepath.Base(f), f); err != nil {
+			fmt.Printf("%v: FAILED: %v\n", f, err)
+			ok = false
+			continue
+		}
+		fmt.Printf("%v: OK\n", f)
+	}
+	if !ok {
+		return fmt.Errorf("some files don't match the release metadata")
+	}
+	return nil
+}
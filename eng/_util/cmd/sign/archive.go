@@ -5,43 +5,92 @@
 package main
 
 import (
-	"archive/tar"
 	"archive/zip"
+	"bytes"
 	"cmp"
 	"context"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
-	"strings"
+
+	goarchive "github.com/microsoft/go/_util/internal/archive"
+	"github.com/microsoft/go/_util/internal/checksum"
+	"github.com/microsoft/go/_util/internal/linuxpkg"
 )
 
-type archiveType int
+// archiveKind is the type of a file to sign, which decides how each step handles it.
+type archiveKind int
 
 const (
-	// zipArchive is a Windows zip archive.
-	zipArchive archiveType = iota
-	// tarGzArchive is a macOS or Linux tar.gz archive.
-	tarGzArchive
+	// kindZip is a Windows Go distribution archive.
+	kindZip archiveKind = iota
+	// kindTarGz is a macOS or Linux Go distribution archive, or the source archive.
+	kindTarGz
+	// kindRPM and kindDEB are Linux packages. They may get an embedded signature.
+	kindRPM
+	kindDEB
+	// kindMSI is a Windows installer, which is signed directly.
+	kindMSI
+	// kindMacPackage is a macOS installer package, which is signed and notarized.
+	kindMacPackage
+	// kindSymbolBundle is a tar bundle of a PDB symbol store. PDBs can't be Authenticode signed,
+	// so the bundle only gets a signature file.
+	kindSymbolBundle
 )
 
+// archiveFormat returns the format of a Go distribution archive kind, or false for other kinds.
+func (k archiveKind) archiveFormat() (goarchive.Format, bool) {
+	switch k {
+	case kindZip:
+		return goarchive.Zip, true
+	case kindTarGz:
+		return goarchive.TarGz, true
+	}
+	return 0, false
+}
+
+// linuxFormat returns the package format of kindRPM or kindDEB.
+func (k archiveKind) linuxFormat() linuxpkg.Format {
+	if k == kindRPM {
+		return linuxpkg.RPM
+	}
+	return linuxpkg.DEB
+}
+
 type archive struct {
 	path string
 	name string
+	kind archiveKind
+
+	// concurrency is the number of goroutines used to compress the archive when it's repacked.
+	concurrency int
 
-	archiveType  archiveType
-	archiveMacOS bool
+	// inputSHA256 is the hash of the original archive, used to decide whether a previous run's
+	// work can be reused.
+	inputSHA256 string
 
 	// workDir is a work dir absolute path that is only used for processing this archive.
 	workDir string
 
+	// hasEntriesToSign is set by prepareEntriesToSign if any entry of a Go distribution archive
+	// has a policy rule with a certificate. If none does, the archive isn't repacked.
+	hasEntriesToSign bool
+
 	// repackedPath is a repackaged archive with signed content. Assigned upon completion.
-	// Windows and macOS archives get repacked.
+	// Windows and macOS archives get repacked, and Linux packages do if a signature is embedded.
+	// For installers and macOS packages, this is the signed file.
 	repackedPath string
 	// notarizedPath is a repacked archive that has also had the notarization ticket attached.
 	// Assigned upon completion.
 	notarizedPath string
+
+	// destinationSums are the checksums of the archive copied to the destination dir, computed
+	// while copying. Assigned by copyToDestination.
+	destinationSums checksum.Sums
 }
 
 func newArchive(p string) (*archive, error) {
@@ -50,32 +99,42 @@ func newArchive(p string) (*archive, error) {
 		path: p,
 		name: name,
 	}
-	if matchOrPanic("go*.zip", name) {
-		a.archiveType = zipArchive
-	} else if matchOrPanic("go*.tar.gz", name) {
-		a.archiveType = tarGzArchive
-	} else {
+	switch {
+	case matchOrPanic("go*.rpm", name):
+		a.kind = kindRPM
+	case matchOrPanic("go*.deb", name):
+		a.kind = kindDEB
+	case matchOrPanic("go*.msi", name):
+		a.kind = kindMSI
+	case matchOrPanic("go*.pkg", name):
+		a.kind = kindMacPackage
+	case matchOrPanic("go*.symbols.tar", name):
+		a.kind = kindSymbolBundle
+	case matchOrPanic("go*.zip", name):
+		a.kind = kindZip
+	case matchOrPanic("go*.tar.gz", name):
+		a.kind = kindTarGz
+	default:
 		return nil, fmt.Errorf("unknown archive type: %s", p)
 	}
+	return &a, nil
+}
 
-	if matchOrPanic("go*darwin*.tar.gz", name) {
-		a.archiveMacOS = true
-	}
-
+// createWorkDir creates a new, unique work dir for a.
+func (a *archive) createWorkDir() error {
 	if err := os.MkdirAll(*tempDir, 0o777); err != nil {
-		return nil, err
+		return err
 	}
-	workDir, err := os.MkdirTemp(*tempDir, "sign-work-"+name)
+	workDir, err := os.MkdirTemp(*tempDir, "sign-work-"+a.name)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create work directory: %v", err)
+		return fmt.Errorf("failed to create work directory: %v", err)
 	}
 	workDir, err = filepath.Abs(workDir)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	a.workDir = workDir
-
-	return &a, nil
+	return nil
 }
 
 // latestPath returns the path of the file that has the most signing steps applied to it. This
@@ -94,36 +153,53 @@ func (a *archive) sigPath() string {
 	return filepath.Join(a.workDir, a.name+".sig")
 }
 
-func (a *archive) macHardenPackPath() string {
-	return filepath.Join(a.workDir, a.name+".ToSignBundle.zip")
+// packageSigPath is the signature of the content of a Linux package, for embedding.
+func (a *archive) packageSigPath() string {
+	return filepath.Join(a.workDir, a.name+".embedded.sig")
+}
+
+// installerSignPath is the signed copy of an MSI installer or macOS package. It keeps the
+// original filename so the signing service recognizes the file type.
+func (a *archive) installerSignPath() string {
+	return filepath.Join(a.workDir, "installer", a.name)
+}
+
+// bundlePath is the zip of the entries that are signed with certificate in one batch.
+func (a *archive) bundlePath(certificate string) string {
+	return filepath.Join(a.workDir, a.name+"."+certificate+".ToSignBundle.zip")
 }
 
 func (a *archive) macNotarizePackPath() string {
 	return filepath.Join(a.workDir, a.name+".ToNotarize.zip")
 }
 
+// macNotarizedPath is the notarized macOS package, extracted from the notarization zip.
+func (a *archive) macNotarizedPath() string {
+	return filepath.Join(a.workDir, "notarized", a.name)
+}
+
 // entrySignInfo returns signing details for a given file in the Go archive, or nil if the given
-// file entry doesn't need to be signed.
+// file entry doesn't need to be signed. The details come from the policy file.
 func (a *archive) entrySignInfo(name string) *fileToSign {
-	if a.archiveType == zipArchive {
-		if strings.HasSuffix(name, ".exe") {
-			return &fileToSign{
-				originalPath: a.path,
-				fullPath:     filepath.Join(a.workDir, "extract", name),
-				authenticode: "Microsoft400",
-			}
-		}
-	} else if a.archiveMacOS {
-		if matchOrPanic("go/bin/*", name) ||
-			matchOrPanic("go/pkg/tool/*/*", name) {
-
-			return &fileToSign{
-				originalPath: a.path,
-				zip:          true,
-			}
+	rules := policy.rules(a, name)
+	if len(rules) != 1 || rules[0].Certificate == certificateNone {
+		return nil
+	}
+	r := rules[0]
+	if r.Batch == batchZip {
+		return &fileToSign{
+			originalPath: a.path,
+			authenticode: r.Certificate,
+			entry:        name,
+			zip:          true,
 		}
 	}
-	return nil
+	return &fileToSign{
+		originalPath: a.path,
+		fullPath:     filepath.Join(a.workDir, "extract", filepath.FromSlash(name)),
+		authenticode: r.Certificate,
+		entry:        name,
+	}
 }
 
 // prepareEntriesToSign extracts files from the archive that need to be signed and returns a list
@@ -133,254 +209,280 @@ func (a *archive) prepareEntriesToSign(ctx context.Context) ([]*fileToSign, erro
 		return nil, fmt.Errorf("failed to extract file from %q: %v", a.path, err)
 	}
 
-	var results []*fileToSign
-
-	if a.archiveType == zipArchive {
-		log.Printf("Extracting files to sign from %q", a.path)
-		zr, err := zip.OpenReader(a.path)
+	switch a.kind {
+	case kindSymbolBundle:
+		return nil, nil
+	case kindRPM, kindDEB:
+		if *packageSignature != "embedded" {
+			return nil, nil
+		}
+		// The signing process replaces the file's content with a signature of the content.
+		log.Printf("Extracting content to sign from %q to %q", a.path, a.packageSigPath())
+		content, err := linuxpkg.SignedContent(a.kind.linuxFormat(), a.path)
 		if err != nil {
 			return fail(err)
 		}
-		defer zr.Close()
-
-		if err := eachZipEntry(zr, func(f *zip.File) error {
-			if err := ctx.Err(); err != nil {
-				return err
-			}
-			if f.FileInfo().IsDir() {
-				return nil
-			}
-			if info := a.entrySignInfo(f.Name); info != nil {
-				if err := withFileCreate(info.fullPath, func(fWriter *os.File) error {
-					fReader, err := f.Open()
-					if err != nil {
-						return err
-					}
-					_, err = io.Copy(fWriter, fReader)
-					return cmp.Or(err, fReader.Close())
-				}); err != nil {
-					return err
-				}
-				results = append(results, info)
-			}
-			return nil
-		}); err != nil {
+		if err := os.WriteFile(a.packageSigPath(), content, 0o666); err != nil {
 			return fail(err)
 		}
-	} else if a.archiveMacOS {
-		// Store macOS files to sign in a zip. Zipping is needed for this platform specifically,
-		// and the "Zip=true" feature mentioned in the doc only works when signing on a macOS
-		// runtime, so we need to do it ourselves.
-		// https://dev.azure.com/devdiv/DevDiv/_wiki/wikis/DevDiv.wiki/19841/Additional-Requirements-for-Signing-or-Notarizing-Mac-Files
-		fts := &fileToSign{
-			originalPath: a.path,
-			fullPath:     a.macHardenPackPath(),
-			authenticode: "MacDeveloperHarden",
+		return []*fileToSign{
+			{
+				originalPath: a.path,
+				fullPath:     a.packageSigPath(),
+				authenticode: "LinuxSignManagedLanguageCompiler",
+			},
+		}, nil
+	case kindMSI:
+		log.Printf("Copying installer to sign: %q -> %q", a.path, a.installerSignPath())
+		if err := os.MkdirAll(filepath.Dir(a.installerSignPath()), 0o777); err != nil {
+			return fail(err)
 		}
-		log.Printf("Creating macOS file hardening bundle at %q", fts.fullPath)
-		if err := withZipCreate(fts.fullPath, func(zw *zip.Writer) error {
-			return a.extractMacOSEntriesToZip(ctx, zw)
-		}); err != nil {
+		if err := goarchive.CopyFile(a.installerSignPath(), a.path); err != nil {
 			return fail(err)
 		}
-		results = append(results, fts)
+		return []*fileToSign{
+			{
+				originalPath: a.path,
+				fullPath:     a.installerSignPath(),
+				authenticode: "Microsoft400",
+			},
+		}, nil
+	case kindMacPackage:
+		// Like hardening, macOS package signing requires a zip.
+		p := a.bundlePath("MacDeveloper")
+		log.Printf("Creating macOS package signing bundle at %q", p)
+		if err := zipFile(p, a.path); err != nil {
+			return fail(err)
+		}
+		return []*fileToSign{
+			{
+				originalPath: a.path,
+				fullPath:     p,
+				authenticode: "MacDeveloper",
+			},
+		}, nil
 	}
-
+	results, err := a.extractEntriesToSign(ctx)
+	if err != nil {
+		return fail(err)
+	}
+	a.hasEntriesToSign = len(results) > 0
 	return results, nil
 }
 
-func (a *archive) extractMacOSEntriesToZip(ctx context.Context, zw *zip.Writer) error {
-	// Open tar.gz macOS archive to put files into the zip.
-	writtenNames := make(map[string]struct{})
-	return withTarGzOpen(a.path, func(tr *tar.Reader) error {
-		return eachTarEntry(tr, func(header *tar.Header, r io.Reader) error {
-			if err := ctx.Err(); err != nil {
+// extractEntriesToSign extracts the entries that are signed individually, and puts the entries
+// that are signed in a batch into a bundle zip per certificate.
+//
+// Batching is needed for macOS specifically, and the "Zip=true" feature mentioned in the doc only
+// works when signing on a macOS runtime, so we need to do it ourselves.
+// https://dev.azure.com/devdiv/DevDiv/_wiki/wikis/DevDiv.wiki/19841/Additional-Requirements-for-Signing-or-Notarizing-Mac-Files
+func (a *archive) extractEntriesToSign(ctx context.Context) (results []*fileToSign, err error) {
+	type bundle struct {
+		f       *os.File
+		zw      *zip.Writer
+		entries map[string]struct{}
+		result  *fileToSign
+	}
+	bundles := make(map[string]*bundle)
+	defer func() {
+		for _, b := range bundles {
+			err = cmp.Or(err, b.zw.Close(), b.f.Close())
+		}
+	}()
+
+	log.Printf("Extracting files to sign from %q", a.path)
+	err = a.source().Walk(func(e *goarchive.Entry, r io.Reader) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if r == nil {
+			return nil
+		}
+		info := a.entrySignInfo(e.Name)
+		if info == nil {
+			return nil
+		}
+		if !info.zip {
+			results = append(results, info)
+			if err := os.MkdirAll(filepath.Dir(info.fullPath), 0o777); err != nil {
 				return err
 			}
-			if header.Typeflag != tar.TypeReg {
-				return nil
-			}
-			if info := a.entrySignInfo(header.Name); info != nil {
-				if !info.zip {
-					return fmt.Errorf("unexpected file to sign directly rather than include in the zip batch: %q", header.Name)
-				}
-
-				base := filepath.Base(header.Name)
-				if _, ok := writtenNames[base]; ok {
-					return fmt.Errorf("duplicate file name in archive: %q", base)
-				}
-				writtenNames[base] = struct{}{}
-
-				w, err := zw.CreateHeader(&zip.FileHeader{
-					Name: base,
-				})
-				if err != nil {
-					return err
-				}
-				_, err = io.Copy(w, r)
+			return goarchive.CopyToFile(info.fullPath, r)
+		}
+
+		b, ok := bundles[info.authenticode]
+		if !ok {
+			p := a.bundlePath(info.authenticode)
+			log.Printf("Creating %v signing bundle at %q", info.authenticode, p)
+			f, err := os.Create(p)
+			if err != nil {
 				return err
 			}
-			return nil
+			b = &bundle{
+				f:       f,
+				zw:      zip.NewWriter(f),
+				entries: make(map[string]struct{}),
+				result: &fileToSign{
+					originalPath: a.path,
+					fullPath:     p,
+					authenticode: info.authenticode,
+				},
+			}
+			bundles[info.authenticode] = b
+			results = append(results, b.result)
+		}
+		base := path.Base(e.Name)
+		if _, ok := b.entries[base]; ok {
+			return fmt.Errorf("duplicate file name in archive: %q", base)
+		}
+		b.entries[base] = struct{}{}
+		b.result.entries = append(b.result.entries, e.Name)
+		w, err := b.zw.CreateHeader(&zip.FileHeader{
+			Name: base,
 		})
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, r)
+		return err
 	})
+	return results, err
 }
 
 func (a *archive) repackSignedEntries(ctx context.Context) error {
 	targetPath := filepath.Join(a.workDir, a.name+".WithSignedContent")
-	if a.archiveType == zipArchive {
-		log.Printf("Repacking signed content to %q", targetPath)
-		if err := withZipOpen(a.path, func(zr *zip.ReadCloser) error {
-			return withZipCreate(targetPath, func(zw *zip.Writer) error {
-				return eachZipEntry(zr, func(f *zip.File) error {
-					if err := ctx.Err(); err != nil {
-						return err
-					}
-					return a.writeZipRepackEntry(f, zw)
-				})
-			})
-		}); err != nil {
-			return err
-		}
-		a.repackedPath = targetPath
-	} else if a.archiveMacOS {
-		log.Printf("Repacking hardened content to %q", targetPath)
-		// Open the original tar.gz for header info and to read unchanged files from.
-		if err := withTarGzOpen(a.path, func(originalTR *tar.Reader) error {
-			// Create the new tar.gz that we're assembling.
-			return withTarGzCreate(targetPath, func(outTW *tar.Writer) error {
-				// Open the zip payload we got back from the signing service.
-				return withZipOpen(a.macHardenPackPath(), func(zrc *zip.ReadCloser) error {
-					// Iterate through the original tar.gz file to populate the target.
-					return eachTarEntry(originalTR, func(hdr *tar.Header, originalR io.Reader) error {
-						if err := ctx.Err(); err != nil {
-							return err
-						}
-						return a.writeTarRepackEntry(hdr, originalR, &zrc.Reader, outTW)
-					})
-				})
-			})
-		}); err != nil {
-			return err
+	switch a.kind {
+	case kindSymbolBundle:
+		return nil
+	case kindRPM, kindDEB:
+		if *packageSignature != "embedded" {
+			return nil
 		}
-		a.repackedPath = targetPath
-	}
-	return nil
-}
-
-// writeZipRepackEntry looks at one entry in the original zip and creates a corresponding entry in
-// the output zip. Reads signed entry content from the signed file on disk. If the file hasn't been
-// signed, the content is read from the original zip.
-func (a *archive) writeZipRepackEntry(original *zip.File, out *zip.Writer) error {
-	w, err := out.CreateHeader(&zip.FileHeader{
-		// Copy necessary original file metadata.
-		Name:     original.Name,
-		Method:   original.Method,
-		Comment:  original.Comment,
-		Modified: original.Modified,
-		Extra:    original.Extra,
-	})
-	if err != nil {
-		return err
-	}
-	var r io.ReadCloser
-	// If we have a signed version of this file, read from that.
-	// Otherwise, read from the original.
-	if info := a.entrySignInfo(original.Name); info != nil {
-		log.Printf("Replacing with signed version: %q", original.Name)
-		r, err = os.Open(info.fullPath)
+		sig, err := os.ReadFile(a.packageSigPath())
 		if err != nil {
 			return err
 		}
-	} else {
-		r, err = original.Open()
+		// The file sent to the signing service starts out as the signed content. If nothing
+		// replaced it with a signature, embedding it would make a package that fails
+		// verification, so ship the package without an embedded signature instead.
+		content, err := linuxpkg.SignedContent(a.kind.linuxFormat(), a.path)
 		if err != nil {
 			return err
 		}
-	}
-	_, err = io.Copy(w, r)
-	return cmp.Or(err, r.Close())
-}
-
-// writeTarRepackEntry looks at one entry in the original tar.gz and creates a corresponding entry
-// in the output tar.gz. Reads signed/hardened entry content from signedPack. Otherwise, the entry
-// content is copied from the original.
-func (a *archive) writeTarRepackEntry(hdr *tar.Header, original io.Reader, signedPack *zip.Reader, out *tar.Writer) error {
-	// Always start with header info from the original tar.gz even if we're going to replace the
-	// file content. This means we don't need to worry about lost metadata due to the zip
-	// round-trip.
-	newHeader := &tar.Header{
-		// Follow tar.Header documented compat guidance by copying over our selection of fields.
-		Name:     hdr.Name,
-		Linkname: hdr.Linkname,
-
-		Size:  hdr.Size,
-		Mode:  hdr.Mode,
-		Uid:   hdr.Uid,
-		Gid:   hdr.Gid,
-		Uname: hdr.Uname,
-		Gname: hdr.Gname,
-
-		ModTime:    hdr.ModTime,
-		AccessTime: hdr.AccessTime,
-		ChangeTime: hdr.ChangeTime,
-	}
-	isFile := hdr.Typeflag == tar.TypeReg
-	if info := a.entrySignInfo(hdr.Name); info != nil && isFile {
-		log.Printf("Replacing with signed version: %q", hdr.Name)
-		replacementFile, err := signedPack.Open(filepath.Base(hdr.Name))
-		if err != nil {
+		if *dryRun || bytes.Equal(sig, content) {
+			log.Printf("Package content of %q wasn't signed: not embedding a signature", a.path)
+			return nil
+		}
+		log.Printf("Embedding signature into %q", targetPath)
+		if err := linuxpkg.EmbedSignature(a.kind.linuxFormat(), a.path, sig, targetPath); err != nil {
 			return err
 		}
-		defer replacementFile.Close()
-		// Get the file size to prepare to copy.
-		stat, err := replacementFile.Stat()
-		if err != nil {
+		a.repackedPath = targetPath
+		return nil
+	case kindMSI:
+		// The installer was signed in place: there's nothing to repack.
+		a.repackedPath = a.installerSignPath()
+		return nil
+	case kindMacPackage:
+		log.Printf("Extracting signed package to %q", a.installerSignPath())
+		if err := unzipFile(a.installerSignPath(), a.bundlePath("MacDeveloper")); err != nil {
 			return err
 		}
-		newHeader.Size = stat.Size()
-		original = replacementFile
+		a.repackedPath = a.installerSignPath()
+		return nil
 	}
-	if err := out.WriteHeader(newHeader); err != nil {
-		return fmt.Errorf(
-			"failed to write header for %q: %v",
-			newHeader.Name, err)
+	return a.repackArchive(ctx, targetPath)
+}
+
+// repackArchive writes the archive to targetPath with each signed entry replaced by the signed
+// file, and sets repackedPath. If no entries were signed, the original archive is kept.
+func (a *archive) repackArchive(ctx context.Context, targetPath string) error {
+	if !a.hasEntriesToSign {
+		// Nothing in the archive is signed individually, for example a Linux archive. Keep the
+		// original rather than spending time recompressing an equivalent copy.
+		log.Printf("No signed entries in %q: keeping the original", a.path)
+		return nil
 	}
-	if isFile {
-		_, err := io.Copy(out, original)
-		if err != nil {
-			return fmt.Errorf("failed to write %q: %v", newHeader.Name, err)
+	bundles := make(map[string]*zip.ReadCloser)
+	defer func() {
+		for _, zrc := range bundles {
+			zrc.Close()
 		}
+	}()
+	log.Printf("Repacking signed content to %q", targetPath)
+	if err := a.source().Replace(targetPath, func(e *goarchive.Entry) (fs.File, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !e.IsRegular() {
+			return nil, nil
+		}
+		info := a.entrySignInfo(e.Name)
+		if info == nil {
+			return nil, nil
+		}
+		log.Printf("Replacing with signed version: %q", e.Name)
+		if !info.zip {
+			return os.Open(info.fullPath)
+		}
+		// Open the zip payload we got back from the signing service.
+		zrc, ok := bundles[info.authenticode]
+		if !ok {
+			var err error
+			if zrc, err = zip.OpenReader(a.bundlePath(info.authenticode)); err != nil {
+				return nil, err
+			}
+			bundles[info.authenticode] = zrc
+		}
+		return zrc.Open(path.Base(e.Name))
+	}); err != nil {
+		return err
 	}
-	// Call Flush to make sure our write was correct. We don't technically need to call Flush here
-	// because the next WriteHeader will confirm that we e.g. wrote the correct number of bytes.
-	// However, calling Flush ourselves lets us emit an error that mentions the bad filename
-	// (rather than the next, unrelated filename).
-	if err := out.Flush(); err != nil {
-		return fmt.Errorf("failed to flush %q: %v", newHeader.Name, err)
-	}
+	a.repackedPath = targetPath
 	return nil
 }
 
+// source returns the original Go distribution archive, configured to use a's share of the
+// parallelism. It panics if a isn't a Go distribution archive.
+func (a *archive) source() *goarchive.Archive {
+	format, ok := a.kind.archiveFormat()
+	if !ok {
+		panic("not a Go distribution archive: " + a.path)
+	}
+	return &goarchive.Archive{
+		Path:        a.path,
+		Format:      format,
+		Concurrency: a.concurrency,
+	}
+}
+
 func (a *archive) prepareNotarize(ctx context.Context) ([]*fileToSign, error) {
 	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
 
-	if !a.archiveMacOS {
+	// The executable binaries inside our tar.gz archive are already notarized by the earlier
+	// "MacDeveloperHarden" step, and that's the best we can do for them. Individual file
+	// notarizations are not stapled: they are stored by Apple and downloaded on demand.
+	//
+	// The installer package can have a stapled ticket, so Installer can check it offline.
+	if a.kind != kindMacPackage {
 		return nil, nil
 	}
 
-	// Currently, we don't produce any macOS artifacts that can accept stapled notarization, like
-	// app bundles, disk images, or installers.
-	//
-	// The executable binaries inside our tar.gz archive are already notarized by the earlier
-	// "MacDeveloperHarden" step, and that's the best we can do. Individual file notarizations are
-	// not stapled: they are stored by Apple and downloaded on demand.
-	//
-	// If we do produce notarizable artifacts in the future, add the logic here to pack them in a
-	// zip and add logic to unpackNotarize to extract them back out, if zip submission is still a
-	// MicroBuild and/or ESRP requirement.
-	return nil, nil
+	// Notarization requires a zip, even when the file is a single package.
+	log.Printf("Creating macOS package notarization bundle at %q", a.macNotarizePackPath())
+	if err := zipFile(a.macNotarizePackPath(), a.latestPath()); err != nil {
+		return nil, err
+	}
+	return []*fileToSign{
+		{
+			originalPath: a.path,
+			fullPath:     a.macNotarizePackPath(),
+			authenticode: "8020",
+			macAppName:   "MicrosoftGo",
+		},
+	}, nil
 }
 
 func (a *archive) unpackNotarize(ctx context.Context) error {
@@ -388,13 +490,50 @@ func (a *archive) unpackNotarize(ctx context.Context) error {
 		return err
 	}
 
-	if !a.archiveMacOS {
+	if a.kind != kindMacPackage {
 		return nil
 	}
 
+	// The signing service replaces the package in the zip with one that has the notarization
+	// ticket stapled to it.
+	log.Printf("Extracting notarized package to %q", a.macNotarizedPath())
+	if err := unzipFile(a.macNotarizedPath(), a.macNotarizePackPath()); err != nil {
+		return err
+	}
+	a.notarizedPath = a.macNotarizedPath()
 	return nil
 }
 
+// zipFile creates a zip at dst that contains the file at src, with the same name as the
+// archive. Only the file's content is kept.
+func zipFile(dst, src string) error {
+	return goarchive.WithZipCreate(dst, func(zw *zip.Writer) error {
+		w, err := zw.Create(filepath.Base(src))
+		if err != nil {
+			return err
+		}
+		return goarchive.WithFileOpen(src, func(f *os.File) error {
+			_, err := io.Copy(w, f)
+			return err
+		})
+	})
+}
+
+// unzipFile extracts the file with the same name as dst from the zip at src to dst.
+func unzipFile(dst, src string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o777); err != nil {
+		return err
+	}
+	return goarchive.WithZipOpen(src, func(zrc *zip.ReadCloser) error {
+		f, err := zrc.Open(filepath.Base(dst))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return goarchive.CopyToFile(dst, f)
+	})
+}
+
 func (a *archive) prepareArchiveSignatures(ctx context.Context) ([]*fileToSign, error) {
 	if err := ctx.Err(); err != nil {
 		return nil, err
@@ -404,7 +543,7 @@ func (a *archive) prepareArchiveSignatures(ctx context.Context) ([]*fileToSign,
 	// file's content in-place with the result. We need to preemptively make a renamed copy of the
 	// file so we end up with both the original file and sig on the machine.
 	log.Printf("Copying file for signature generation: %q -> %q", a.latestPath(), a.sigPath())
-	if err := copyFile(a.sigPath(), a.latestPath()); err != nil {
+	if err := goarchive.CopyFile(a.sigPath(), a.latestPath()); err != nil {
 		return nil, err
 	}
 	return []*fileToSign{
@@ -426,10 +565,17 @@ func (a *archive) copyToDestination(ctx context.Context) error {
 	}
 
 	log.Printf("Copying finished files to destination: %q", a.latestPath())
-	if err := copyFile(filepath.Join(*destinationDir, a.name), a.latestPath()); err != nil {
+	f, err := os.Open(a.latestPath())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := checksum.NewHasher(checksumAlgorithms...)
+	if err := goarchive.CopyToFile(filepath.Join(*destinationDir, a.name), io.TeeReader(f, h)); err != nil {
 		return err
 	}
-	if err := copyFile(filepath.Join(*destinationDir, a.name+".sig"), a.sigPath()); err != nil {
+	a.destinationSums = h.Sums()
+	if err := goarchive.CopyFile(filepath.Join(*destinationDir, a.name+".sig"), a.sigPath()); err != nil {
 		return err
 	}
 	return nil
@@ -0,0 +1,240 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	goarchive "github.com/microsoft/go/_util/internal/archive"
+	"github.com/microsoft/go/_util/internal/checksum"
+	"github.com/microsoft/go/_util/internal/linuxpkg"
+)
+
+// writeLinuxPackage builds a package in the given format from a small Linux archive in the temp
+// dir, and returns its path.
+func writeLinuxPackage(t *testing.T, format linuxpkg.Format) string {
+	src := filepath.Join(t.TempDir(), "go1.23.1-1.linux-amd64.tar.gz")
+	if err := goarchive.WithTarGzCreate(src, 1, func(tw *tar.Writer) error {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "go/bin/go", Mode: 0o755, Size: 3}); err != nil {
+			return err
+		}
+		_, err := tw.Write([]byte("abc"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	m, err := linuxpkg.NewMetadata(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(*tempDir, m.Filename(format))
+	if _, err := linuxpkg.Build(format, src, dst, m); err != nil {
+		t.Fatal(err)
+	}
+	return dst
+}
+
+func TestEmbeddedPackageSignature(t *testing.T) {
+	tests := []struct {
+		name   string
+		dryRun bool
+		// sig replaces the file sent to the signing service, if not nil.
+		sig       []byte
+		wantEmbed bool
+	}{
+		{"dry run", true, nil, false},
+		{"not signed", false, nil, false},
+		{"signed", false, []byte("signature"), true},
+	}
+	for _, format := range []linuxpkg.Format{linuxpkg.RPM, linuxpkg.DEB} {
+		for _, tt := range tests {
+			t.Run(string(format)+"/"+tt.name, func(t *testing.T) {
+				setupState(t)
+				*dryRun = tt.dryRun
+				*packageSignature = "embedded"
+
+				a, err := newArchive(writeLinuxPackage(t, format))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := a.createWorkDir(); err != nil {
+					t.Fatal(err)
+				}
+				files, err := a.prepareEntriesToSign(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(files) != 1 {
+					t.Fatalf("prepareEntriesToSign returned %v files, want 1", len(files))
+				}
+				if tt.sig != nil {
+					if err := os.WriteFile(files[0].fullPath, tt.sig, 0o666); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := a.repackSignedEntries(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+				if embedded := a.repackedPath != ""; embedded != tt.wantEmbed {
+					t.Errorf("embedded a signature = %v, want %v", embedded, tt.wantEmbed)
+				}
+			})
+		}
+	}
+}
+
+// writeGoArchive writes a Go distribution archive with the given name to the temp dir. Each entry
+// has its name as its content.
+func writeGoArchive(t *testing.T, name string, entries []string) string {
+	p := filepath.Join(t.TempDir(), name)
+	var err error
+	if strings.HasSuffix(name, ".zip") {
+		err = goarchive.WithZipCreate(p, func(zw *zip.Writer) error {
+			for _, e := range entries {
+				w, err := zw.Create(e)
+				if err != nil {
+					return err
+				}
+				if _, err := io.WriteString(w, e); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	} else {
+		err = goarchive.WithTarGzCreate(p, 1, func(tw *tar.Writer) error {
+			for _, e := range entries {
+				if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: e, Mode: 0o755, Size: int64(len(e))}); err != nil {
+					return err
+				}
+				if _, err := io.WriteString(tw, e); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestRepackArchive(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		// wantSigned are the entries that are signed and replaced in the repacked archive. If
+		// empty, the archive isn't repacked.
+		wantSigned []string
+	}{
+		{
+			"go1.23.1-1.windows-amd64.zip",
+			[]string{"go/bin/go.exe", "go/pkg/tool/windows_amd64/vet.exe", "go/src/fmt/print.go"},
+			[]string{"go/bin/go.exe", "go/pkg/tool/windows_amd64/vet.exe"},
+		},
+		{
+			"go1.23.1-1.linux-amd64.tar.gz",
+			[]string{"go/bin/go", "go/pkg/tool/linux_amd64/vet", "go/src/fmt/print.go"},
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupState(t)
+			var err error
+			if policy, err = loadPolicy(filepath.Join("..", "..", "..", "signing", "policy.json")); err != nil {
+				t.Fatal(err)
+			}
+
+			a, err := newArchive(writeGoArchive(t, tt.name, tt.entries))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := a.createWorkDir(); err != nil {
+				t.Fatal(err)
+			}
+			files, err := a.prepareEntriesToSign(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range files {
+				if err := os.WriteFile(f.fullPath, []byte("signed"), 0o666); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := a.repackSignedEntries(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(tt.wantSigned) == 0 {
+				if a.repackedPath != "" {
+					t.Errorf("repackedPath = %q, want the archive not to be repacked", a.repackedPath)
+				}
+				if _, err := os.Stat(filepath.Join(a.workDir, a.name+".WithSignedContent")); !os.IsNotExist(err) {
+					t.Errorf("repacked archive exists or failed to stat: %v", err)
+				}
+				return
+			}
+			if a.repackedPath == "" {
+				t.Fatal("repackedPath is empty, want a repacked archive")
+			}
+			repacked := *a.source()
+			repacked.Path = a.repackedPath
+			var signed []string
+			if err := repacked.Walk(func(e *goarchive.Entry, r io.Reader) error {
+				content, err := io.ReadAll(r)
+				if err != nil {
+					return err
+				}
+				if string(content) == "signed" {
+					signed = append(signed, e.Name)
+				} else if string(content) != e.Name {
+					t.Errorf("entry %q content = %q, want its original content", e.Name, content)
+				}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(signed, tt.wantSigned) {
+				t.Errorf("signed entries = %v, want %v", signed, tt.wantSigned)
+			}
+		})
+	}
+}
+
+func TestFindArchivesSkipsChecksums(t *testing.T) {
+	dir := t.TempDir()
+	archives := []string{"go1.23.1-1.linux-amd64.tar.gz", "go1.23.1-1.windows-amd64.zip"}
+	names := append([]string{"go1.23.1-1.linux-amd64.deb.manifest.json"}, archives...)
+	for _, a := range checksum.Algorithms {
+		names = append(names, archives[0]+a.Ext(), archives[1]+a.Ext(), a.ManifestName())
+	}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	found, err := findArchives(context.Background(), filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, a := range found {
+		got = append(got, a.name)
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, archives) {
+		t.Errorf("findArchives = %v, want %v", got, archives)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	goarchive "github.com/microsoft/go/_util/internal/archive"
+	"github.com/microsoft/go/_util/internal/checksum"
+)
+
+// auditFilename is the audit log in the destination dir. Each line is a JSON auditRecord. The
+// log is only appended to, so a resumed run adds to the records of the runs before it.
+const auditFilename = "sign-audit.jsonl"
+
+// auditRecord describes one file that was sent to the signing service.
+type auditRecord struct {
+	Time time.Time `json:"time"`
+	Step string    `json:"step"`
+	// Archive is the original archive the file came from.
+	Archive string `json:"archive"`
+	// Entry is the archive entry, if the file is an entry that was signed individually.
+	Entry string `json:"entry,omitempty"`
+	// Entries are the archive entries in the file, if the file is a bundle of entries.
+	Entries []string `json:"entries,omitempty"`
+	// File is the path of the file that was signed.
+	File         string `json:"file"`
+	Authenticode string `json:"authenticode"`
+	MacAppName   string `json:"macAppName,omitempty"`
+
+	PreSignSHA256  string `json:"preSignSHA256"`
+	PostSignSHA256 string `json:"postSignSHA256"`
+	// EntryHashes are the hashes of each of Entries, if the file is a bundle.
+	EntryHashes []*entryHashes `json:"entryHashes,omitempty"`
+
+	SignType string `json:"signType"`
+	DryRun   bool   `json:"dryRun"`
+	// Binlog is the filename of the MSBuild binary log of the signing call, copied next to the
+	// audit log. Empty for a dry run.
+	Binlog string `json:"binlog,omitempty"`
+}
+
+// entryHashes are the hashes of one archive entry in a bundle.
+type entryHashes struct {
+	Entry          string `json:"entry"`
+	PreSignSHA256  string `json:"preSignSHA256"`
+	PostSignSHA256 string `json:"postSignSHA256"`
+}
+
+// fileHashes are the hashes of a file sent to the signing service.
+type fileHashes struct {
+	sha256 string
+	// entries maps the name of each file in a bundle zip to its SHA-256. Nil if the file isn't
+	// a bundle.
+	entries map[string]string
+}
+
+// hashFiles returns the SHA-256 of each file's content, and of each entry in a bundle.
+func hashFiles(files []*fileToSign) ([]fileHashes, error) {
+	hashes := make([]fileHashes, len(files))
+	err := forEachIndexParallel(files, *parallelism, func(i int, f *fileToSign) error {
+		h, err := checksum.File(f.fullPath, checksum.SHA256)
+		if err != nil {
+			return err
+		}
+		hashes[i].sha256 = h
+		if len(f.entries) > 0 {
+			hashes[i].entries, err = zipEntrySHA256(f.fullPath)
+		}
+		return err
+	})
+	return hashes, err
+}
+
+// zipEntrySHA256 returns the SHA-256 of each file in the zip at p, by name.
+func zipEntrySHA256(p string) (map[string]string, error) {
+	sums := make(map[string]string)
+	err := goarchive.WithZipOpen(p, func(zr *zip.ReadCloser) error {
+		for _, zf := range zr.File {
+			if zf.FileInfo().IsDir() {
+				continue
+			}
+			r, err := zf.Open()
+			if err != nil {
+				return err
+			}
+			h := checksum.NewHasher(checksum.SHA256)
+			_, err = io.Copy(h, r)
+			if err := cmp.Or(err, r.Close()); err != nil {
+				return err
+			}
+			sums[zf.Name] = h.Sums()[checksum.SHA256]
+		}
+		return nil
+	})
+	return sums, err
+}
+
+// copyBinlog copies the binlog of a signing call next to the audit log, so it ships with the
+// signed files, and returns its filename. It returns "" if binlog is "".
+func copyBinlog(binlog string) (string, error) {
+	if binlog == "" {
+		return "", nil
+	}
+	if err := os.MkdirAll(*destinationDir, 0o777); err != nil {
+		return "", err
+	}
+	name := filepath.Base(binlog)
+	if err := goarchive.CopyFile(filepath.Join(*destinationDir, name), binlog); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// appendAudit appends a record for each signed file to the audit log. binlog is the filename
+// returned by copyBinlog.
+func appendAudit(step string, files []*fileToSign, pre, post []fileHashes, binlog string) error {
+	records := make([]*auditRecord, len(files))
+	now := time.Now().UTC()
+	for i, fts := range files {
+		r := &auditRecord{
+			Time:           now,
+			Step:           step,
+			Archive:        fts.originalPath,
+			Entry:          fts.entry,
+			Entries:        fts.entries,
+			File:           fts.fullPath,
+			Authenticode:   fts.authenticode,
+			MacAppName:     fts.macAppName,
+			PreSignSHA256:  pre[i].sha256,
+			PostSignSHA256: post[i].sha256,
+			SignType:       *signType,
+			DryRun:         *dryRun,
+			Binlog:         binlog,
+		}
+		// The bundle zip has each entry under its base name.
+		for _, e := range fts.entries {
+			base := path.Base(e)
+			preSum, ok := pre[i].entries[base]
+			if !ok {
+				return fmt.Errorf("bundle %q doesn't contain %q before signing", fts.fullPath, base)
+			}
+			postSum, ok := post[i].entries[base]
+			if !ok {
+				return fmt.Errorf("bundle %q doesn't contain %q after signing", fts.fullPath, base)
+			}
+			r.EntryHashes = append(r.EntryHashes, &entryHashes{
+				Entry:          e,
+				PreSignSHA256:  preSum,
+				PostSignSHA256: postSum,
+			})
+		}
+		records[i] = r
+	}
+
+	if err := os.MkdirAll(*destinationDir, 0o777); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(*destinationDir, auditFilename), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o666)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, r := range records {
+		if err = enc.Encode(r); err != nil {
+			break
+		}
+	}
+	return cmp.Or(err, f.Close())
+}
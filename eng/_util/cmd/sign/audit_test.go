@@ -0,0 +1,168 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	goarchive "github.com/microsoft/go/_util/internal/archive"
+)
+
+// setupAudit points the destination dir at a new temp dir, and restores it when the test ends.
+func setupAudit(t *testing.T) {
+	oldDestinationDir := *destinationDir
+	t.Cleanup(func() { *destinationDir = oldDestinationDir })
+	*destinationDir = t.TempDir()
+	setupState(t)
+}
+
+func sha256String(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// writeBundle writes a bundle zip with the given files, by name, and returns its path.
+func writeBundle(t *testing.T, p string, files map[string]string) string {
+	if err := goarchive.WithZipCreate(p, func(zw *zip.Writer) error {
+		for name, content := range files {
+			w, err := zw.Create(name)
+			if err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, content); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// readAudit returns the records in the audit log.
+func readAudit(t *testing.T) []*auditRecord {
+	f, err := os.Open(filepath.Join(*destinationDir, auditFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var records []*auditRecord
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var r auditRecord
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, &r)
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestAudit(t *testing.T) {
+	setupAudit(t)
+	dir := t.TempDir()
+	single := filepath.Join(dir, "go.exe")
+	if err := os.WriteFile(single, []byte("go"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	bundle := writeBundle(t, filepath.Join(dir, "bundle.zip"), map[string]string{"go": "go", "vet": "vet"})
+	files := []*fileToSign{
+		{originalPath: "a.zip", fullPath: single, authenticode: "Microsoft400", entry: "go/bin/go.exe"},
+		{originalPath: "b.tar.gz", fullPath: bundle, authenticode: "MacDeveloperHarden", entries: []string{"go/bin/go", "go/pkg/tool/darwin_arm64/vet"}, zip: true},
+	}
+
+	pre, err := hashFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Simulate the signing service changing one file in the bundle.
+	writeBundle(t, bundle, map[string]string{"go": "signed go", "vet": "vet"})
+	post, err := hashFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := appendAudit("1-Individual", files, pre, post, "Sign1-Individual-1.binlog"); err != nil {
+		t.Fatal(err)
+	}
+	// A second call, like a later step or a resumed run, appends to the log.
+	if err := appendAudit("3-Sigs", files[:1], pre[:1], post[:1], ""); err != nil {
+		t.Fatal(err)
+	}
+
+	records := readAudit(t)
+	if len(records) != 3 {
+		t.Fatalf("audit log has %v records, want 3", len(records))
+	}
+	if r := records[0]; r.Entry != "go/bin/go.exe" || r.PreSignSHA256 != sha256String("go") || r.PostSignSHA256 != r.PreSignSHA256 || r.EntryHashes != nil {
+		t.Errorf("individual file record = %+v", r)
+	}
+	r := records[1]
+	if r.Binlog != "Sign1-Individual-1.binlog" || r.Step != "1-Individual" || !r.DryRun || r.SignType != "test" {
+		t.Errorf("bundle record = %+v", r)
+	}
+	if r.PreSignSHA256 == r.PostSignSHA256 {
+		t.Errorf("bundle hash didn't change after signing: %v", r.PreSignSHA256)
+	}
+	wantEntries := []*entryHashes{
+		{"go/bin/go", sha256String("go"), sha256String("signed go")},
+		{"go/pkg/tool/darwin_arm64/vet", sha256String("vet"), sha256String("vet")},
+	}
+	if !reflect.DeepEqual(r.EntryHashes, wantEntries) {
+		t.Errorf("bundle entry hashes = %+v, want %+v", r.EntryHashes, wantEntries)
+	}
+	if r := records[2]; r.Step != "3-Sigs" || r.Binlog != "" {
+		t.Errorf("appended record = %+v", r)
+	}
+}
+
+func TestAuditMissingBundleEntry(t *testing.T) {
+	setupAudit(t)
+	bundle := writeBundle(t, filepath.Join(t.TempDir(), "bundle.zip"), map[string]string{"go": "go"})
+	files := []*fileToSign{
+		{originalPath: "b.tar.gz", fullPath: bundle, entries: []string{"go/bin/go", "go/bin/gofmt"}, zip: true},
+	}
+	hashes, err := hashFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := appendAudit("1-Individual", files, hashes, hashes, ""); err == nil {
+		t.Error("appendAudit succeeded for a bundle missing an entry, want error")
+	}
+}
+
+func TestCopyBinlog(t *testing.T) {
+	setupAudit(t)
+	binlog := filepath.Join(t.TempDir(), "Sign1-Individual-123.binlog")
+	if err := os.WriteFile(binlog, []byte("binlog"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	name, err := copyBinlog(binlog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != filepath.Base(binlog) {
+		t.Errorf("copyBinlog = %q, want %q", name, filepath.Base(binlog))
+	}
+	if got, err := os.ReadFile(filepath.Join(*destinationDir, name)); err != nil || string(got) != "binlog" {
+		t.Errorf("copied binlog = %q, %v, want %q", got, err, "binlog")
+	}
+
+	if name, err := copyBinlog(""); name != "" || err != nil {
+		t.Errorf(`copyBinlog("") = %q, %v, want "", nil`, name, err)
+	}
+}
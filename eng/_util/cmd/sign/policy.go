@@ -0,0 +1,271 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	goarchive "github.com/microsoft/go/_util/internal/archive"
+)
+
+// policyVersion is the only policy file version this command understands. Increment it when a
+// change to the format would make an older sign command misinterpret a newer policy.
+const policyVersion = 1
+
+const (
+	// certificateNone marks entries that are intentionally not signed individually.
+	certificateNone = "none"
+
+	// batchNone signs each matching entry as an individual file.
+	batchNone = "none"
+	// batchZip puts all matching entries of an archive that use the same certificate into one zip
+	// that is sent to the signing service, then reads the signed entries from the returned zip.
+	batchZip = "zip"
+)
+
+// signPolicy is the content of the policy file. It describes which archive entries are signed,
+// with which certificate, and how.
+type signPolicy struct {
+	Version int         `json:"version"`
+	Rules   []*signRule `json:"rules"`
+
+	// sha256 is the hash of the policy file, recorded in the state file.
+	sha256 string
+}
+
+type signRule struct {
+	// Description explains the rule. It's included in validation errors.
+	Description string `json:"description"`
+	// ArchiveType is the archive format the rule applies to: "zip" or "tar.gz".
+	ArchiveType string `json:"archiveType"`
+	// Archive is a glob matched against the archive filename. Empty matches any archive.
+	Archive string `json:"archive,omitempty"`
+	// Glob is matched against the slash-separated entry name. "**" matches any number of path
+	// elements, and the other syntax is the same as path.Match.
+	Glob string `json:"glob"`
+	// Exclude are globs, with the same syntax as Glob, for entries the rule doesn't apply to even
+	// though they match Glob.
+	Exclude []string `json:"exclude,omitempty"`
+	// Certificate is the MicroBuild certificate name, or "none" to leave the entries unsigned.
+	Certificate string `json:"certificate"`
+	// Batch is "none" or "zip". Defaults to "none".
+	Batch string `json:"batch,omitempty"`
+}
+
+func (r *signRule) String() string {
+	s := fmt.Sprintf("%v %q %q", r.ArchiveType, r.Archive, r.Glob)
+	if r.Description != "" {
+		s += " (" + r.Description + ")"
+	}
+	return s
+}
+
+// loadPolicy reads and checks the policy file at p.
+func loadPolicy(p string) (*signPolicy, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %v", err)
+	}
+	var sp signPolicy
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&sp); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file %q: %v", p, err)
+	}
+	if sp.Version != policyVersion {
+		return nil, fmt.Errorf("policy file %q has version %v, but this command only supports version %v", p, sp.Version, policyVersion)
+	}
+	sum := sha256.Sum256(data)
+	sp.sha256 = hex.EncodeToString(sum[:])
+	var errs []error
+	for i, r := range sp.Rules {
+		if r.Batch == "" {
+			r.Batch = batchNone
+		}
+		fail := func(format string, args ...any) {
+			errs = append(errs, fmt.Errorf("rule %v: %v: %v", i, r, fmt.Sprintf(format, args...)))
+		}
+		if r.ArchiveType != goarchive.Zip.String() && r.ArchiveType != goarchive.TarGz.String() {
+			fail("unknown archive type %q", r.ArchiveType)
+		}
+		if _, err := filepath.Match(r.Archive, ""); err != nil {
+			fail("invalid archive glob: %v", err)
+		}
+		if r.Glob == "" {
+			fail("glob is empty")
+		} else if err := checkEntryGlob(r.Glob); err != nil {
+			fail("invalid glob: %v", err)
+		}
+		for _, g := range r.Exclude {
+			if err := checkEntryGlob(g); err != nil {
+				fail("invalid exclude glob %q: %v", g, err)
+			}
+		}
+		if r.Certificate == "" {
+			fail("certificate is empty: use %q for entries that aren't signed", certificateNone)
+		}
+		switch r.Batch {
+		case batchNone:
+		case batchZip:
+			if r.Certificate == certificateNone {
+				fail("batch %q requires a certificate", r.Batch)
+			}
+		default:
+			fail("unknown batch mode %q", r.Batch)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("invalid policy file %q:\n%v", p, err)
+	}
+	return &sp, nil
+}
+
+// rules returns the rules that apply to entry name in a.
+func (sp *signPolicy) rules(a *archive, name string) []*signRule {
+	format, ok := a.kind.archiveFormat()
+	if !ok {
+		return nil
+	}
+	var rules []*signRule
+	for _, r := range sp.Rules {
+		if r.ArchiveType != format.String() {
+			continue
+		}
+		if r.Archive != "" && !matchOrPanic(r.Archive, a.name) {
+			continue
+		}
+		if matchEntryGlob(r.Glob, name) && !slices.ContainsFunc(r.Exclude, func(g string) bool {
+			return matchEntryGlob(g, name)
+		}) {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// matchEntryGlob reports whether name matches pattern. Pattern syntax is the same as path.Match,
+// except that a "**" path element matches zero or more path elements. The pattern must have been
+// checked by checkEntryGlob.
+func matchEntryGlob(pattern, name string) bool {
+	return matchGlobElems(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchGlobElems(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchGlobElems(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// checkEntryGlob returns an error if pattern is malformed.
+func checkEntryGlob(pattern string) error {
+	for _, p := range strings.Split(pattern, "/") {
+		if _, err := path.Match(p, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// executableExtensions are file extensions of executables and shared libraries. Files with these
+// extensions are treated as executables even if their content isn't recognized.
+var executableExtensions = []string{".exe", ".dll", ".so", ".dylib"}
+
+// executableMagics are the leading bytes of PE, ELF, and Mach-O files.
+var executableMagics = [][]byte{
+	[]byte("MZ"),
+	[]byte("\x7fELF"),
+	{0xfe, 0xed, 0xfa, 0xce},
+	{0xce, 0xfa, 0xed, 0xfe},
+	{0xfe, 0xed, 0xfa, 0xcf},
+	{0xcf, 0xfa, 0xed, 0xfe},
+	// Universal binary.
+	{0xca, 0xfe, 0xba, 0xbe},
+}
+
+// isExecutable reports whether the entry name with content starting with head is an executable or
+// a shared library.
+func isExecutable(name string, head []byte) bool {
+	if slices.Contains(executableExtensions, path.Ext(name)) || strings.Contains(path.Base(name), ".so.") {
+		return true
+	}
+	for _, m := range executableMagics {
+		if bytes.HasPrefix(head, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePolicy checks that every executable in a matches exactly one policy rule, and that no
+// other entry matches more than one. All problems are reported in one error so the policy can be
+// fixed in one pass.
+func (a *archive) validatePolicy() error {
+	if _, ok := a.kind.archiveFormat(); !ok {
+		return nil
+	}
+	var problems []string
+	var executables int
+	err := a.source().Walk(func(e *goarchive.Entry, r io.Reader) error {
+		if r == nil {
+			return nil
+		}
+		head := make([]byte, 4)
+		n, err := io.ReadFull(r, head)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return err
+		}
+		rules := policy.rules(a, e.Name)
+		if len(rules) > 1 {
+			var b strings.Builder
+			fmt.Fprintf(&b, "%q matches %v rules:", e.Name, len(rules))
+			for _, r := range rules {
+				fmt.Fprintf(&b, "\n    %v", r)
+			}
+			problems = append(problems, b.String())
+		}
+		if isExecutable(e.Name, head[:n]) {
+			executables++
+			if len(rules) == 0 {
+				problems = append(problems, fmt.Sprintf("executable %q doesn't match any rule", e.Name))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to validate signing policy for %q: %v", a.path, err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("signing policy %q doesn't classify %q:\n  %v", *policyPath, a.path, strings.Join(problems, "\n  "))
+	}
+	log.Printf("Validated signing policy for %q: %v executables classified", a.path, executables)
+	return nil
+}
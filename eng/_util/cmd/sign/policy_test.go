@@ -0,0 +1,81 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchEntryGlob(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"go/bin/*", "go/bin/go", true},
+		{"go/bin/*", "go/bin/sub/go", false},
+		{"go/pkg/tool/*/*.exe", "go/pkg/tool/windows_amd64/vet.exe", true},
+		{"go/src/**", "go/src/runtime/race/race_linux_amd64.syso", true},
+		{"go/src/**", "go/src", true},
+		{"go/**/testdata/*", "go/src/debug/elf/testdata/gcc-amd64", true},
+		{"go/**/testdata/*", "go/testdata/x", true},
+		{"go/**/testdata/*", "go/src/testdata", false},
+		{"**", "go/bin/go", true},
+		{"**/*.exe", "go/bin/go.exe", true},
+		{"**/*.exe", "go.exe", true},
+		{"**/*.exe", "go/bin/go.exe/x", false},
+	}
+	for _, tt := range tests {
+		if got := matchEntryGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchEntryGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRepoPolicy(t *testing.T) {
+	sp, err := loadPolicy(filepath.Join("..", "..", "..", "signing", "policy.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sp.Rules) == 0 {
+		t.Error("policy has no rules")
+	}
+}
+
+func TestRepoPolicyRules(t *testing.T) {
+	oldPolicy := policy
+	defer func() { policy = oldPolicy }()
+	var err error
+	if policy, err = loadPolicy(filepath.Join("..", "..", "..", "signing", "policy.json")); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		archive, entry, certificate string
+	}{
+		{"go1.23.1-1.windows-amd64.zip", "go/bin/go.exe", "Microsoft400"},
+		{"go1.23.1-1.windows-amd64.zip", "go/pkg/tool/windows_amd64/vet.exe", "Microsoft400"},
+		// Every .exe in a zip is signed, including testdata.
+		{"go1.23.1-1.windows-amd64.zip", "go/src/debug/pe/testdata/x.exe", "Microsoft400"},
+		{"go1.23.1-1.windows-amd64.zip", "go/test/fixedbugs/x.exe", "Microsoft400"},
+		{"go1.23.1-1.windows-amd64.zip", "go/src/debug/elf/testdata/gcc-amd64-linux-exec", certificateNone},
+		{"go1.23.1-1.darwin-arm64.tar.gz", "go/bin/go", "MacDeveloperHarden"},
+		{"go1.23.1-1.darwin-arm64.tar.gz", "go/pkg/tool/darwin_arm64/vet", "MacDeveloperHarden"},
+		{"go1.23.1-1.linux-amd64.tar.gz", "go/bin/go", certificateNone},
+	}
+	for _, tt := range tests {
+		a, err := newArchive(tt.archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules := policy.rules(a, tt.entry)
+		if len(rules) != 1 {
+			t.Errorf("%v %v matches %v rules, want 1", tt.archive, tt.entry, len(rules))
+			continue
+		}
+		if rules[0].Certificate != tt.certificate {
+			t.Errorf("%v %v certificate = %q, want %q", tt.archive, tt.entry, rules[0].Certificate, tt.certificate)
+		}
+	}
+}
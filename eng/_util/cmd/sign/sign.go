@@ -13,6 +13,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
 	"time"
 
@@ -26,9 +28,21 @@ Use '-n' to test the command locally.
 Signs in multiple passes. Some steps only apply to certain types of archives:
 
 1. Archive entries. Extracts specific entries from inside each archive, signs, and repacks.
-2. Notarize. macOS archives get a notarization ticket attached to the tar.gz.
-3. Signatures. Creates sig files for each archive.
-4. Locally creates a .sha256 file for each archive.
+   The entries and certificates are listed in the '-policy' file. Before signing, every
+   executable in each archive must match exactly one rule in the policy.
+   With '-package-signature embedded', RPM and DEB packages get a signature embedded here.
+   MSI installers are Authenticode signed here, and macOS packages are signed.
+2. Notarize. macOS packages are notarized and get the notarization ticket stapled.
+3. Signatures. Creates sig files for each archive, and for PDB symbol store bundles
+   (go*.symbols.tar), which aren't otherwise signed.
+4. Locally creates a checksum file for each archive, such as .sha256, for each algorithm in
+   '-checksums'. Files are hashed while they are copied to the destination dir.
+
+Each file sent to the signing service is recorded in sign-audit.jsonl in the destination
+dir, with its hashes before and after signing.
+
+Progress is recorded in a state file in the temp dir. If a run fails, use '-resume' to retry
+without repeating the steps that already succeeded.
 
 See /eng/_util/cmd/sign/README.md for more information.
 `
@@ -46,8 +60,32 @@ var (
 			"Any MSBuild processes launched by this tool are be manually killed. "+
 			"If set to a value lower than AzDO pipeline timeout, this helps avoid pipeline breakage when uploading MSBuild outputs.")
 	dryRun = flag.Bool("n", false, "Dry run: don't run the MSBuild signing tooling at all, even in test mode. This works on non-Windows platforms.")
+
+	resume = flag.Bool("resume", false,
+		"Resume a previous run that used the same -temp-dir. Steps that were completed for an archive are skipped "+
+			"if the archive and the files produced by the step are unchanged.")
+
+	packageSignature = flag.String("package-signature", "detached",
+		"How to sign RPM and DEB packages. Options: detached (only create a .sig file, like archives), "+
+			"embedded (also embed a signature that rpm and debsig-verify check).")
+
+	policyPath = flag.String("policy", "eng/signing/policy.json",
+		"Signing policy file: which archive entries to sign, with which certificate, and how.")
+
+	checksums = flag.String("checksums", "sha256",
+		"Comma-separated checksum algorithms to write for each archive. Options: sha256, sha384, sha512, blake2b.")
+
+	parallelism = flag.Int("parallel", runtime.NumCPU(),
+		"Maximum number of archives to extract and repack at the same time. When there are fewer "+
+			"archives than this, the rest is split between them as gzip compression goroutines.")
 )
 
+// policy is the signing policy loaded from policyPath.
+var policy *signPolicy
+
+// checksumAlgorithms is parsed from checksums.
+var checksumAlgorithms []checksum.Algorithm
+
 func main() {
 	help := flag.Bool("h", false, "Print this help message.")
 
@@ -63,6 +101,25 @@ func main() {
 		return
 	}
 
+	if *parallelism < 1 {
+		log.Printf("error: -parallel must be at least 1, got %v", *parallelism)
+		os.Exit(1)
+	}
+
+	if *packageSignature != "detached" && *packageSignature != "embedded" {
+		log.Printf("error: -package-signature must be detached or embedded, got %q", *packageSignature)
+		os.Exit(1)
+	}
+
+	for _, s := range strings.Split(*checksums, ",") {
+		a, err := checksum.ParseAlgorithm(strings.TrimSpace(s))
+		if err != nil {
+			log.Printf("error: -checksums: %v", err)
+			os.Exit(1)
+		}
+		checksumAlgorithms = append(checksumAlgorithms, a)
+	}
+
 	if err := run(); err != nil {
 		log.Printf("error: %v", err)
 		os.Exit(1)
@@ -73,7 +130,8 @@ func run() error {
 	// A context for timeout. This timeout is mainly here to make sure child MSBuild processes are
 	// terminated. There are some ctx.Err() checks sprinkled into the Go code, but canceling
 	// quickly during the packaging/repackaging work in Go is not currently important: the Go work
-	// takes an insignificant amount of time compared to the signing service calls in MSBuild.
+	// takes much less time than the signing service calls in MSBuild, especially now that
+	// archives are processed in parallel.
 	var ctx context.Context
 	if *timeout == 0 {
 		ctx = context.Background()
@@ -83,79 +141,149 @@ func run() error {
 		defer cancel()
 	}
 
+	var err error
+	if policy, err = loadPolicy(*policyPath); err != nil {
+		return err
+	}
+
 	archives, err := findArchives(ctx, *filesGlob)
 	if err != nil {
 		return err
 	}
+	// Each archive worker compresses with its share of the parallelism, so the total number of
+	// goroutines doing gzip compression stays at about -parallel.
+	for _, a := range archives {
+		a.concurrency = max(1, *parallelism/len(archives))
+	}
 
-	log.Println("Signing individual files extracted from archives")
+	log.Printf("Validating archive contents against signing policy %q", *policyPath)
 
-	individualFilesToSign, err := flatMapSlice(archives, func(a *archive) ([]*fileToSign, error) {
-		return a.prepareEntriesToSign(ctx)
-	})
-	if err != nil {
+	if err := forEachParallel(archives, *parallelism, (*archive).validatePolicy); err != nil {
 		return err
 	}
 
-	if err := sign(ctx, "1-Individual", individualFilesToSign); err != nil {
+	state, err := loadState(*resume)
+	if err != nil {
 		return err
 	}
-
+	paths := make([]string, len(archives))
+	for i, a := range archives {
+		paths[i] = a.path
+	}
+	inputHashes, err := sha256Files(paths)
+	if err != nil {
+		return err
+	}
+	for i, a := range archives {
+		a.inputSHA256 = inputHashes[i]
+	}
 	for _, a := range archives {
-		if err := a.repackSignedEntries(ctx); err != nil {
+		if err := state.initArchive(a); err != nil {
 			return err
 		}
 	}
+	if err := state.save(); err != nil {
+		return err
+	}
+
+	log.Println("Signing individual files extracted from archives")
+
+	if err := runStep(ctx, state, "1-Individual", archives, (*archive).prepareEntriesToSign, (*archive).repackSignedEntries); err != nil {
+		return err
+	}
 
 	log.Println("Notarizing macOS archives")
 
-	filesToNotarize, err := flatMapSlice(archives, func(a *archive) ([]*fileToSign, error) {
-		return a.prepareNotarize(ctx)
-	})
-	if err != nil {
+	if err := runStep(ctx, state, "2-Notarize", archives, (*archive).prepareNotarize, (*archive).unpackNotarize); err != nil {
+		return err
+	}
+
+	log.Println("Creating signature files")
+
+	if err := runStep(ctx, state, "3-Sigs", archives, (*archive).prepareArchiveSignatures, nil); err != nil {
 		return err
 	}
 
-	if err := sign(ctx, "2-Notarize", filesToNotarize); err != nil {
+	log.Println("Copying finished files to destination")
+
+	if err := forEachParallel(archives, *parallelism, func(a *archive) error {
+		return a.copyToDestination(ctx)
+	}); err != nil {
 		return err
 	}
 
+	log.Println("Generating checksum files")
+
 	for _, a := range archives {
-		if err := a.unpackNotarize(ctx); err != nil {
+		written, err := checksum.WriteChecksumFiles(filepath.Join(*destinationDir, a.name), a.destinationSums)
+		if err != nil {
 			return err
 		}
+		for _, w := range written {
+			log.Printf("Wrote checksum file %q\n", w)
+		}
 	}
 
-	log.Println("Creating signature files")
+	return nil
+}
 
-	signatureFiles, err := flatMapSlice(archives, func(a *archive) ([]*fileToSign, error) {
-		return a.prepareArchiveSignatures(ctx)
+// runStep runs one signing step for the archives that haven't already completed it according to
+// state. prepare returns the files to sign for an archive, and finish (if not nil) processes the
+// signed files. The state is saved when the step is complete.
+func runStep(
+	ctx context.Context, state *signState, step string, archives []*archive,
+	prepare func(*archive, context.Context) ([]*fileToSign, error),
+	finish func(*archive, context.Context) error,
+) error {
+	restored := make([]bool, len(archives))
+	if err := forEachIndexParallel(archives, *parallelism, func(i int, a *archive) error {
+		restored[i] = state.restoreStep(a, step)
+		return nil
+	}); err != nil {
+		return err
+	}
+	var pending []*archive
+	for i, a := range archives {
+		if restored[i] {
+			log.Printf("Skipping step %q for %q: completed by a previous run", step, a.path)
+			continue
+		}
+		pending = append(pending, a)
+	}
+
+	files, err := flatMapSliceParallel(pending, *parallelism, func(a *archive) ([]*fileToSign, error) {
+		return prepare(a, ctx)
 	})
 	if err != nil {
 		return err
 	}
 
-	if err := sign(ctx, "3-Sigs", signatureFiles); err != nil {
+	if err := sign(ctx, step, files); err != nil {
 		return err
 	}
 
-	log.Println("Copying finished files to destination")
-
-	for _, a := range archives {
-		if err := a.copyToDestination(ctx); err != nil {
+	if finish != nil {
+		if err := forEachParallel(pending, *parallelism, func(a *archive) error {
+			return finish(a, ctx)
+		}); err != nil {
 			return err
 		}
 	}
 
-	log.Println("Generating checksum files")
-
-	for _, a := range archives {
-		if err := checksum.WriteSHA256ChecksumFile(filepath.Join(*destinationDir, a.name)); err != nil {
-			return err
-		}
+	if err := forEachParallel(pending, *parallelism, func(a *archive) error {
+		return state.completeStep(a, step)
+	}); err != nil {
+		return err
 	}
+	return state.save()
+}
 
-	return nil
+// isChecksumFile reports whether p is a checksum file or manifest that the checksum package writes.
+func isChecksumFile(p string) bool {
+	name := filepath.Base(p)
+	return slices.ContainsFunc(checksum.Algorithms, func(a checksum.Algorithm) bool {
+		return strings.HasSuffix(name, a.Ext()) || name == a.ManifestName()
+	})
 }
 
 func findArchives(ctx context.Context, glob string) ([]*archive, error) {
@@ -178,7 +306,11 @@ func findArchives(ctx context.Context, glob string) ([]*archive, error) {
 			return nil, err
 		}
 		// Ignore checksum files: we always generate new ones.
-		if strings.HasSuffix(f, ".sha256") {
+		if isChecksumFile(f) {
+			continue
+		}
+		// Ignore Linux package manifests created by the linuxpkg command: they aren't signed.
+		if strings.HasSuffix(f, ".manifest.json") {
 			continue
 		}
 
@@ -208,6 +340,27 @@ func sign(ctx context.Context, step string, files []*fileToSign) error {
 		return nil
 	}
 
+	pre, err := hashFiles(files)
+	if err != nil {
+		return err
+	}
+	binlog, err := runSigning(ctx, step, files)
+	if err != nil {
+		return err
+	}
+	post, err := hashFiles(files)
+	if err != nil {
+		return err
+	}
+	if binlog, err = copyBinlog(binlog); err != nil {
+		return err
+	}
+	return appendAudit(step, files, pre, post, binlog)
+}
+
+// runSigning runs the MSBuild signing tooling for files, and returns the path of the binlog. In
+// a dry run, it does nothing and returns "".
+func runSigning(ctx context.Context, step string, files []*fileToSign) (string, error) {
 	var sb strings.Builder
 	sb.WriteString("<Project>\n")
 	sb.WriteString("  <ItemGroup>\n")
@@ -220,21 +373,31 @@ func sign(ctx context.Context, step string, files []*fileToSign) error {
 	log.Printf("Signing with props file content:\n%s\n", sb.String())
 	if *dryRun {
 		log.Printf("Dry run: skipping signing.")
-		return nil
+		return "", nil
 	}
 
 	if err := os.MkdirAll(*tempDir, 0o777); err != nil {
-		return err
+		return "", err
 	}
 	// Get an absolute path to pass to MSBuild, because our working dirs may not be the same.
 	// MSBuild in general will resolve paths relative to the csproj.
 	absTemp, err := filepath.Abs(*tempDir)
 	if err != nil {
-		return err
+		return "", err
 	}
 	propsFilePath := filepath.Join(absTemp, "Sign"+step+".props")
 	if err := os.WriteFile(propsFilePath, []byte(sb.String()), 0o666); err != nil {
-		return err
+		return "", err
+	}
+	// Each call gets its own binlog, so a resumed run doesn't overwrite the binlog of an earlier
+	// call that the audit log refers to.
+	f, err := os.CreateTemp(absTemp, "Sign"+step+"-*.binlog")
+	if err != nil {
+		return "", err
+	}
+	binlog := f.Name()
+	if err := f.Close(); err != nil {
+		return "", err
 	}
 
 	cmd := exec.CommandContext(
@@ -244,20 +407,24 @@ func sign(ctx context.Context, step string, files []*fileToSign) error {
 		"/p:FilesToSignPropsFile="+propsFilePath,
 		"/t:AfterBuild",
 		"/p:SignType="+*signType,
-		"/bl:"+filepath.Join(absTemp, "Sign"+step+".binlog"),
+		"/bl:"+binlog,
 		"/v:n",
 	)
 	cmd.Dir = *signingCsprojDir
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	log.Printf("Running: %v", cmd)
-	return cmd.Run()
+	return binlog, cmd.Run()
 }
 
 type fileToSign struct {
 	originalPath string
 	fullPath     string
 	authenticode string
+	// entry is the archive entry name, if this is an entry signed individually.
+	entry string
+	// entries are the archive entry names in this file, if it's a bundle of entries.
+	entries []string
 	// This file is part of a zip payload, e.g. for macOS hardening.
 	zip bool
 	// macAppName for notarization.
@@ -276,16 +443,47 @@ func (f *fileToSign) WriteMSBuildItem(w io.Writer) {
 	fmt.Fprintf(w, " />\n")
 }
 
-// flatMapSlice sequentially maps each element of es to a slice using f and flattens the resulting
-// slices. If any call to f returns an error, the error is returned immediately.
-func flatMapSlice[E, R any](es []E, f func(E) ([]R, error)) ([]R, error) {
+// flatMapSliceParallel maps each element of es to a slice using f and flattens the resulting
+// slices, calling f concurrently using at most n goroutines. The results are flattened in the same
+// order as es. If any call to f returns an error, no more calls are started, and the error from the
+// earliest element in es is returned once the calls already in progress have finished.
+func flatMapSliceParallel[E, R any](es []E, n int, f func(E) ([]R, error)) ([]R, error) {
+	rs := make([][]R, len(es))
+	if err := forEachIndexParallel(es, n, func(i int, e E) error {
+		var err error
+		rs[i], err = f(e)
+		return err
+	}); err != nil {
+		return nil, err
+	}
 	var results []R
-	for _, e := range es {
-		rs, err := f(e)
-		if err != nil {
-			return nil, err
-		}
-		results = append(results, rs...)
+	for _, r := range rs {
+		results = append(results, r...)
 	}
 	return results, nil
 }
+
+// forEachParallel calls f for each element of es using at most n goroutines. Error handling is
+// the same as flatMapSliceParallel.
+func forEachParallel[E any](es []E, n int, f func(E) error) error {
+	return forEachIndexParallel(es, n, func(_ int, e E) error {
+		return f(e)
+	})
+}
+
+// forEachIndexParallel calls f for each element of es and its index using at most n goroutines.
+// Error handling is the same as flatMapSliceParallel.
+func forEachIndexParallel[E any](es []E, n int, f func(int, E) error) error {
+	return checksum.ForEach(len(es), n, func(i int) error {
+		return f(i, es[i])
+	})
+}
+
+// matchOrPanic returns whether name matches the pattern glob, or panics if pattern is invalid.
+func matchOrPanic(pattern, name string) bool {
+	ok, err := filepath.Match(pattern, name)
+	if err != nil {
+		panic(err)
+	}
+	return ok
+}
@@ -0,0 +1,244 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/microsoft/go/_util/internal/checksum"
+)
+
+const stateFilename = "sign-state.json"
+
+// steps is the ordered list of signing steps. Each archive's state is only valid for a prefix of
+// this list: redoing a step invalidates the later ones.
+var steps = []string{"1-Individual", "2-Notarize", "3-Sigs"}
+
+// signState is a checkpoint of a signing run, stored in the temp dir. It allows a run with the
+// "-resume" flag to skip the steps that were already completed for each archive, as long as the
+// archive and the files produced by the step haven't changed.
+type signState struct {
+	path string
+
+	// SignType, DryRun, and PackageSignature are the options used by the run. Work done with
+	// different options can't be reused: for example, a dry run must not make a real run skip
+	// signing.
+	SignType         string `json:"signType"`
+	DryRun           bool   `json:"dryRun"`
+	PackageSignature string `json:"packageSignature"`
+	// PolicySHA256 is the hash of the signing policy file. A policy change can change which
+	// entries are signed, so it also invalidates the state.
+	PolicySHA256 string `json:"policySHA256"`
+
+	// Archives maps archive name to state.
+	Archives map[string]*archiveState `json:"archives"`
+}
+
+type archiveState struct {
+	Path        string `json:"path"`
+	InputSHA256 string `json:"inputSHA256"`
+	// WorkDir is reused by a resumed run so the files produced by completed steps are found.
+	WorkDir string `json:"workDir"`
+
+	Steps map[string]*stepState `json:"steps"`
+}
+
+type stepState struct {
+	Completed time.Time `json:"completed"`
+
+	// RepackedPath and NotarizedPath are the archive's paths after the step.
+	RepackedPath  string `json:"repackedPath,omitempty"`
+	NotarizedPath string `json:"notarizedPath,omitempty"`
+
+	// Outputs maps each file the archive has after the step to its SHA-256 hash.
+	Outputs map[string]string `json:"outputs"`
+}
+
+// loadState loads the state file from the temp dir if resume is true, or returns an empty state
+// otherwise. The returned state is saved to the temp dir as steps complete.
+func loadState(resume bool) (*signState, error) {
+	newState := func() *signState {
+		return &signState{
+			path:             filepath.Join(*tempDir, stateFilename),
+			SignType:         *signType,
+			DryRun:           *dryRun,
+			PackageSignature: *packageSignature,
+			PolicySHA256:     policy.sha256,
+			Archives:         make(map[string]*archiveState),
+		}
+	}
+	s := newState()
+	if !resume {
+		return s, nil
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			log.Printf("No state file found at %q: starting from the beginning", s.path)
+			return s, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %q: %v", s.path, err)
+	}
+	if s.SignType != *signType || s.DryRun != *dryRun || s.PackageSignature != *packageSignature {
+		log.Printf(
+			"State file %q is from a run with -sign-type %q, -n=%v, and -package-signature %q: starting from the beginning",
+			s.path, s.SignType, s.DryRun, s.PackageSignature)
+		return newState(), nil
+	}
+	if s.PolicySHA256 != policy.sha256 {
+		log.Printf("State file %q is from a run with a different signing policy: starting from the beginning", s.path)
+		return newState(), nil
+	}
+	if s.Archives == nil {
+		s.Archives = make(map[string]*archiveState)
+	}
+	log.Printf("Loaded state file %q", s.path)
+	return s, nil
+}
+
+func (s *signState) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o777); err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, append(data, '\n'), 0o666)
+}
+
+// initArchive assigns a work dir to a. If the state has a record of the same archive content,
+// its work dir is reused. Otherwise, a new work dir is created and the record is reset.
+func (s *signState) initArchive(a *archive) error {
+	if as, ok := s.Archives[a.name]; ok && as.InputSHA256 == a.inputSHA256 {
+		if info, err := os.Stat(as.WorkDir); err == nil && info.IsDir() {
+			log.Printf("Resuming %q with existing work directory %q", a.path, as.WorkDir)
+			a.workDir = as.WorkDir
+			return nil
+		}
+	}
+	if err := a.createWorkDir(); err != nil {
+		return err
+	}
+	s.Archives[a.name] = &archiveState{
+		Path:        a.path,
+		InputSHA256: a.inputSHA256,
+		WorkDir:     a.workDir,
+		Steps:       make(map[string]*stepState),
+	}
+	return nil
+}
+
+// restoreStep returns true if step was completed for a in a previous run and all the files it
+// produced are unchanged. If so, a's paths are updated to the state after the step. It's safe to
+// call concurrently for different archives.
+func (s *signState) restoreStep(a *archive, step string) bool {
+	as, ok := s.Archives[a.name]
+	if !ok || as.InputSHA256 != a.inputSHA256 || as.WorkDir != a.workDir {
+		return false
+	}
+	ss, ok := as.Steps[step]
+	if !ok {
+		return false
+	}
+	var paths []string
+	for p, want := range ss.Outputs {
+		if h, ok := as.earlierSHA256(step, p); ok && h == want {
+			continue
+		}
+		paths = append(paths, p)
+	}
+	hashes, err := sha256Files(paths)
+	if err != nil {
+		log.Printf("Output of step %q for %q is missing: redoing step: %v", step, a.name, err)
+		return false
+	}
+	for i, p := range paths {
+		if hashes[i] != ss.Outputs[p] {
+			log.Printf("Output %q of step %q for %q changed: redoing step", p, step, a.name)
+			return false
+		}
+	}
+	a.repackedPath = ss.RepackedPath
+	a.notarizedPath = ss.NotarizedPath
+	return true
+}
+
+// completeStep records that step was completed for a, and forgets any later steps. It's safe to
+// call concurrently for different archives.
+func (s *signState) completeStep(a *archive, step string) error {
+	as := s.Archives[a.name]
+	for _, later := range steps[slices.Index(steps, step)+1:] {
+		delete(as.Steps, later)
+	}
+	ss := &stepState{
+		Completed:     time.Now().UTC(),
+		RepackedPath:  a.repackedPath,
+		NotarizedPath: a.notarizedPath,
+		Outputs:       make(map[string]string),
+	}
+	outputs := []string{a.repackedPath, a.notarizedPath}
+	if step == "3-Sigs" {
+		outputs = append(outputs, a.sigPath())
+	}
+	var paths []string
+	for _, p := range outputs {
+		if p == "" {
+			continue
+		}
+		if h, ok := as.earlierSHA256(step, p); ok {
+			ss.Outputs[p] = h
+			continue
+		}
+		paths = append(paths, p)
+	}
+	hashes, err := sha256Files(paths)
+	if err != nil {
+		return err
+	}
+	for i, p := range paths {
+		ss.Outputs[p] = hashes[i]
+	}
+	as.Steps[step] = ss
+	return nil
+}
+
+// earlierSHA256 returns the hash of the output at path recorded by a step before step, if any.
+// Steps run in order, so each earlier step in the state was either completed or restored (and
+// its outputs checked) by this run. A step doesn't modify the outputs of earlier steps, so the
+// hash can be used instead of reading the file again.
+func (as *archiveState) earlierSHA256(step, path string) (string, bool) {
+	for _, earlier := range steps[:slices.Index(steps, step)] {
+		if ss, ok := as.Steps[earlier]; ok {
+			if h, ok := ss.Outputs[path]; ok {
+				return h, true
+			}
+		}
+	}
+	return "", false
+}
+
+// sha256Files returns the SHA-256 of each file in paths, reading the files concurrently.
+func sha256Files(paths []string) ([]string, error) {
+	sums, err := (&checksum.Engine{Concurrency: *parallelism}).Files(paths, checksum.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, len(sums))
+	for i, s := range sums {
+		hashes[i] = s[checksum.SHA256]
+	}
+	return hashes, nil
+}
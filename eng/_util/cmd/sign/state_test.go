@@ -0,0 +1,221 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/go/_util/internal/checksum"
+)
+
+// setupState points the flags that affect the state at a new temp dir, and restores them when
+// the test ends.
+func setupState(t *testing.T) {
+	oldTempDir, oldSignType, oldDryRun, oldPackageSignature, oldPolicy := *tempDir, *signType, *dryRun, *packageSignature, policy
+	t.Cleanup(func() {
+		*tempDir, *signType, *dryRun, *packageSignature, policy = oldTempDir, oldSignType, oldDryRun, oldPackageSignature, oldPolicy
+	})
+	*tempDir = t.TempDir()
+	*signType = "test"
+	*dryRun = true
+	*packageSignature = "detached"
+	policy = &signPolicy{sha256: "policy-hash"}
+}
+
+// newTestArchive returns an archive for a new file in the temp dir with the given content.
+func newTestArchive(t *testing.T, content string) *archive {
+	p := filepath.Join(*tempDir, "go1.23.1-1.linux-amd64.tar.gz")
+	if err := os.WriteFile(p, []byte(content), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	a, err := newArchive(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.inputSHA256, err = checksum.File(p, checksum.SHA256); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// completeTestStep runs a fake first step for a new archive: it writes a repacked file and
+// records the step in a saved state. Returns the repacked path.
+func completeTestStep(t *testing.T, content string) string {
+	s, err := loadState(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := newTestArchive(t, content)
+	if err := s.initArchive(a); err != nil {
+		t.Fatal(err)
+	}
+	a.repackedPath = filepath.Join(a.workDir, a.name+".WithSignedContent")
+	if err := os.WriteFile(a.repackedPath, []byte("repacked"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.completeStep(a, "1-Individual"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.save(); err != nil {
+		t.Fatal(err)
+	}
+	return a.repackedPath
+}
+
+// resumeTestArchive loads the saved state and initializes an archive with content in it.
+func resumeTestArchive(t *testing.T, content string) (*signState, *archive) {
+	s, err := loadState(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := newTestArchive(t, content)
+	if err := s.initArchive(a); err != nil {
+		t.Fatal(err)
+	}
+	return s, a
+}
+
+func TestStateResume(t *testing.T) {
+	setupState(t)
+	repacked := completeTestStep(t, "archive")
+
+	s, a := resumeTestArchive(t, "archive")
+	if a.workDir != filepath.Dir(repacked) {
+		t.Errorf("workDir = %q, want the previous run's %q", a.workDir, filepath.Dir(repacked))
+	}
+	if !s.restoreStep(a, "1-Individual") {
+		t.Fatal("restoreStep = false for an unchanged archive and output, want true")
+	}
+	if a.repackedPath != repacked {
+		t.Errorf("repackedPath = %q, want %q", a.repackedPath, repacked)
+	}
+	if s.restoreStep(a, "2-Notarize") {
+		t.Error("restoreStep = true for a step that wasn't completed, want false")
+	}
+}
+
+func TestStateInputChanged(t *testing.T) {
+	setupState(t)
+	repacked := completeTestStep(t, "archive")
+
+	s, a := resumeTestArchive(t, "changed archive")
+	if a.workDir == filepath.Dir(repacked) {
+		t.Errorf("workDir = %q, want a new work dir for a changed archive", a.workDir)
+	}
+	if s.restoreStep(a, "1-Individual") {
+		t.Error("restoreStep = true for a changed archive, want false")
+	}
+}
+
+func TestStateOutputChanged(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(path string) error
+	}{
+		{"modified", func(path string) error { return os.WriteFile(path, []byte("tampered"), 0o666) }},
+		{"missing", os.Remove},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupState(t)
+			repacked := completeTestStep(t, "archive")
+			if err := tt.change(repacked); err != nil {
+				t.Fatal(err)
+			}
+
+			s, a := resumeTestArchive(t, "archive")
+			if s.restoreStep(a, "1-Individual") {
+				t.Error("restoreStep = true after the step's output changed, want false")
+			}
+			if a.repackedPath != "" {
+				t.Errorf("repackedPath = %q, want it unset", a.repackedPath)
+			}
+		})
+	}
+}
+
+func TestStateOptionsChanged(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func()
+	}{
+		{"sign-type", func() { *signType = "real" }},
+		{"n", func() { *dryRun = false }},
+		{"package-signature", func() { *packageSignature = "embedded" }},
+		{"policy", func() { policy = &signPolicy{sha256: "other-policy-hash"} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupState(t)
+			completeTestStep(t, "archive")
+			tt.change()
+
+			s, a := resumeTestArchive(t, "archive")
+			if s.restoreStep(a, "1-Individual") {
+				t.Errorf("restoreStep = true after changing %v, want false", tt.name)
+			}
+		})
+	}
+}
+
+func TestStateCompleteStepForgetsLaterSteps(t *testing.T) {
+	setupState(t)
+	s, err := loadState(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := newTestArchive(t, "archive")
+	if err := s.initArchive(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(a.sigPath(), []byte("sig"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range steps {
+		if err := s.completeStep(a, step); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Redoing the first step invalidates the work the later steps did on its output.
+	if err := s.completeStep(a, steps[0]); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(s.Archives[a.name].Steps); got != 1 {
+		t.Errorf("%v steps recorded after redoing the first, want 1", got)
+	}
+}
+
+func TestStateReusesEarlierStepHashes(t *testing.T) {
+	setupState(t)
+	s, err := loadState(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := newTestArchive(t, "archive")
+	if err := s.initArchive(a); err != nil {
+		t.Fatal(err)
+	}
+	a.repackedPath = filepath.Join(a.workDir, a.name+".WithSignedContent")
+	if err := os.WriteFile(a.repackedPath, []byte("repacked"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.completeStep(a, "1-Individual"); err != nil {
+		t.Fatal(err)
+	}
+	// The next step has the same output. Its hash comes from the first step rather than from
+	// reading the file again, so removing the file doesn't make completing the step fail.
+	if err := os.Remove(a.repackedPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.completeStep(a, "2-Notarize"); err != nil {
+		t.Fatal(err)
+	}
+	ss := s.Archives[a.name].Steps
+	if got, want := ss["2-Notarize"].Outputs[a.repackedPath], ss["1-Individual"].Outputs[a.repackedPath]; got != want {
+		t.Errorf("2-Notarize output hash = %q, want the 1-Individual hash %q", got, want)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"github.com/microsoft/go/_util/internal/symstore"
+)
+
+const description = `
+This command lays out PDB files in a symbol store directory, where each PDB is
+at "<name>/<GUID+age>/<name>", and writes an index.json that lists each PDB with
+its key, size, and SHA-256. Pass the PDB files as non-flag arguments.
+
+The GUID and age are read from each PDB. With '-binaries', the PE files (or zip
+archives of PE files) that were built with the PDBs are read too: each PDB must
+be referred to by the debug directory of one of them, and is stored under the
+PDB name that the PE file refers to, which is the name a debugger looks up.
+
+With '-bundle', also creates a tar of the store. The sign command recognizes
+go*.symbols.tar bundles and creates a signature for them.
+
+"eng/run.ps1 build -pdb -packbuild" runs this automatically.
+
+Example:
+
+  eng/run.ps1 symbols -o eng/artifacts/symstore -binaries 'go/bin/*.exe' eng/artifacts/symbols/*.pdb
+`
+
+func main() {
+	help := flag.Bool("h", false, "Print this help message.")
+	outDir := flag.String("o", "eng/artifacts/symstore", "Symbol store directory to create.")
+	bundle := flag.String("bundle", "", "Path of a tar of the symbol store to create, such as go1.23.1-1.windows-amd64.symbols.tar.")
+	var binaries []string
+	flag.Func("binaries", "Glob of PE files or zip archives that refer to the PDBs. May be repeated.", func(s string) error {
+		matches, err := filepath.Glob(s)
+		if err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("no files match %q", s)
+		}
+		binaries = append(binaries, matches...)
+		return nil
+	})
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "%s\n", description)
+	}
+
+	flag.Parse()
+	if *help {
+		flag.Usage()
+		return
+	}
+	if flag.NArg() == 0 {
+		flag.Usage()
+		log.Fatal("No PDB files specified.")
+	}
+
+	idx, err := symstore.Build(*outDir, flag.Args(), binaries)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, e := range idx.Entries {
+		fmt.Printf("%v\n", e.Path)
+	}
+	fmt.Printf("Wrote symbol store %q with %v PDBs\n", *outDir, len(idx.Entries))
+
+	if *bundle != "" {
+		if err := symstore.WriteBundle(*bundle, *outDir); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Wrote symbol bundle %q\n", *bundle)
+	}
+}
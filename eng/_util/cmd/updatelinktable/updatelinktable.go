@@ -58,6 +58,9 @@ type version struct {
 	LatestStable   bool
 	PreviousStable bool
 	Platforms      map[string]struct{}
+	// Installer is true if the branch's releases include a Windows MSI installer. Set it once an
+	// installer has shipped, so the table doesn't link to files that don't exist.
+	Installer bool
 }
 
 var linuxFiles = []goFileType{
@@ -77,6 +80,13 @@ var windowsFiles = []goFileType{
 		Ext:      ".zip",
 		Checksum: true,
 	},
+	{
+		Kind:      supportdata.Installer,
+		Name:      "Installer (msi)",
+		Ext:       ".msi",
+		Checksum:  true,
+		Installer: true,
+	},
 }
 
 var sourceFiles = []goFileType{
@@ -103,6 +113,8 @@ type goFileType struct {
 	Ext       string
 	Checksum  bool
 	Signature bool
+	// Installer is true if the file is only listed for versions with Installer set.
+	Installer bool
 }
 
 func (t *goFileType) ArtifactLink(version, platform, os, arch string) *supportdata.LatestLink {
@@ -236,6 +248,9 @@ func data() (string, []supportdata.Branch) {
 				types = fileTypes("")
 			}
 			for _, f := range types {
+				if f.Installer && !v.Installer {
+					continue
+				}
 				artifact := f.ArtifactLink(v.Number, p, os, arch)
 				writeURL(f.Name, artifact.URL)
 				branch.Files = append(branch.Files, artifact)
@@ -8,20 +8,39 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 
 	"github.com/microsoft/go/_util/internal/checksum"
 )
 
 const description = `
-This command creates a SHA256 checksum file for the given files, in the same
-location and with the same name as each given file but with ".sha256" added to
-the end. Pass files as non-flag arguments.
+This command creates a checksum file for the given files, in the same location
+and with the same name as each given file but with the algorithm's extension
+(".sha256", ".sha384", ".sha512", or ".b2") added to the end. Pass files as
+non-flag arguments.
 
-Generated files are compatible with "sha256sum -c".
+With '-manifest', instead writes one manifest per directory, like SHA256SUMS,
+listing the checksums of all the given files in that directory.
+
+Each file is read once, no matter how many algorithms are given, and up to
+'-parallel' files are hashed at the same time.
+
+Generated files are compatible with "sha256sum -c", "sha512sum -c", etc.
+
+With '-verify', the arguments are checksum files or manifests to check, in any
+of these formats. Each listed file is found relative to the checksum file. The
+command fails if any file doesn't match.
 `
 
 func main() {
 	help := flag.Bool("h", false, "Print this help message.")
+	algorithms := flag.String("algorithms", "sha256", "Comma-separated checksum algorithms to write. Options: sha256, sha384, sha512, blake2b.")
+	manifest := flag.Bool("manifest", false, "Write a combined manifest per directory instead of a checksum file per file.")
+	verify := flag.Bool("verify", false, "Verify the given checksum files instead of writing checksums.")
+	parallel := flag.Int("parallel", runtime.NumCPU(), "Maximum number of files to hash at the same time.")
 
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), "Usage:\n")
@@ -38,9 +57,86 @@ func main() {
 		flag.Usage()
 		log.Fatal("No files specified.")
 	}
-	for _, m := range flag.Args() {
-		if err := checksum.WriteSHA256ChecksumFile(m); err != nil {
+
+	engine := &checksum.Engine{Concurrency: *parallel}
+
+	if *verify {
+		if !runVerify(engine, flag.Args()) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	var algs []checksum.Algorithm
+	for _, s := range strings.Split(*algorithms, ",") {
+		a, err := checksum.ParseAlgorithm(strings.TrimSpace(s))
+		if err != nil {
 			log.Fatal(err)
 		}
+		algs = append(algs, a)
+	}
+
+	if *manifest {
+		// Group the files by directory, keeping the order the directories were first seen.
+		var dirs []string
+		files := make(map[string][]string)
+		for _, f := range flag.Args() {
+			dir := filepath.Dir(f)
+			if _, ok := files[dir]; !ok {
+				dirs = append(dirs, dir)
+			}
+			files[dir] = append(files[dir], f)
+		}
+		for _, dir := range dirs {
+			written, err := engine.WriteManifests(dir, files[dir], algs...)
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, w := range written {
+				log.Printf("Wrote checksum manifest %q with %v files\n", w, len(files[dir]))
+			}
+		}
+		return
+	}
+
+	sums, err := engine.Files(flag.Args(), algs...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for i, m := range flag.Args() {
+		written, err := checksum.WriteChecksumFiles(m, sums[i])
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, w := range written {
+			log.Printf("Wrote checksum file %q\n", w)
+		}
+	}
+}
+
+// runVerify verifies each checksum file and prints a line per listed file in the style of
+// "sha256sum -c". Returns true if all files match.
+func runVerify(engine *checksum.Engine, paths []string) bool {
+	ok := true
+	for _, p := range paths {
+		results, err := engine.Verify(p)
+		if err != nil {
+			log.Printf("error: %v", err)
+			ok = false
+			continue
+		}
+		for _, r := range results {
+			if r.Err == nil {
+				fmt.Printf("%v: OK\n", r.Name)
+				continue
+			}
+			ok = false
+			if r.Err == checksum.ErrMismatch {
+				fmt.Printf("%v: FAILED\n", r.Name)
+			} else {
+				fmt.Printf("%v: FAILED open or read: %v\n", r.Name, r.Err)
+			}
+		}
 	}
+	return ok
 }
@@ -9,6 +9,7 @@ go 1.22.0
 require (
 	github.com/microsoft/go-infra v0.0.6
 	github.com/microsoft/go-infra/goinstallscript v0.0.0-20241113173623-26aea3823c67
+	golang.org/x/crypto v0.31.0
 	golang.org/x/sys v0.28.0
 	gotest.tools/gotestsum v1.12.0
 )
@@ -24,6 +25,6 @@ require (
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/microsoft/azure-devops-go-api/azuredevops v1.0.0-b5 // indirect
 	golang.org/x/sync v0.10.0 // indirect
-	golang.org/x/term v0.20.0 // indirect
+	golang.org/x/term v0.27.0 // indirect
 	golang.org/x/text v0.21.0 // indirect
 )
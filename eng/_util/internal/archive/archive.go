@@ -0,0 +1,400 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package archive reads, extracts, and rewrites the zip and tar.gz archives that make up a Go
+// distribution. Every entry name is checked to be a local path before it is passed to a caller,
+// so extracting an entry under a directory can't write outside that directory.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"cmp"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Format is the container format of an archive.
+type Format int
+
+const (
+	// Zip is a zip archive, used for Windows.
+	Zip Format = iota
+	// TarGz is a gzip-compressed tar archive, used for macOS and Linux.
+	TarGz
+)
+
+func (f Format) String() string {
+	switch f {
+	case Zip:
+		return "zip"
+	case TarGz:
+		return "tar.gz"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// FormatOf returns the format of the archive at path, based on its file extension.
+func FormatOf(path string) (Format, error) {
+	switch {
+	case strings.HasSuffix(path, ".zip"):
+		return Zip, nil
+	case strings.HasSuffix(path, ".tar.gz"), strings.HasSuffix(path, ".tgz"):
+		return TarGz, nil
+	}
+	return 0, fmt.Errorf("unknown archive type: %s", path)
+}
+
+// Entry is the metadata of one file, directory, or link in an archive.
+type Entry struct {
+	// Name is the slash-separated path of the entry. It is always a local path.
+	Name string
+	// Mode is the entry's file mode, including type bits.
+	Mode fs.FileMode
+	// Size is the uncompressed size of the entry's content.
+	Size    int64
+	ModTime time.Time
+	// Linkname is the target of a tar symlink or hard link.
+	Linkname string
+
+	zipFile   *zip.File
+	tarHeader *tar.Header
+}
+
+// IsRegular returns whether the entry is a regular file with content.
+func (e *Entry) IsRegular() bool {
+	if e.tarHeader != nil {
+		return e.tarHeader.Typeflag == tar.TypeReg
+	}
+	return e.Mode.IsRegular()
+}
+
+// IsDir returns whether the entry is a directory.
+func (e *Entry) IsDir() bool {
+	return e.Mode.IsDir()
+}
+
+// ZipHeader returns the zip header of the entry, or nil if the entry isn't from a zip archive.
+func (e *Entry) ZipHeader() *zip.FileHeader {
+	if e.zipFile == nil {
+		return nil
+	}
+	return &e.zipFile.FileHeader
+}
+
+// TarHeader returns the tar header of the entry, or nil if the entry isn't from a tar archive.
+func (e *Entry) TarHeader() *tar.Header {
+	return e.tarHeader
+}
+
+func newZipEntry(f *zip.File) *Entry {
+	return &Entry{
+		Name:    f.Name,
+		Mode:    f.Mode(),
+		Size:    int64(f.UncompressedSize64),
+		ModTime: f.Modified,
+		zipFile: f,
+	}
+}
+
+func newTarEntry(h *tar.Header) *Entry {
+	return &Entry{
+		Name:      h.Name,
+		Mode:      h.FileInfo().Mode(),
+		Size:      h.Size,
+		ModTime:   h.ModTime,
+		Linkname:  h.Linkname,
+		tarHeader: h,
+	}
+}
+
+// Archive is a zip or tar.gz archive file on disk.
+type Archive struct {
+	Path   string
+	Format Format
+
+	// Concurrency is the number of goroutines used to compress a tar.gz archive written by
+	// Replace. Zero means runtime.NumCPU().
+	Concurrency int
+}
+
+// New returns an Archive for the file at path, determining its format from the file extension.
+// The file isn't opened until a method is called.
+func New(path string) (*Archive, error) {
+	format, err := FormatOf(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Archive{Path: path, Format: format}, nil
+}
+
+// WalkFunc is called by Walk for each entry. For regular files, r reads the entry's content. For
+// other entries, r is nil. r is only valid until WalkFunc returns.
+type WalkFunc func(e *Entry, r io.Reader) error
+
+// Walk calls f for each entry in the archive, in archive order. If f returns an error, Walk stops
+// and returns it.
+func (a *Archive) Walk(f WalkFunc) error {
+	switch a.Format {
+	case Zip:
+		return WithZipOpen(a.Path, func(zr *zip.ReadCloser) error {
+			return walkZip(&zr.Reader, f)
+		})
+	case TarGz:
+		return WithFileOpen(a.Path, func(file *os.File) error {
+			return walkTarGz(file, f)
+		})
+	}
+	return fmt.Errorf("unsupported archive format: %v", a.Format)
+}
+
+func walkZip(zr *zip.Reader, f WalkFunc) error {
+	return EachZipEntry(zr, func(zf *zip.File) error {
+		e := newZipEntry(zf)
+		if !e.IsRegular() {
+			return f(e, nil)
+		}
+		// Only decompress the content if f reads it.
+		r := &lazyZipReader{f: zf}
+		return cmp.Or(f(e, r), r.close())
+	})
+}
+
+func walkTarGz(r io.Reader, f WalkFunc) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	return EachTarEntry(tar.NewReader(gz), func(h *tar.Header, r io.Reader) error {
+		e := newTarEntry(h)
+		if !e.IsRegular() {
+			r = nil
+		}
+		return f(e, r)
+	})
+}
+
+type lazyZipReader struct {
+	f  *zip.File
+	rc io.ReadCloser
+}
+
+func (r *lazyZipReader) Read(p []byte) (int, error) {
+	if r.rc == nil {
+		rc, err := r.f.Open()
+		if err != nil {
+			return 0, err
+		}
+		r.rc = rc
+	}
+	return r.rc.Read(p)
+}
+
+func (r *lazyZipReader) close() error {
+	if r.rc == nil {
+		return nil
+	}
+	return r.rc.Close()
+}
+
+// List returns the metadata of every entry in the archive, in archive order.
+func (a *Archive) List() ([]*Entry, error) {
+	var entries []*Entry
+	if err := a.Walk(func(e *Entry, _ io.Reader) error {
+		entries = append(entries, e)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Extract writes each regular file entry selected by match to the same relative path under dir.
+// If match is nil, every regular file is extracted. Returns the extracted entries.
+func (a *Archive) Extract(dir string, match func(*Entry) bool) ([]*Entry, error) {
+	var extracted []*Entry
+	if err := a.Walk(func(e *Entry, r io.Reader) error {
+		if r == nil || (match != nil && !match(e)) {
+			return nil
+		}
+		if err := CopyToFile(filepath.Join(dir, filepath.FromSlash(e.Name)), r); err != nil {
+			return err
+		}
+		extracted = append(extracted, e)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to extract from %q: %v", a.Path, err)
+	}
+	return extracted, nil
+}
+
+// ReplaceFunc returns the new content for a regular file entry, or nil to keep the original
+// content. Replace closes the returned file. The file's size according to Stat is used as the new
+// entry size.
+type ReplaceFunc func(e *Entry) (fs.File, error)
+
+// Replace writes a copy of the archive to dst, in the same format, with the content of entries
+// replaced according to replace. Entry order and metadata are preserved.
+//
+// For zip archives, the rewrite is lossless: every zip.FileHeader field of every entry is
+// preserved (including external attributes, creator version, flags, and extra fields) except the
+// CRC and sizes of replaced entries. Unchanged entries are copied without recompression. Fields
+// that archive/zip doesn't expose, like internal attributes, are not preserved: Go's distpack
+// doesn't set them.
+//
+// For tar.gz archives, the tar header fields that matter for a Go distribution are copied and
+// the archive is recompressed.
+func (a *Archive) Replace(dst string, replace ReplaceFunc) error {
+	switch a.Format {
+	case Zip:
+		return WithZipOpen(a.Path, func(zr *zip.ReadCloser) error {
+			return WithZipCreate(dst, func(zw *zip.Writer) error {
+				return replaceZip(&zr.Reader, zw, replace)
+			})
+		})
+	case TarGz:
+		concurrency := a.Concurrency
+		if concurrency == 0 {
+			concurrency = runtime.NumCPU()
+		}
+		return WithTarGzOpen(a.Path, func(tr *tar.Reader) error {
+			return WithTarGzCreate(dst, concurrency, func(tw *tar.Writer) error {
+				return EachTarEntry(tr, func(h *tar.Header, r io.Reader) error {
+					return replaceTarEntry(h, r, tw, replace)
+				})
+			})
+		})
+	}
+	return fmt.Errorf("unsupported archive format: %v", a.Format)
+}
+
+func replaceZip(zr *zip.Reader, zw *zip.Writer, replace ReplaceFunc) error {
+	if err := zw.SetComment(zr.Comment); err != nil {
+		return err
+	}
+	return EachZipEntry(zr, func(original *zip.File) error {
+		e := newZipEntry(original)
+		if !e.IsRegular() {
+			return zw.Copy(original)
+		}
+		replacement, err := replace(e)
+		if err != nil {
+			return err
+		}
+		if replacement == nil {
+			return zw.Copy(original)
+		}
+		defer replacement.Close()
+		return writeZipReplacement(zw, &original.FileHeader, replacement)
+	})
+}
+
+func writeZipReplacement(zw *zip.Writer, original *zip.FileHeader, r io.Reader) error {
+	// Compress the content ourselves so we can use CreateRaw. CreateHeader would overwrite the
+	// creator and reader versions and, if Modified is set, append a duplicate timestamp to the
+	// extra field.
+	var compressed bytes.Buffer
+	crc := crc32.NewIEEE()
+	var cw io.WriteCloser
+	switch original.Method {
+	case zip.Store:
+		cw = nopWriteCloser{&compressed}
+	case zip.Deflate:
+		var err error
+		if cw, err = flate.NewWriter(&compressed, flate.DefaultCompression); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported zip compression method %v for %q", original.Method, original.Name)
+	}
+	n, err := io.Copy(io.MultiWriter(cw, crc), r)
+	if err := cmp.Or(err, cw.Close()); err != nil {
+		return err
+	}
+
+	fh := *original
+	fh.CRC32 = crc.Sum32()
+	fh.UncompressedSize64 = uint64(n)
+	fh.CompressedSize64 = uint64(compressed.Len())
+	w, err := zw.CreateRaw(&fh)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, &compressed)
+	return err
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func replaceTarEntry(hdr *tar.Header, original io.Reader, out *tar.Writer, replace ReplaceFunc) error {
+	// Always start with header info from the original tar.gz even if we're going to replace the
+	// file content. This means the replacement doesn't need to carry any metadata.
+	newHeader := &tar.Header{
+		// Follow tar.Header documented compat guidance by copying over our selection of fields.
+		Typeflag: hdr.Typeflag,
+		Name:     hdr.Name,
+		Linkname: hdr.Linkname,
+
+		Size:  hdr.Size,
+		Mode:  hdr.Mode,
+		Uid:   hdr.Uid,
+		Gid:   hdr.Gid,
+		Uname: hdr.Uname,
+		Gname: hdr.Gname,
+
+		ModTime:    hdr.ModTime,
+		AccessTime: hdr.AccessTime,
+		ChangeTime: hdr.ChangeTime,
+
+		Devmajor: hdr.Devmajor,
+		Devminor: hdr.Devminor,
+	}
+	isFile := hdr.Typeflag == tar.TypeReg
+	if isFile {
+		replacement, err := replace(newTarEntry(hdr))
+		if err != nil {
+			return err
+		}
+		if replacement != nil {
+			defer replacement.Close()
+			// Get the file size to prepare to copy.
+			stat, err := replacement.Stat()
+			if err != nil {
+				return err
+			}
+			newHeader.Size = stat.Size()
+			original = replacement
+		}
+	}
+	if err := out.WriteHeader(newHeader); err != nil {
+		return fmt.Errorf("failed to write header for %q: %v", newHeader.Name, err)
+	}
+	if isFile {
+		if _, err := io.Copy(out, original); err != nil {
+			return fmt.Errorf("failed to write %q: %v", newHeader.Name, err)
+		}
+	}
+	// Call Flush to make sure our write was correct. We don't technically need to call Flush here
+	// because the next WriteHeader will confirm that we e.g. wrote the correct number of bytes.
+	// However, calling Flush ourselves lets us emit an error that mentions the bad filename
+	// (rather than the next, unrelated filename).
+	if err := out.Flush(); err != nil {
+		return fmt.Errorf("failed to flush %q: %v", newHeader.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testModTime = time.Date(2024, 1, 2, 3, 4, 6, 0, time.UTC)
+
+func TestReplaceZipPreservesCentralDirectory(t *testing.T) {
+	dir := t.TempDir()
+	originalPath := filepath.Join(dir, "go1.0.windows-amd64.zip")
+
+	entries := []struct {
+		fh      zip.FileHeader
+		mode    os.FileMode
+		content string
+	}{
+		{fh: zip.FileHeader{Name: "go/"}, mode: os.ModeDir | 0o755},
+		{fh: zip.FileHeader{Name: "go/bin/"}, mode: os.ModeDir | 0o755},
+		{fh: zip.FileHeader{Name: "go/bin/go.exe", Method: zip.Deflate, Comment: "tool"}, mode: 0o755, content: "unsigned go"},
+		{fh: zip.FileHeader{Name: "go/VERSION", Method: zip.Store}, mode: 0o644, content: "go1.0"},
+		{fh: zip.FileHeader{Name: "go/bin/gofmt.exe", Method: zip.Store}, mode: 0o755, content: "unsigned gofmt"},
+		{fh: zip.FileHeader{Name: "go/src/", Extra: []byte{0xfe, 0xca, 0, 0}}, mode: os.ModeDir | 0o755},
+	}
+	if err := WithZipCreate(originalPath, func(zw *zip.Writer) error {
+		if err := zw.SetComment("archive comment"); err != nil {
+			return err
+		}
+		for _, e := range entries {
+			fh := e.fh
+			fh.Modified = testModTime
+			fh.SetMode(e.mode)
+			w, err := zw.CreateHeader(&fh)
+			if err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, e.content); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	signedContent := map[string]string{
+		"go/bin/go.exe":    "signed go, which is longer than the original",
+		"go/bin/gofmt.exe": "signed gofmt",
+	}
+	a, err := New(originalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repackedPath := filepath.Join(dir, "repacked.zip")
+	if err := a.Replace(repackedPath, replaceFromMap(t, signedContent)); err != nil {
+		t.Fatal(err)
+	}
+
+	original, err := zip.OpenReader(originalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer original.Close()
+	repacked, err := zip.OpenReader(repackedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repacked.Close()
+
+	if original.Comment != repacked.Comment {
+		t.Errorf("archive comment: got %q, want %q", repacked.Comment, original.Comment)
+	}
+	if len(original.File) != len(repacked.File) {
+		t.Fatalf("got %v entries, want %v", len(repacked.File), len(original.File))
+	}
+	for i, of := range original.File {
+		rf := repacked.File[i]
+		want, got := of.FileHeader, rf.FileHeader
+
+		if content, ok := signedContent[of.Name]; ok {
+			if gotContent := readZipFile(t, rf); gotContent != content {
+				t.Errorf("%q: got content %q, want %q", of.Name, gotContent, content)
+			}
+			if got.CRC32 != crc32.ChecksumIEEE([]byte(content)) {
+				t.Errorf("%q: CRC32 doesn't match replaced content", of.Name)
+			}
+			// These fields are expected to change along with the content.
+			want.CRC32, got.CRC32 = 0, 0
+			want.CompressedSize, got.CompressedSize = 0, 0
+			want.CompressedSize64, got.CompressedSize64 = 0, 0
+			want.UncompressedSize, got.UncompressedSize = 0, 0
+			want.UncompressedSize64, got.UncompressedSize64 = 0, 0
+		} else if readZipFile(t, rf) != readZipFile(t, of) {
+			t.Errorf("%q: content changed", of.Name)
+		}
+
+		// Compare field by field for a more helpful failure message than DeepEqual alone.
+		wv, gv := reflect.ValueOf(want), reflect.ValueOf(got)
+		for j := 0; j < wv.NumField(); j++ {
+			if !reflect.DeepEqual(wv.Field(j).Interface(), gv.Field(j).Interface()) {
+				t.Errorf("%q: field %v: got %#v, want %#v",
+					of.Name, wv.Type().Field(j).Name, gv.Field(j).Interface(), wv.Field(j).Interface())
+			}
+		}
+	}
+}
+
+func TestReplaceTarGzPreservesHeaders(t *testing.T) {
+	dir := t.TempDir()
+	originalPath := filepath.Join(dir, "go1.0.darwin-arm64.tar.gz")
+
+	headers := []*tar.Header{
+		{Typeflag: tar.TypeDir, Name: "go/", Mode: 0o755},
+		{Typeflag: tar.TypeReg, Name: "go/bin/go", Mode: 0o755, Size: int64(len("unsigned go"))},
+		{Typeflag: tar.TypeSymlink, Name: "go/bin/link", Linkname: "go", Mode: 0o777},
+		{Typeflag: tar.TypeReg, Name: "go/VERSION", Mode: 0o644, Size: int64(len("go1.0")), Uname: "gopher"},
+	}
+	content := map[string]string{
+		"go/bin/go":  "unsigned go",
+		"go/VERSION": "go1.0",
+	}
+	if err := WithTarGzCreate(originalPath, 2, func(tw *tar.Writer) error {
+		for _, h := range headers {
+			h.ModTime = testModTime
+			if err := tw.WriteHeader(h); err != nil {
+				return err
+			}
+			if _, err := io.WriteString(tw, content[h.Name]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := New(originalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repackedPath := filepath.Join(dir, "repacked.tar.gz")
+	if err := a.Replace(repackedPath, replaceFromMap(t, map[string]string{
+		"go/bin/go": "signed go",
+	})); err != nil {
+		t.Fatal(err)
+	}
+	content["go/bin/go"] = "signed go"
+
+	repacked, err := New(repackedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var i int
+	if err := repacked.Walk(func(e *Entry, r io.Reader) error {
+		if i >= len(headers) {
+			t.Fatalf("unexpected extra entry %q", e.Name)
+		}
+		want, got := headers[i], e.TarHeader()
+		i++
+		if got.Name != want.Name || got.Typeflag != want.Typeflag || got.Linkname != want.Linkname ||
+			got.Mode != want.Mode || got.Uname != want.Uname || !got.ModTime.Equal(want.ModTime) {
+
+			t.Errorf("header mismatch:\ngot  %+v\nwant %+v", got, want)
+		}
+		if r != nil {
+			b, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			if string(b) != content[e.Name] {
+				t.Errorf("%q: got content %q, want %q", e.Name, b, content[e.Name])
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if i != len(headers) {
+		t.Errorf("got %v entries, want %v", i, len(headers))
+	}
+}
+
+func TestExtract(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "go.zip")
+	if err := WithZipCreate(archivePath, func(zw *zip.Writer) error {
+		for _, name := range []string{"go/", "go/bin/go.exe", "go/bin/gofmt.exe", "go/VERSION"} {
+			w, err := zw.Create(name)
+			if err != nil {
+				return err
+			}
+			if !strings.HasSuffix(name, "/") {
+				if _, err := io.WriteString(w, name); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := New(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extractDir := filepath.Join(dir, "extract")
+	extracted, err := a.Extract(extractDir, func(e *Entry) bool {
+		return strings.HasSuffix(e.Name, ".exe")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(extracted) != 2 {
+		t.Fatalf("got %v extracted entries, want 2", len(extracted))
+	}
+	for _, e := range extracted {
+		b, err := os.ReadFile(filepath.Join(extractDir, filepath.FromSlash(e.Name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != e.Name {
+			t.Errorf("%q: got content %q", e.Name, b)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(extractDir, "go", "VERSION")); !os.IsNotExist(err) {
+		t.Errorf("expected unselected entry not to be extracted, got %v", err)
+	}
+}
+
+// replaceFromMap returns a ReplaceFunc that replaces the entries named in m with the associated
+// content, written to temporary files.
+func replaceFromMap(t *testing.T, m map[string]string) ReplaceFunc {
+	dir := t.TempDir()
+	return func(e *Entry) (fs.File, error) {
+		content, ok := m[e.Name]
+		if !ok {
+			return nil, nil
+		}
+		p := filepath.Join(dir, filepath.FromSlash(e.Name))
+		if err := CopyToFile(p, strings.NewReader(content)); err != nil {
+			return nil, err
+		}
+		return os.Open(p)
+	}
+}
+
+func readZipFile(t *testing.T, f *zip.File) string {
+	t.Helper()
+	r, err := f.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
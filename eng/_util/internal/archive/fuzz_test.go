@@ -0,0 +1,107 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var fuzzSeedNames = []string{
+	"go/bin/go",
+	"go/",
+	"../evil",
+	"go/../../evil",
+	"/abs/evil",
+	`go\..\..\evil`,
+	`C:\evil`,
+	"",
+	".",
+}
+
+func FuzzWalkZip(f *testing.F) {
+	for _, name := range fuzzSeedNames {
+		var b bytes.Buffer
+		zw := zip.NewWriter(&b)
+		w, err := zw.Create(name)
+		if err != nil {
+			f.Fatal(err)
+		}
+		io.WriteString(w, "content")
+		if err := zw.Close(); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b.Bytes())
+		// Truncated archives exercise malformed central directory handling.
+		f.Add(b.Bytes()[:b.Len()/2])
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return
+		}
+		walkChecked(t, func(wf WalkFunc) error { return walkZip(zr, wf) })
+	})
+}
+
+func FuzzWalkTarGz(f *testing.F) {
+	for _, name := range fuzzSeedNames {
+		for _, typeflag := range []byte{tar.TypeReg, tar.TypeDir, tar.TypeSymlink} {
+			var b bytes.Buffer
+			gz := gzip.NewWriter(&b)
+			tw := tar.NewWriter(gz)
+			h := &tar.Header{Typeflag: typeflag, Name: name, Mode: 0o644}
+			if typeflag == tar.TypeReg {
+				h.Size = int64(len("content"))
+			} else if typeflag == tar.TypeSymlink {
+				h.Linkname = "../../evil"
+			}
+			// Some names aren't representable. Skip them rather than failing the seed.
+			if err := tw.WriteHeader(h); err != nil {
+				continue
+			}
+			if typeflag == tar.TypeReg {
+				io.WriteString(tw, "content")
+			}
+			if err := tw.Close(); err != nil {
+				f.Fatal(err)
+			}
+			if err := gz.Close(); err != nil {
+				f.Fatal(err)
+			}
+			f.Add(b.Bytes())
+			f.Add(b.Bytes()[:b.Len()/2])
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		walkChecked(t, func(wf WalkFunc) error { return walkTarGz(bytes.NewReader(data), wf) })
+	})
+}
+
+// walkChecked runs walk and checks that every entry it reports is safe to extract.
+func walkChecked(t *testing.T, walk func(WalkFunc) error) {
+	const dir = "/extract"
+	_ = walk(func(e *Entry, r io.Reader) error {
+		if !isLocalName(e.Name) {
+			t.Fatalf("walk reported non-local name %q", e.Name)
+		}
+		dst := filepath.Join(dir, filepath.FromSlash(e.Name))
+		if dst != dir && !strings.HasPrefix(dst, dir+string(filepath.Separator)) {
+			t.Fatalf("entry %q would be extracted to %q, outside %q", e.Name, dst, dir)
+		}
+		if r != nil {
+			// Read the content to exercise malformed sizes and compressed data.
+			_, err := io.Copy(io.Discard, r)
+			return err
+		}
+		return nil
+	})
+}
@@ -0,0 +1,66 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package archive
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Name is the parsed filename of a Microsoft Go distribution archive, such as
+// "go1.23.1-1.linux-amd64.tar.gz".
+type Name struct {
+	// Version is the upstream Go version without the "go" prefix, e.g. "1.23.1".
+	Version string
+	// Release is the Microsoft revision, e.g. "1". It's "1" if the filename doesn't have one.
+	Release string
+	GOOS    string
+	GOARCH  string
+	Format  Format
+}
+
+// ParseName parses the filename of the archive at p. It returns an error for a source archive,
+// which has no platform.
+func ParseName(p string) (*Name, error) {
+	base := filepath.Base(p)
+	format, err := FormatOf(base)
+	if err != nil {
+		return nil, err
+	}
+	rest, ok := strings.CutPrefix(base, "go")
+	if !ok {
+		return nil, fmt.Errorf("archive filename doesn't start with 'go': %q", base)
+	}
+	for _, ext := range []string{".zip", ".tar.gz", ".tgz"} {
+		if r, ok := strings.CutSuffix(rest, ext); ok {
+			rest = r
+			break
+		}
+	}
+	// The platform is the last dot-separated part. The version and the release may contain dots.
+	i := strings.LastIndex(rest, ".")
+	if i < 0 {
+		return nil, fmt.Errorf("archive filename doesn't contain a platform: %q", base)
+	}
+	goos, goarch, ok := strings.Cut(rest[i+1:], "-")
+	if !ok || goos == "" || goarch == "" {
+		return nil, fmt.Errorf("archive filename doesn't contain a platform: %q", base)
+	}
+	version, release, ok := strings.Cut(rest[:i], "-")
+	if !ok {
+		release = "1"
+	}
+	if version == "" || release == "" {
+		return nil, fmt.Errorf("archive filename doesn't contain a version: %q", base)
+	}
+	return &Name{
+		Version: version,
+		Release: release,
+		GOOS:    goos,
+		GOARCH:  goarch,
+		Format:  format,
+	}, nil
+}
@@ -0,0 +1,47 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package archive
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseName(t *testing.T) {
+	tests := []struct {
+		path string
+		want *Name
+	}{
+		{"go1.23.1-1.linux-amd64.tar.gz", &Name{"1.23.1", "1", "linux", "amd64", TarGz}},
+		{"dir/go1.23.1.windows-386.zip", &Name{"1.23.1", "1", "windows", "386", Zip}},
+		{"go1.24rc1-20241018.1.darwin-arm64.tar.gz", &Name{"1.24rc1", "20241018.1", "darwin", "arm64", TarGz}},
+		{"go1.23.1-2024-1.linux-armv6l.tgz", &Name{"1.23.1", "2024-1", "linux", "armv6l", TarGz}},
+	}
+	for _, tt := range tests {
+		got, err := ParseName(tt.path)
+		if err != nil {
+			t.Errorf("ParseName(%q) error: %v", tt.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseName(%q) = %+v, want %+v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestParseNameError(t *testing.T) {
+	for _, p := range []string{
+		"go1.23.1-1.src.tar.gz",
+		"microsoft-go1.23.1.linux-amd64.tar.gz",
+		"go1.23.1-1.linux-amd64.rpm",
+		"go.linux-amd64.tar.gz",
+		"go1.23.1-.linux-amd64.tar.gz",
+		"go1.23.1-1.linux-.tar.gz",
+	} {
+		if got, err := ParseName(p); err == nil {
+			t.Errorf("ParseName(%q) = %+v, want error", p, got)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package archive
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+const (
+	// parallelGzipBlockSize is the amount of uncompressed data compressed by each goroutine.
+	parallelGzipBlockSize = 1 << 20
+	// flateWindowSize is the size of the DEFLATE back-reference window. The tail of each block is
+	// used as the preset dictionary of the next block so compression ratio doesn't suffer much
+	// from splitting the input.
+	flateWindowSize = 32 << 10
+)
+
+// parallelGzipWriter is an io.WriteCloser that compresses blocks of its input concurrently and
+// writes a standard single-member gzip stream. This is the same technique used by
+// github.com/klauspost/pgzip: each block is compressed independently (using the previous block's
+// tail as a dictionary) and ends with a sync flush, so the compressed blocks can be concatenated
+// into one valid DEFLATE stream.
+//
+// The output isn't byte-for-byte identical to compress/gzip output, but any gzip reader can read
+// it.
+type parallelGzipWriter struct {
+	w     io.Writer
+	level int
+
+	buf  []byte
+	dict []byte
+	crc  uint32
+	size uint32
+
+	// blocks is the ordered queue of blocks being compressed. Its capacity limits the number of
+	// blocks that are held in memory and compressed at the same time.
+	blocks chan *gzipBlock
+	// written is closed when the goroutine writing compressed blocks to w has finished.
+	written chan struct{}
+
+	errMu sync.Mutex
+	err   error
+
+	closed bool
+}
+
+type gzipBlock struct {
+	out  []byte
+	err  error
+	done chan struct{}
+}
+
+func newParallelGzipWriter(w io.Writer, level, concurrency int) (*parallelGzipWriter, error) {
+	if _, err := flate.NewWriter(io.Discard, level); err != nil {
+		return nil, err
+	}
+	z := &parallelGzipWriter{
+		w:       w,
+		level:   level,
+		buf:     make([]byte, 0, parallelGzipBlockSize),
+		blocks:  make(chan *gzipBlock, max(concurrency, 1)),
+		written: make(chan struct{}),
+	}
+	if _, err := w.Write(gzipHeader(level)); err != nil {
+		return nil, err
+	}
+	go z.writeBlocks()
+	return z, nil
+}
+
+// gzipHeader returns a gzip member header with no optional fields, matching the header that
+// compress/gzip writes when no Header fields are set.
+func gzipHeader(level int) []byte {
+	h := []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 255}
+	switch level {
+	case flate.BestCompression:
+		h[8] = 2
+	case flate.BestSpeed:
+		h[8] = 4
+	}
+	return h
+}
+
+func (z *parallelGzipWriter) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, errors.New("write to closed parallel gzip writer")
+	}
+	if err := z.getErr(); err != nil {
+		return 0, err
+	}
+	z.crc = crc32.Update(z.crc, crc32.IEEETable, p)
+	z.size += uint32(len(p))
+	n := 0
+	for len(p) > 0 {
+		c := copy(z.buf[len(z.buf):cap(z.buf)], p)
+		z.buf = z.buf[:len(z.buf)+c]
+		p = p[c:]
+		n += c
+		if len(z.buf) == cap(z.buf) {
+			z.startBlock(false)
+		}
+	}
+	return n, nil
+}
+
+// Close compresses any remaining data, waits for all blocks to be written, and writes the gzip
+// trailer. It doesn't close the underlying writer.
+func (z *parallelGzipWriter) Close() error {
+	if z.closed {
+		return nil
+	}
+	z.closed = true
+	z.startBlock(true)
+	close(z.blocks)
+	<-z.written
+	if err := z.getErr(); err != nil {
+		return err
+	}
+	var trailer [8]byte
+	binary.LittleEndian.PutUint32(trailer[:4], z.crc)
+	binary.LittleEndian.PutUint32(trailer[4:], z.size)
+	_, err := z.w.Write(trailer[:])
+	return err
+}
+
+// startBlock queues the buffered data for compression in a new goroutine. If last is true, the
+// block is terminated with a final DEFLATE block rather than a sync flush.
+func (z *parallelGzipWriter) startBlock(last bool) {
+	data, dict := z.buf, z.dict
+	b := &gzipBlock{done: make(chan struct{})}
+	// Blocks when enough blocks are already in flight.
+	z.blocks <- b
+	go func() {
+		defer close(b.done)
+		b.out, b.err = compressBlock(z.level, dict, data, last)
+	}()
+
+	z.dict = data[max(len(data)-flateWindowSize, 0):]
+	z.buf = make([]byte, 0, parallelGzipBlockSize)
+}
+
+func compressBlock(level int, dict, data []byte, last bool) ([]byte, error) {
+	var b bytes.Buffer
+	fw, err := flate.NewWriterDict(&b, level, dict)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return nil, err
+	}
+	if last {
+		err = fw.Close()
+	} else {
+		err = fw.Flush()
+	}
+	return b.Bytes(), err
+}
+
+// writeBlocks writes compressed blocks to the underlying writer in order as they complete.
+func (z *parallelGzipWriter) writeBlocks() {
+	defer close(z.written)
+	for b := range z.blocks {
+		<-b.done
+		if z.getErr() != nil {
+			// Keep draining so the producer doesn't block forever.
+			continue
+		}
+		if b.err != nil {
+			z.setErr(b.err)
+			continue
+		}
+		if _, err := z.w.Write(b.out); err != nil {
+			z.setErr(err)
+		}
+	}
+}
+
+func (z *parallelGzipWriter) getErr() error {
+	z.errMu.Lock()
+	defer z.errMu.Unlock()
+	return z.err
+}
+
+func (z *parallelGzipWriter) setErr(err error) {
+	z.errMu.Lock()
+	defer z.errMu.Unlock()
+	if z.err == nil {
+		z.err = err
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestParallelGzipWriterRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	// Mix random and repetitive data so back-references cross block boundaries.
+	random := make([]byte, 3*parallelGzipBlockSize/2)
+	for i := range random {
+		random[i] = byte(r.IntN(16))
+	}
+	repeated := bytes.Repeat([]byte("go/pkg/tool/linux_amd64/compile\n"), parallelGzipBlockSize/8)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"small", []byte("hello, world\n")},
+		{"exact-block", repeated[:parallelGzipBlockSize]},
+		{"multi-block", append(append([]byte{}, random...), repeated...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			z, err := newParallelGzipWriter(&b, gzip.BestCompression, 4)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Write in uneven chunks to exercise block splitting.
+			for data := tt.data; len(data) > 0; {
+				n := min(len(data), 12345)
+				if _, err := z.Write(data[:n]); err != nil {
+					t.Fatal(err)
+				}
+				data = data[n:]
+			}
+			if err := z.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			zr, err := gzip.NewReader(&b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Ensure the output is a single gzip member, like compress/gzip produces.
+			zr.Multistream(false)
+			got, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.data) {
+				t.Errorf("round trip mismatch: got %v bytes, want %v bytes", len(got), len(tt.data))
+			}
+			if rest, _ := io.ReadAll(&b); len(rest) != 0 {
+				t.Errorf("unexpected %v bytes after gzip member", len(rest))
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"cmp"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EachZipEntry calls f for each entry in r, in order. Returns an error without calling f if an
+// entry has a name that isn't a local path.
+func EachZipEntry(r *zip.Reader, f func(*zip.File) error) error {
+	for _, file := range r.File {
+		if !isLocalName(file.Name) {
+			return fmt.Errorf("zip contains non-local path: %s", file.Name)
+		}
+		if err := f(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EachTarEntry calls f for each entry in r, in order. The io.Reader passed to f reads the content
+// of the entry. Returns an error without calling f if an entry has a name that isn't a local path.
+func EachTarEntry(r *tar.Reader, f func(*tar.Header, io.Reader) error) error {
+	for {
+		header, err := r.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if !isLocalName(header.Name) {
+			return fmt.Errorf("tar contains non-local path: %s", header.Name)
+		}
+		if err := f(header, r); err != nil {
+			return err
+		}
+	}
+}
+
+// isLocalName returns whether the archive entry name is safe to join to an extraction directory.
+// Disallows absolute paths, "..", etc. Backslashes are also disallowed: they are not separators
+// in archive entry names, but they are on Windows, where we sign.
+func isLocalName(name string) bool {
+	return filepath.IsLocal(name) && !strings.Contains(name, `\`)
+}
+
+// WithFileOpen opens path, calls f, and closes the file.
+func WithFileOpen(path string, f func(*os.File) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return cmp.Or(f(file), file.Close())
+}
+
+// WithZipOpen opens the zip file at path, calls f, and closes the zip file.
+func WithZipOpen(path string, f func(*zip.ReadCloser) error) error {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	return cmp.Or(f(r), r.Close())
+}
+
+// WithTarGzOpen opens the tar.gz file at path, calls f, and closes the file.
+func WithTarGzOpen(path string, f func(*tar.Reader) error) error {
+	return WithFileOpen(path, func(file *os.File) error {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		r := tar.NewReader(gz)
+		return f(r)
+	})
+}
+
+// WithFileCreate creates the file at path (and its parent directories), calls f, and closes the
+// file.
+func WithFileCreate(path string, f func(*os.File) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	return cmp.Or(f(file), file.Close())
+}
+
+// WithZipCreate creates a zip file at path, calls f, and finishes writing the zip file.
+func WithZipCreate(path string, f func(*zip.Writer) error) error {
+	return WithFileCreate(path, func(file *os.File) error {
+		w := zip.NewWriter(file)
+		return cmp.Or(f(w), w.Close())
+	})
+}
+
+// WithTarGzCreate creates a tar.gz file at path, calls f, and finishes writing the tar.gz file.
+// Compression uses concurrency goroutines.
+func WithTarGzCreate(path string, concurrency int, f func(*tar.Writer) error) error {
+	return WithFileCreate(path, func(file *os.File) error {
+		// Use parallel compression: the Go archives are large, and best compression on a single
+		// core makes repacking take much longer than extracting.
+		gzw, err := newParallelGzipWriter(file, gzip.BestCompression, concurrency)
+		if err != nil {
+			return err
+		}
+		tw := tar.NewWriter(gzw)
+		return cmp.Or(f(tw), tw.Close(), gzw.Close())
+	})
+}
+
+// CopyFile copies src to dst, creating dst's directory if necessary. Doesn't copy file
+// permissions.
+func CopyFile(dst, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	return cmp.Or(CopyToFile(dst, f), f.Close())
+}
+
+// CopyToFile copies the content of r to a new file at path, creating path's directory if
+// necessary.
+func CopyToFile(path string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	return cmp.Or(err, f.Close())
+}
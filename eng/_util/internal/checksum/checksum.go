@@ -1,32 +1,307 @@
 package checksum
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"io"
+	"hash"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
 )
 
+// Algorithm is a checksum algorithm.
+type Algorithm string
+
+const (
+	SHA256 Algorithm = "sha256"
+	SHA384 Algorithm = "sha384"
+	SHA512 Algorithm = "sha512"
+	// BLAKE2b is BLAKE2b-512, the default of "b2sum".
+	BLAKE2b Algorithm = "blake2b"
+)
+
+// Algorithms lists the supported algorithms.
+var Algorithms = []Algorithm{SHA256, SHA384, SHA512, BLAKE2b}
+
+// ParseAlgorithm returns the algorithm named s, ignoring case.
+func ParseAlgorithm(s string) (Algorithm, error) {
+	a := Algorithm(strings.ToLower(s))
+	if !slices.Contains(Algorithms, a) {
+		return "", fmt.Errorf("unknown checksum algorithm %q, supported: %v", s, Algorithms)
+	}
+	return a, nil
+}
+
+// New returns a new hash for the algorithm.
+func (a Algorithm) New() hash.Hash {
+	switch a {
+	case SHA256:
+		return sha256.New()
+	case SHA384:
+		return sha512.New384()
+	case SHA512:
+		return sha512.New()
+	case BLAKE2b:
+		h, err := blake2b.New512(nil)
+		if err != nil {
+			panic(err)
+		}
+		return h
+	}
+	panic("unknown checksum algorithm: " + string(a))
+}
+
+// Ext is the extension added to a file's name to get the name of its checksum file.
+func (a Algorithm) Ext() string {
+	if a == BLAKE2b {
+		return ".b2"
+	}
+	return "." + string(a)
+}
+
+// ManifestName is the conventional name of a manifest with the checksums of the files in a
+// directory, such as "SHA256SUMS".
+func (a Algorithm) ManifestName() string {
+	if a == BLAKE2b {
+		return "B2SUMS"
+	}
+	return strings.ToUpper(string(a)) + "SUMS"
+}
+
+// tag is the algorithm name in the BSD-style "--tag" format.
+func (a Algorithm) tag() string {
+	if a == BLAKE2b {
+		return "BLAKE2b"
+	}
+	return strings.ToUpper(string(a))
+}
+
+// File returns the hex checksum of the file at path.
+func File(path string, a Algorithm) (string, error) {
+	sums, err := fileSums(path, []Algorithm{a})
+	if err != nil {
+		return "", err
+	}
+	return sums[a], nil
+}
+
 func WriteSHA256ChecksumFile(path string) error {
-	file, err := os.Open(path)
+	return WriteChecksumFile(path, SHA256)
+}
+
+// WriteChecksumFile writes the checksum of the file at path to a file with the same name plus
+// the algorithm's extension.
+func WriteChecksumFile(path string, a Algorithm) error {
+	sum, err := File(path, a)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	checksum := sha256.New()
-	if _, err = io.Copy(checksum, file); err != nil {
-		return err
+	_, err = WriteChecksumFiles(path, Sums{a: sum})
+	return err
+}
+
+// WriteChecksumFiles writes a checksum file for the file at path for each checksum in sums,
+// which were already computed, for example by a Hasher while the file was copied. Returns the
+// paths of the checksum files.
+func WriteChecksumFiles(path string, sums Sums) ([]string, error) {
+	var written []string
+	for _, a := range Algorithms {
+		sum, ok := sums[a]
+		if !ok {
+			continue
+		}
+		// Write the checksum in a format that "sha256sum -c" can work with. Use the base path of
+		// the tarball (not full path, not relative path) because then "sha256sum -c"
+		// automatically works when the file and the checksum file are downloaded to the same
+		// directory.
+		content := line(sum, filepath.Base(path))
+		outputPath := path + a.Ext()
+		if err := os.WriteFile(outputPath, []byte(content), 0o666); err != nil {
+			return nil, err
+		}
+		written = append(written, outputPath)
 	}
-	// Write the checksum in a format that "sha256sum -c" can work with. Use the base path of the
-	// tarball (not full path, not relative path) because then "sha256sum -c" automatically works
-	// when the file and the checksum file are downloaded to the same directory.
-	content := fmt.Sprintf("%v  %v\n", hex.EncodeToString(checksum.Sum(nil)), filepath.Base(path))
-	outputPath := path + ".sha256"
-	if err := os.WriteFile(outputPath, []byte(content), 0o666); err != nil {
-		return err
+	return written, nil
+}
+
+// WriteManifests writes a manifest with the checksums of files to dir for each of algs, named
+// by the algorithm's ManifestName. Each file must be in dir. Files are listed by name, in sorted
+// order. Returns the paths of the manifests.
+func WriteManifests(dir string, files []string, algs ...Algorithm) ([]string, error) {
+	return defaultEngine.WriteManifests(dir, files, algs...)
+}
+
+// WriteManifests is like the package-level WriteManifests, but hashes the files using e.
+func (e *Engine) WriteManifests(dir string, files []string, algs ...Algorithm) ([]string, error) {
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		if filepath.Clean(filepath.Dir(f)) != filepath.Clean(dir) {
+			return nil, fmt.Errorf("file %q is not in manifest dir %q", f, dir)
+		}
+		names = append(names, filepath.Base(f))
+	}
+	slices.Sort(names)
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = filepath.Join(dir, name)
+	}
+	sums, err := e.Files(paths, algs...)
+	if err != nil {
+		return nil, err
+	}
+	written := make([]string, 0, len(algs))
+	for _, a := range algs {
+		var b strings.Builder
+		for i, name := range names {
+			b.WriteString(line(sums[i][a], name))
+		}
+		outputPath := filepath.Join(dir, a.ManifestName())
+		if err := os.WriteFile(outputPath, []byte(b.String()), 0o666); err != nil {
+			return nil, err
+		}
+		written = append(written, outputPath)
+	}
+	return written, nil
+}
+
+func line(sum, name string) string {
+	return sum + "  " + name + "\n"
+}
+
+// Result is the result of verifying one file listed in a checksum file.
+type Result struct {
+	// Name is the file name as listed in the checksum file.
+	Name string
+	// Err is nil if the file's checksum matches.
+	Err error
+}
+
+// ErrMismatch is the error of a Result if the file's checksum doesn't match.
+var ErrMismatch = errors.New("checksum mismatch")
+
+// Verify checks the files listed in the checksum file at path, which may be a single-file
+// checksum file or a manifest. It accepts the formats written by "sha256sum", "sha512sum" and
+// "b2sum", with or without "--tag". Listed files are found relative to the checksum file's
+// directory, like running "sha256sum -c" in that directory.
+//
+// If the algorithm of a line isn't given by a tag, it's determined by the checksum file's name,
+// then by the length of the checksum.
+func Verify(path string) ([]Result, error) {
+	return defaultEngine.Verify(path)
+}
+
+// Verify is like the package-level Verify, but hashes the listed files using e.
+func (e *Engine) Verify(path string) ([]Result, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fileAlg := algorithmOfName(filepath.Base(path))
+	dir := filepath.Dir(path)
+
+	type check struct {
+		alg       Algorithm
+		sum, name string
+	}
+	var checks []check
+	s := bufio.NewScanner(bytes.NewReader(content))
+	for lineNum := 1; s.Scan(); lineNum++ {
+		text := strings.TrimSuffix(s.Text(), "\r")
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		alg, sum, name, err := parseLine(text, fileAlg)
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v: %v", path, lineNum, err)
+		}
+		checks = append(checks, check{alg, sum, name})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(checks) == 0 {
+		return nil, fmt.Errorf("%v: no checksums found", path)
+	}
+
+	results := make([]Result, len(checks))
+	// Failures are reported in the results, so ForEach never fails.
+	_ = ForEach(len(checks), e.Concurrency, func(i int) error {
+		c := checks[i]
+		got, err := File(filepath.Join(dir, filepath.FromSlash(c.name)), c.alg)
+		if err == nil && got != c.sum {
+			err = ErrMismatch
+		}
+		results[i] = Result{Name: c.name, Err: err}
+		return nil
+	})
+	return results, nil
+}
+
+// parseLine parses a GNU-style "<sum>  <name>" or "<sum> *<name>" line, or a BSD-style
+// "<TAG> (<name>) = <sum>" line.
+func parseLine(text string, fileAlg Algorithm) (alg Algorithm, sum, name string, err error) {
+	if tag, rest, ok := strings.Cut(text, " ("); ok && !strings.Contains(tag, " ") {
+		name, sum, ok = strings.Cut(rest, ") = ")
+		if !ok {
+			return "", "", "", fmt.Errorf("malformed line: %q", text)
+		}
+		for _, a := range Algorithms {
+			if a.tag() == tag {
+				alg = a
+			}
+		}
+		if alg == "" {
+			return "", "", "", fmt.Errorf("unknown algorithm tag %q", tag)
+		}
+	} else {
+		var ok bool
+		sum, name, ok = strings.Cut(text, " ")
+		if !ok || len(name) < 2 || (name[0] != ' ' && name[0] != '*') {
+			return "", "", "", fmt.Errorf("malformed line: %q", text)
+		}
+		name = name[1:]
+		alg = fileAlg
+		if alg == "" {
+			if alg, err = algorithmOfLength(len(sum)); err != nil {
+				return "", "", "", err
+			}
+		}
+	}
+	sum = strings.ToLower(sum)
+	if _, err := hex.DecodeString(sum); err != nil || len(sum) != 2*alg.New().Size() {
+		return "", "", "", fmt.Errorf("invalid %v checksum: %q", alg, sum)
+	}
+	return alg, sum, name, nil
+}
+
+// algorithmOfName returns the algorithm of a checksum file based on its name, or "" if the name
+// isn't conventional.
+func algorithmOfName(name string) Algorithm {
+	for _, a := range Algorithms {
+		if strings.HasSuffix(name, a.Ext()) || name == a.ManifestName() {
+			return a
+		}
+	}
+	return ""
+}
+
+func algorithmOfLength(n int) (Algorithm, error) {
+	switch n {
+	case 64:
+		return SHA256, nil
+	case 96:
+		return SHA384, nil
+	case 128:
+		// Could also be BLAKE2b, but b2sum output is normally in a B2SUMS or .b2 file.
+		return SHA512, nil
 	}
-	fmt.Printf("Wrote checksum file %q with content: %v", outputPath, content)
-	return nil
+	return "", fmt.Errorf("can't determine algorithm of %v-digit checksum", n)
 }
@@ -0,0 +1,170 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package checksum
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.tar.gz")
+	b := filepath.Join(dir, "b.zip")
+	for _, f := range []string{a, b} {
+		if err := os.WriteFile(f, []byte(filepath.Base(f)), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manifests, err := WriteManifests(dir, []string{b, a}, Algorithms...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wantManifests []string
+	for _, alg := range Algorithms {
+		wantManifests = append(wantManifests, filepath.Join(dir, alg.ManifestName()))
+	}
+	if !slices.Equal(manifests, wantManifests) {
+		t.Errorf("WriteManifests = %q, want %q", manifests, wantManifests)
+	}
+	checksumFiles := manifests
+	for _, alg := range Algorithms {
+		if err := WriteChecksumFile(a, alg); err != nil {
+			t.Fatal(err)
+		}
+		checksumFiles = append(checksumFiles, a+alg.Ext())
+	}
+	// "sha256sum --tag" output for a.tar.gz, and "sha256sum -b" output for b.zip.
+	tagged := filepath.Join(dir, "tagged.txt")
+	if err := os.WriteFile(tagged, []byte(
+		"SHA256 (a.tar.gz) = 0a67bba7da46793c9f1908a7eec3d06a11ba7bc00bf749c31bb134f4f45ebcad\n"+
+			"cf41e189cdc819769f648a9719d25ddfb16e0583d1a9f9391c31378831eb4523 *b.zip\n"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	checksumFiles = append(checksumFiles, tagged)
+
+	for _, p := range checksumFiles {
+		results, err := Verify(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range results {
+			if r.Err != nil {
+				t.Errorf("%v: %v: %v", p, r.Name, r.Err)
+			}
+		}
+	}
+
+	if err := os.WriteFile(b, []byte("changed"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	results, err := Verify(filepath.Join(dir, SHA512.ManifestName()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Err != nil || !errors.Is(results[1].Err, ErrMismatch) {
+		t.Errorf("after change, results = %v, want a.tar.gz OK and b.zip mismatch", results)
+	}
+}
+
+func TestEngine(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for i := range 10 {
+		p := filepath.Join(dir, fmt.Sprintf("f%v", i))
+		if err := os.WriteFile(p, bytes.Repeat([]byte{byte(i)}, 100000*i), 0o666); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, p)
+	}
+	e := &Engine{Concurrency: 3}
+	sums, err := e.Files(paths, Algorithms...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range paths {
+		for _, a := range Algorithms {
+			want, err := File(p, a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sums[i][a] != want {
+				t.Errorf("%v %v = %v, want %v", p, a, sums[i][a], want)
+			}
+		}
+	}
+
+	// Hashing while copying gives the same result as hashing the copy.
+	var dst bytes.Buffer
+	h := NewHasher(SHA256, BLAKE2b)
+	f, err := os.Open(paths[5])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(io.MultiWriter(&dst, h), f); err != nil {
+		t.Fatal(err)
+	}
+	if got := h.Sums(); got[SHA256] != sums[5][SHA256] || got[BLAKE2b] != sums[5][BLAKE2b] || len(got) != 2 {
+		t.Errorf("Hasher sums = %v, want sha256 and blake2b of %v", got, sums[5])
+	}
+
+	if _, err := e.Files(append(paths, filepath.Join(dir, "missing")), SHA256); err == nil {
+		t.Error("Files with a missing file succeeded")
+	}
+}
+
+func TestForEach(t *testing.T) {
+	var mu sync.Mutex
+	var running, maxRunning int
+	called := make([]bool, 20)
+	err := ForEach(len(called), 3, func(i int) error {
+		mu.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		called[i] = true
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if maxRunning > 3 {
+		t.Errorf("%v calls ran at the same time, want at most 3", maxRunning)
+	}
+	if slices.Contains(called, false) {
+		t.Errorf("not every index was called: %v", called)
+	}
+
+	// After a failure, no more calls are started, and the lowest failed index's error is returned.
+	var calls atomic.Int32
+	err = ForEach(100, 1, func(i int) error {
+		calls.Add(1)
+		if i >= 2 {
+			return fmt.Errorf("error %v", i)
+		}
+		return nil
+	})
+	if err == nil || err.Error() != "error 2" {
+		t.Errorf("ForEach error = %v, want error 2", err)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("ForEach made %v calls, want 3", n)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package checksum
+
+import (
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// Sums maps each algorithm to a hex checksum.
+type Sums map[Algorithm]string
+
+// Hasher is an io.Writer that computes the checksums of the data written to it with several
+// algorithms in one pass. For example, use it with io.MultiWriter to hash a file while copying it.
+type Hasher struct {
+	algs   []Algorithm
+	hashes []hash.Hash
+	w      io.Writer
+}
+
+// NewHasher returns a Hasher that computes checksums with each of algs.
+func NewHasher(algs ...Algorithm) *Hasher {
+	h := &Hasher{algs: algs}
+	ws := make([]io.Writer, 0, len(algs))
+	for _, a := range algs {
+		hh := a.New()
+		h.hashes = append(h.hashes, hh)
+		ws = append(ws, hh)
+	}
+	h.w = io.MultiWriter(ws...)
+	return h
+}
+
+func (h *Hasher) Write(p []byte) (int, error) {
+	return h.w.Write(p)
+}
+
+// Sums returns the checksums of the data written so far.
+func (h *Hasher) Sums() Sums {
+	sums := make(Sums, len(h.algs))
+	for i, a := range h.algs {
+		sums[a] = hex.EncodeToString(h.hashes[i].Sum(nil))
+	}
+	return sums
+}
+
+// Engine computes the checksums of files concurrently. Each file is read once, no matter how
+// many algorithms are used.
+type Engine struct {
+	// Concurrency is the maximum number of files read at the same time. If zero,
+	// runtime.NumCPU is used.
+	Concurrency int
+}
+
+// Files returns the checksums of each file in paths with each of algs, in the same order as
+// paths. If reading any file fails, no more files are read, and the error for the earliest file
+// that failed is returned.
+func (e *Engine) Files(paths []string, algs ...Algorithm) ([]Sums, error) {
+	sums := make([]Sums, len(paths))
+	err := ForEach(len(paths), e.Concurrency, func(i int) error {
+		s, err := fileSums(paths[i], algs)
+		sums[i] = s
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sums, nil
+}
+
+func fileSums(path string, algs []Algorithm) (Sums, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := NewHasher(algs...)
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sums(), nil
+}
+
+// ForEach calls f for each index below n, running at most concurrency calls at the same time. If
+// concurrency is zero or less, runtime.NumCPU is used. If any call returns an error, no more calls
+// are started, and the error of the lowest index that failed is returned once the calls already in
+// progress have finished.
+func ForEach(n, concurrency int, f func(i int) error) error {
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+	errs := make([]error, n)
+	sem := make(chan struct{}, concurrency)
+
+	var failMu sync.Mutex
+	var failed bool
+
+	var wg sync.WaitGroup
+	for i := range n {
+		sem <- struct{}{}
+		failMu.Lock()
+		stop := failed
+		failMu.Unlock()
+		if stop {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := f(i); err != nil {
+				errs[i] = err
+				failMu.Lock()
+				failed = true
+				failMu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// defaultEngine is used by the package-level functions.
+var defaultEngine Engine
@@ -0,0 +1,296 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linuxpkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/microsoft/go/_util/internal/archive"
+)
+
+const (
+	arMagic       = "!<arch>\n"
+	arHeaderSize  = 60
+	debBinaryName = "debian-binary"
+	debControl    = "control.tar.gz"
+	debData       = "data.tar.gz"
+	// debSigName is the ar member that holds a detached signature of the package, as created by
+	// debsigs and checked by debsig-verify.
+	debSigName = "_gpgorigin"
+)
+
+func buildDEB(src, dst, arch string, m *Metadata) ([]*file, error) {
+	tmp, err := os.MkdirTemp(filepath.Dir(dst), filepath.Base(dst)+".tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	// Write the data archive while reading the source archive, then the control archive, which
+	// needs the file list.
+	dataPath := filepath.Join(tmp, debData)
+	var files []*file
+	if err := archive.WithTarGzCreate(dataPath, runtime.NumCPU(), func(tw *tar.Writer) error {
+		files, err = walkSource(src, m, "/usr", func(f *file, r io.Reader) error {
+			return writeDEBDataEntry(tw, f, r)
+		})
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	control, err := debControlArchive(arch, m, files)
+	if err != nil {
+		return nil, err
+	}
+
+	return files, archive.WithFileCreate(dst, func(out *os.File) error {
+		if _, err := io.WriteString(out, arMagic); err != nil {
+			return err
+		}
+		if err := writeARMember(out, debBinaryName, m.BuildTime, 0o100644, int64(len("2.0\n")), strings.NewReader("2.0\n")); err != nil {
+			return err
+		}
+		if err := writeARMember(out, debControl, m.BuildTime, 0o100644, int64(len(control)), bytes.NewReader(control)); err != nil {
+			return err
+		}
+		return archive.WithFileOpen(dataPath, func(data *os.File) error {
+			stat, err := data.Stat()
+			if err != nil {
+				return err
+			}
+			return writeARMember(out, debData, m.BuildTime, 0o100644, stat.Size(), data)
+		})
+	})
+}
+
+func writeDEBDataEntry(tw *tar.Writer, f *file, r io.Reader) error {
+	h := &tar.Header{
+		Name:    "." + f.path,
+		Mode:    int64(f.mode.Perm()),
+		ModTime: f.modTime,
+		Uname:   "root",
+		Gname:   "root",
+	}
+	switch {
+	case f.mode.IsDir():
+		h.Typeflag = tar.TypeDir
+		h.Name += "/"
+	case f.mode&fs.ModeSymlink != 0:
+		h.Typeflag = tar.TypeSymlink
+		h.Linkname = f.linkname
+	default:
+		h.Typeflag = tar.TypeReg
+		h.Size = f.size
+	}
+	if err := tw.WriteHeader(h); err != nil {
+		return err
+	}
+	if r != nil {
+		if _, err := io.Copy(tw, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// debControlArchive returns the content of control.tar.gz: the control file, the md5sums file,
+// and maintainer scripts that register the alternatives.
+func debControlArchive(arch string, m *Metadata, files []*file) ([]byte, error) {
+	var installedSize int64
+	var md5sums strings.Builder
+	for _, f := range files {
+		if f.mode.IsRegular() {
+			installedSize += f.size
+			fmt.Fprintf(&md5sums, "%v  %v\n", f.md5, strings.TrimPrefix(f.path, "/"))
+		}
+	}
+
+	var control strings.Builder
+	fmt.Fprintf(&control, "Package: %v\n", m.Name)
+	fmt.Fprintf(&control, "Version: %v-%v\n", m.Version, m.Release)
+	fmt.Fprintf(&control, "Architecture: %v\n", arch)
+	fmt.Fprintf(&control, "Maintainer: %v\n", m.Maintainer)
+	// Installed-Size is in KiB.
+	fmt.Fprintf(&control, "Installed-Size: %v\n", (installedSize+1023)/1024)
+	fmt.Fprintf(&control, "Section: devel\n")
+	fmt.Fprintf(&control, "Priority: optional\n")
+	fmt.Fprintf(&control, "Homepage: %v\n", m.URL)
+	fmt.Fprintf(&control, "Description: %v\n", m.Summary)
+	fmt.Fprintf(&control, " %v\n", m.Description)
+
+	controlFiles := []struct {
+		name    string
+		mode    int64
+		content string
+	}{
+		{"control", 0o644, control.String()},
+		{"md5sums", 0o644, md5sums.String()},
+		{"postinst", 0o755, m.postInstallScript("update-alternatives")},
+		{"prerm", 0o755, m.preUninstallScript("update-alternatives", `[ "$1" = remove ] || [ "$1" = deconfigure ]`)},
+	}
+
+	var b bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&b, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir, Name: "./", Mode: 0o755, ModTime: m.BuildTime, Uname: "root", Gname: "root",
+	}); err != nil {
+		return nil, err
+	}
+	for _, cf := range controlFiles {
+		if cf.content == "" {
+			continue
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     "./" + cf.name,
+			Mode:     cf.mode,
+			Size:     int64(len(cf.content)),
+			ModTime:  m.BuildTime,
+			Uname:    "root",
+			Gname:    "root",
+		}); err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(tw, cf.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := cmp.Or(tw.Close(), gz.Close()); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// writeARMember writes a member of a common-format ar archive, as used by dpkg.
+func writeARMember(w io.Writer, name string, modTime time.Time, mode uint32, size int64, r io.Reader) error {
+	if len(name) > 16 {
+		return fmt.Errorf("ar member name too long: %q", name)
+	}
+	header := fmt.Sprintf("%-16s%-12d%-6d%-6d%-8o%-10d`\n", name, modTime.Unix(), 0, 0, mode, size)
+	if len(header) != arHeaderSize {
+		return fmt.Errorf("invalid ar header for %q", name)
+	}
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("ar member %q: wrote %v bytes, expected %v", name, n, size)
+	}
+	// Members are aligned to 2 bytes.
+	if size%2 != 0 {
+		_, err = io.WriteString(w, "\n")
+	}
+	return err
+}
+
+type arMember struct {
+	name    string
+	modTime time.Time
+	mode    uint32
+	data    []byte
+}
+
+// readAR reads all members of an ar archive.
+func readAR(data []byte) ([]*arMember, error) {
+	rest, ok := bytes.CutPrefix(data, []byte(arMagic))
+	if !ok {
+		return nil, errors.New("not an ar archive")
+	}
+	var members []*arMember
+	for len(rest) > 0 {
+		if len(rest) < arHeaderSize {
+			return nil, errors.New("truncated ar header")
+		}
+		h := string(rest[:arHeaderSize])
+		rest = rest[arHeaderSize:]
+		if h[58:60] != "`\n" {
+			return nil, fmt.Errorf("invalid ar header: %q", h)
+		}
+		name := strings.TrimSuffix(strings.TrimRight(h[:16], " "), "/")
+		mtime, err1 := strconv.ParseInt(strings.TrimSpace(h[16:28]), 10, 64)
+		mode, err2 := strconv.ParseUint(strings.TrimSpace(h[40:48]), 8, 32)
+		size, err3 := strconv.ParseInt(strings.TrimSpace(h[48:58]), 10, 64)
+		if err := cmp.Or(err1, err2, err3); err != nil {
+			return nil, fmt.Errorf("invalid ar header %q: %v", h, err)
+		}
+		if size < 0 || size > int64(len(rest)) {
+			return nil, fmt.Errorf("ar member %q is truncated", name)
+		}
+		members = append(members, &arMember{
+			name:    name,
+			modTime: time.Unix(mtime, 0),
+			mode:    uint32(mode),
+			data:    rest[:size],
+		})
+		rest = rest[size:]
+		if size%2 != 0 && len(rest) > 0 {
+			rest = rest[1:]
+		}
+	}
+	return members, nil
+}
+
+// debSignedContent returns the data signed by debsigs: the concatenation of the debian-binary,
+// control, and data members.
+func debSignedContent(members []*arMember) ([]byte, error) {
+	var b bytes.Buffer
+	for _, name := range []string{debBinaryName, "control.tar", "data.tar"} {
+		found := false
+		for _, m := range members {
+			if m.name == name || strings.HasPrefix(m.name, name+".") {
+				b.Write(m.data)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("deb package has no %q member", name)
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// embedDEBSignature writes the deb package with the given detached signature added as the
+// _gpgorigin member, replacing any existing signature.
+func embedDEBSignature(members []*arMember, sig []byte, dst string) error {
+	return archive.WithFileCreate(dst, func(out *os.File) error {
+		if _, err := io.WriteString(out, arMagic); err != nil {
+			return err
+		}
+		var modTime time.Time
+		for _, m := range members {
+			if m.name == debSigName {
+				continue
+			}
+			modTime = m.modTime
+			if err := writeARMember(out, m.name, m.modTime, m.mode, int64(len(m.data)), bytes.NewReader(m.data)); err != nil {
+				return err
+			}
+		}
+		return writeARMember(out, debSigName, modTime, 0o100644, int64(len(sig)), bytes.NewReader(sig))
+	})
+}
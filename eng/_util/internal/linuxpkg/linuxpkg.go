@@ -0,0 +1,341 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package linuxpkg creates RPM and DEB packages from a Linux Go distribution tar.gz, and embeds
+// signatures into them. It is implemented in pure Go so packages can be created on any build
+// machine without rpmbuild or dpkg-deb.
+package linuxpkg
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/microsoft/go/_util/internal/archive"
+	"github.com/microsoft/go/_util/internal/checksum"
+)
+
+// Format is a Linux package format.
+type Format string
+
+const (
+	// RPM is used by Azure Linux, Fedora, RHEL, etc.
+	RPM Format = "rpm"
+	// DEB is used by Ubuntu, Debian, etc.
+	DEB Format = "deb"
+)
+
+// FormatOf returns the package format of the file at p, based on its file extension.
+func FormatOf(p string) (Format, error) {
+	switch {
+	case strings.HasSuffix(p, ".rpm"):
+		return RPM, nil
+	case strings.HasSuffix(p, ".deb"):
+		return DEB, nil
+	}
+	return "", fmt.Errorf("unknown package type: %s", p)
+}
+
+// Metadata describes the package to create.
+type Metadata struct {
+	// Name is the package name, e.g. "msft-golang".
+	Name string
+	// Version is the upstream Go version without the "go" prefix, e.g. "1.23.1".
+	Version string
+	// Release is the Microsoft revision, e.g. "1".
+	Release string
+	// GOARCH is the Go architecture name of the distribution, e.g. "amd64" or "armv6l".
+	GOARCH string
+
+	Summary     string
+	Description string
+	License     string
+	URL         string
+	Maintainer  string
+
+	// InstallDir is the absolute path where the "go" directory of the archive is installed.
+	InstallDir string
+	// Alternatives are the commands in InstallDir/bin that are linked from /usr/bin using the
+	// alternatives system. The first is the master link, and the rest are slaves.
+	Alternatives []string
+	// Priority is the alternatives priority.
+	Priority int
+
+	// BuildTime is recorded in the package and used as the package's own file timestamps. If
+	// zero, the newest modification time in the source archive is used so the package is
+	// reproducible.
+	BuildTime time.Time
+}
+
+// DefaultInstallDir is the install location used by the Azure Linux and Fedora golang packages.
+// The DEB package uses it too, so the same instructions work on both.
+const DefaultInstallDir = "/usr/lib/golang"
+
+// NewMetadata returns metadata for a package created from the Microsoft Go archive at p, such as
+// "go1.23.1-1.linux-amd64.tar.gz", using the version and architecture in the filename.
+func NewMetadata(p string) (*Metadata, error) {
+	n, err := archive.ParseName(p)
+	if err != nil {
+		return nil, err
+	}
+	if n.GOOS != "linux" || n.Format != archive.TarGz {
+		return nil, fmt.Errorf("not a Linux tar.gz archive: %q", filepath.Base(p))
+	}
+	// Neither format allows '-' in the release, and BUILD_BUILDNUMBER may contain it.
+	release := strings.ReplaceAll(n.Release, "-", ".")
+	return &Metadata{
+		Name:    "msft-golang",
+		Version: n.Version,
+		Release: release,
+		GOARCH:  n.GOARCH,
+		Summary: "The Go programming language, built by Microsoft",
+		Description: "Go is an open source programming language that makes it easy to build simple, " +
+			"reliable, and efficient software. This build is produced by Microsoft and includes " +
+			"support for using system crypto libraries for FIPS compliance.",
+		License:      "BSD-3-Clause",
+		URL:          "https://github.com/microsoft/go",
+		Maintainer:   "Microsoft Go Team <golangdev@microsoft.com>",
+		InstallDir:   DefaultInstallDir,
+		Alternatives: []string{"go", "gofmt"},
+		Priority:     100,
+	}, nil
+}
+
+// Arch returns the package architecture name for GOARCH in the given format.
+func (m *Metadata) Arch(format Format) (string, error) {
+	type arches struct{ rpm, deb string }
+	a, ok := map[string]arches{
+		"amd64":  {"x86_64", "amd64"},
+		"arm64":  {"aarch64", "arm64"},
+		"armv6l": {"armv6hl", "armhf"},
+		"386":    {"i686", "i386"},
+	}[m.GOARCH]
+	if !ok {
+		return "", fmt.Errorf("unsupported architecture for Linux packages: %q", m.GOARCH)
+	}
+	if format == RPM {
+		return a.rpm, nil
+	}
+	return a.deb, nil
+}
+
+// Filename returns the conventional filename of the package. It matches the archive naming
+// pattern so the package is found by the same tools.
+func (m *Metadata) Filename(format Format) string {
+	return "go" + m.Version + "-" + m.Release + ".linux-" + m.GOARCH + "." + string(format)
+}
+
+// postInstallScript returns a shell script that registers the alternatives using cmd.
+func (m *Metadata) postInstallScript(cmd string) string {
+	if len(m.Alternatives) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\nset -e\n")
+	fmt.Fprintf(&b, "%v --install /usr/bin/%v %v %v/bin/%v %v",
+		cmd, m.Alternatives[0], m.Alternatives[0], m.InstallDir, m.Alternatives[0], m.Priority)
+	for _, a := range m.Alternatives[1:] {
+		fmt.Fprintf(&b, " \\\n  --slave /usr/bin/%v %v %v/bin/%v", a, a, m.InstallDir, a)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// preUninstallScript returns a shell script that unregisters the alternatives using cmd when
+// condition is true.
+func (m *Metadata) preUninstallScript(cmd, condition string) string {
+	if len(m.Alternatives) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("#!/bin/sh\nset -e\nif %v; then\n  %v --remove %v %v/bin/%v\nfi\n",
+		condition, cmd, m.Alternatives[0], m.InstallDir, m.Alternatives[0])
+}
+
+// Manifest describes a package created by Build. It doesn't include the package's own hash
+// because signing may embed a signature into the package after it's created.
+type Manifest struct {
+	Format   Format `json:"format"`
+	Filename string `json:"filename"`
+
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Release string `json:"release"`
+	Arch    string `json:"arch"`
+
+	InstallDir   string   `json:"installDir"`
+	Alternatives []string `json:"alternatives,omitempty"`
+
+	// InstalledSize is the total size of the regular files in bytes.
+	InstalledSize int64 `json:"installedSize"`
+	FileCount     int   `json:"fileCount"`
+	BuildTime     int64 `json:"buildTime"`
+
+	// Source is the filename of the archive the package was created from.
+	Source       string `json:"source"`
+	SourceSHA256 string `json:"sourceSHA256"`
+}
+
+// WriteFile writes the manifest as indented JSON.
+func (m *Manifest) WriteFile(p string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p, append(data, '\n'), 0o666)
+}
+
+// Build creates a package in the given format at dst from the Go distribution tar.gz at src.
+func Build(format Format, src, dst string, m *Metadata) (*Manifest, error) {
+	arch, err := m.Arch(format)
+	if err != nil {
+		return nil, err
+	}
+	if !path.IsAbs(m.InstallDir) {
+		return nil, fmt.Errorf("install dir must be absolute: %q", m.InstallDir)
+	}
+	sourceSHA256, err := checksum.File(src, checksum.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	// Copy m: the build time may be filled in from the archive.
+	mc := *m
+	m = &mc
+
+	var files []*file
+	switch format {
+	case RPM:
+		files, err = buildRPM(src, dst, arch, m)
+	case DEB:
+		files, err = buildDEB(src, dst, arch, m)
+	default:
+		err = fmt.Errorf("unsupported package format: %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %v package %q: %v", format, dst, err)
+	}
+
+	manifest := &Manifest{
+		Format:       format,
+		Filename:     filepath.Base(dst),
+		Name:         m.Name,
+		Version:      m.Version,
+		Release:      m.Release,
+		Arch:         arch,
+		InstallDir:   m.InstallDir,
+		Alternatives: m.Alternatives,
+		BuildTime:    m.BuildTime.Unix(),
+		Source:       filepath.Base(src),
+		SourceSHA256: sourceSHA256,
+	}
+	for _, f := range files {
+		if f.mode.IsRegular() {
+			manifest.InstalledSize += f.size
+			manifest.FileCount++
+		}
+	}
+	return manifest, nil
+}
+
+// file is a file, directory, or symlink installed by the package.
+type file struct {
+	// path is the absolute install path.
+	path     string
+	mode     fs.FileMode
+	size     int64
+	modTime  time.Time
+	linkname string
+	sha256   string
+	md5      string
+}
+
+// walkFunc is called for each file installed by a package, in an order where each directory comes
+// before its content. For regular files, r reads the content.
+type walkFunc func(f *file, r io.Reader) error
+
+// walkSource calls fn for each file in the Go distribution archive at src, mapped to its install
+// path under m.InstallDir. Parent directories are included even if the archive doesn't have an
+// entry for them, starting at topDir. The digests of regular files are filled in after fn returns
+// so fn must read the whole content. If m.BuildTime is zero, it is set to the newest modification
+// time in the archive. Returns every file sorted by path, including directories.
+func walkSource(src string, m *Metadata, topDir string, fn walkFunc) ([]*file, error) {
+	a, err := archive.New(src)
+	if err != nil {
+		return nil, err
+	}
+	if a.Format != archive.TarGz {
+		return nil, fmt.Errorf("expected a tar.gz archive: %q", src)
+	}
+
+	autoTime := m.BuildTime.IsZero()
+	var files []*file
+	seenDirs := make(map[string]struct{})
+	var addDir func(dir string, modTime time.Time) error
+	addDir = func(dir string, modTime time.Time) error {
+		if _, ok := seenDirs[dir]; ok {
+			return nil
+		}
+		if dir != topDir {
+			if err := addDir(path.Dir(dir), modTime); err != nil {
+				return err
+			}
+		}
+		seenDirs[dir] = struct{}{}
+		f := &file{path: dir, mode: fs.ModeDir | 0o755, modTime: modTime}
+		files = append(files, f)
+		return fn(f, nil)
+	}
+	if !strings.HasPrefix(m.InstallDir, topDir) {
+		return nil, fmt.Errorf("install dir %q isn't under %q", m.InstallDir, topDir)
+	}
+
+	err = a.Walk(func(e *archive.Entry, r io.Reader) error {
+		rel, ok := strings.CutPrefix(strings.TrimSuffix(e.Name, "/"), "go")
+		if !ok || (rel != "" && !strings.HasPrefix(rel, "/")) {
+			return fmt.Errorf("entry isn't in the 'go' directory: %q", e.Name)
+		}
+		p := m.InstallDir + rel
+		if autoTime && e.ModTime.After(m.BuildTime) {
+			m.BuildTime = e.ModTime
+		}
+		if e.IsDir() {
+			return addDir(p, e.ModTime)
+		}
+		if err := addDir(path.Dir(p), e.ModTime); err != nil {
+			return err
+		}
+		f := &file{path: p, mode: e.Mode, size: e.Size, modTime: e.ModTime, linkname: e.Linkname}
+		switch {
+		case e.IsRegular():
+			sha, md := sha256.New(), md5.New()
+			if err := fn(f, io.TeeReader(r, io.MultiWriter(sha, md))); err != nil {
+				return err
+			}
+			f.sha256 = hex.EncodeToString(sha.Sum(nil))
+			f.md5 = hex.EncodeToString(md.Sum(nil))
+		case e.Mode&fs.ModeSymlink != 0:
+			if err := fn(f, nil); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported entry type for %q: %v", e.Name, e.Mode)
+		}
+		files = append(files, f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(files, func(a, b *file) int { return strings.Compare(a.path, b.path) })
+	return files, nil
+}
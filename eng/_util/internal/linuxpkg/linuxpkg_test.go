@@ -0,0 +1,127 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linuxpkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/microsoft/go/_util/internal/archive"
+)
+
+func writeTestArchive(t *testing.T, dir string) string {
+	src := filepath.Join(dir, "go1.23.1-1.linux-amd64.tar.gz")
+	modTime := time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC)
+	if err := archive.WithTarGzCreate(src, 1, func(tw *tar.Writer) error {
+		for _, h := range []*tar.Header{
+			{Typeflag: tar.TypeDir, Name: "go/", Mode: 0o755},
+			{Typeflag: tar.TypeReg, Name: "go/bin/go", Mode: 0o755, Size: 3},
+			{Typeflag: tar.TypeReg, Name: "go/src/a.go", Mode: 0o644, Size: 3},
+			{Typeflag: tar.TypeSymlink, Name: "go/src/link.go", Mode: 0o777, Linkname: "a.go"},
+		} {
+			h.ModTime = modTime
+			if err := tw.WriteHeader(h); err != nil {
+				return err
+			}
+			if h.Size > 0 {
+				if _, err := tw.Write([]byte("abc")); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return src
+}
+
+func TestBuildAndEmbedSignature(t *testing.T) {
+	dir := t.TempDir()
+	src := writeTestArchive(t, dir)
+	m, err := NewMetadata(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []Format{RPM, DEB} {
+		t.Run(string(format), func(t *testing.T) {
+			dst := filepath.Join(dir, m.Filename(format))
+			manifest, err := Build(format, src, dst, m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if manifest.FileCount != 2 || manifest.InstalledSize != 6 {
+				t.Errorf("manifest has %v files, %v bytes; want 2 files, 6 bytes", manifest.FileCount, manifest.InstalledSize)
+			}
+
+			content, err := SignedContent(format, dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signed := dst + ".signed"
+			if err := EmbedSignature(format, dst, []byte("first"), signed); err != nil {
+				t.Fatal(err)
+			}
+			// Embedding again replaces the signature rather than adding another one.
+			if err := EmbedSignature(format, signed, []byte("second"), signed+"2"); err != nil {
+				t.Fatal(err)
+			}
+			signedContent, err := SignedContent(format, signed+"2")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(content, signedContent) {
+				t.Error("embedding a signature changed the signed content")
+			}
+
+			data, err := os.ReadFile(signed + "2")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sigs [][]byte
+			switch format {
+			case RPM:
+				parts, err := readRPM(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, e := range parts.signature.entries {
+					if e.tag == rpmSigTagRSA {
+						sigs = append(sigs, e.data)
+					}
+				}
+			case DEB:
+				members, err := readAR(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, m := range members {
+					if m.name == debSigName {
+						sigs = append(sigs, m.data)
+					}
+				}
+			}
+			if len(sigs) != 1 || string(sigs[0]) != "second" {
+				t.Errorf("signatures = %q, want [second]", sigs)
+			}
+		})
+	}
+}
+
+func TestDearmor(t *testing.T) {
+	armored := "-----BEGIN PGP SIGNATURE-----\nVersion: test\n\nAQID\nBAU=\n=abcd\n-----END PGP SIGNATURE-----\n"
+	got, err := dearmor([]byte(armored))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{1, 2, 3, 4, 5}; !bytes.Equal(got, want) {
+		t.Errorf("dearmor = %v, want %v", got, want)
+	}
+}
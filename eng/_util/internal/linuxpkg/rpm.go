@@ -0,0 +1,626 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linuxpkg
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/microsoft/go/_util/internal/archive"
+)
+
+// RPM file format: https://rpm-software-management.github.io/rpm/manual/format.html
+
+const (
+	rpmLeadSize = 96
+
+	rpmTypeInt16       = 3
+	rpmTypeInt32       = 4
+	rpmTypeString      = 6
+	rpmTypeBin         = 7
+	rpmTypeStringArray = 8
+	rpmTypeI18NString  = 9
+
+	rpmTagHeaderSignatures = 62
+	rpmTagHeaderImmutable  = 63
+	rpmTagHeaderI18NTable  = 100
+
+	rpmSigTagRSA         = 268
+	rpmSigTagSHA1        = 269
+	rpmSigTagSHA256      = 273
+	rpmSigTagSize        = 1000
+	rpmSigTagMD5         = 1004
+	rpmSigTagPayloadSize = 1007
+
+	rpmTagName              = 1000
+	rpmTagVersion           = 1001
+	rpmTagRelease           = 1002
+	rpmTagSummary           = 1004
+	rpmTagDescription       = 1005
+	rpmTagBuildTime         = 1006
+	rpmTagBuildHost         = 1007
+	rpmTagSize              = 1009
+	rpmTagLicense           = 1014
+	rpmTagGroup             = 1016
+	rpmTagURL               = 1020
+	rpmTagOS                = 1021
+	rpmTagArch              = 1022
+	rpmTagPostIn            = 1024
+	rpmTagPreUn             = 1025
+	rpmTagFileSizes         = 1028
+	rpmTagFileModes         = 1030
+	rpmTagFileRDevs         = 1033
+	rpmTagFileMTimes        = 1034
+	rpmTagFileDigests       = 1035
+	rpmTagFileLinkTos       = 1036
+	rpmTagFileFlags         = 1037
+	rpmTagFileUserName      = 1039
+	rpmTagFileGroupName     = 1040
+	rpmTagSourceRPM         = 1044
+	rpmTagFileVerifyFlags   = 1045
+	rpmTagArchiveSize       = 1046
+	rpmTagProvideName       = 1047
+	rpmTagRequireFlags      = 1048
+	rpmTagRequireName       = 1049
+	rpmTagRequireVersion    = 1050
+	rpmTagPostInProg        = 1086
+	rpmTagPreUnProg         = 1087
+	rpmTagFileDevices       = 1095
+	rpmTagFileInodes        = 1096
+	rpmTagFileLangs         = 1097
+	rpmTagProvideFlags      = 1112
+	rpmTagProvideVersion    = 1113
+	rpmTagDirIndexes        = 1116
+	rpmTagBaseNames         = 1117
+	rpmTagDirNames          = 1118
+	rpmTagPayloadFormat     = 1124
+	rpmTagPayloadCompressor = 1125
+	rpmTagPayloadFlags      = 1126
+	rpmTagFileDigestAlgo    = 5011
+	rpmTagPayloadDigest     = 5092
+	rpmTagPayloadDigestAlgo = 5093
+
+	// rpmDigestSHA256 is the PGPHASHALGO value for SHA-256.
+	rpmDigestSHA256 = 8
+
+	rpmSenseLess   = 1 << 1
+	rpmSenseEqual  = 1 << 3
+	rpmSenseInterp = 1 << 8
+	rpmSensePost   = 1 << 10
+	rpmSensePreUn  = 1 << 12
+	rpmSenseRPMLib = 1 << 24
+)
+
+var rpmHeaderMagic = []byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0}
+
+// rpmEntry is one tag in an RPM header, with its data already encoded.
+type rpmEntry struct {
+	tag   int32
+	typ   uint32
+	count uint32
+	data  []byte
+}
+
+// rpmHeader is an RPM header structure, used for both the signature header and the main header.
+type rpmHeader struct {
+	entries []*rpmEntry
+}
+
+func (h *rpmHeader) add(tag int32, typ uint32, count int, data []byte) {
+	h.entries = append(h.entries, &rpmEntry{tag: tag, typ: typ, count: uint32(count), data: data})
+}
+
+func (h *rpmHeader) addString(tag int32, s string) {
+	h.add(tag, rpmTypeString, 1, append([]byte(s), 0))
+}
+
+func (h *rpmHeader) addI18NString(tag int32, s string) {
+	h.add(tag, rpmTypeI18NString, 1, append([]byte(s), 0))
+}
+
+func (h *rpmHeader) addStrings(tag int32, ss []string) {
+	var b []byte
+	for _, s := range ss {
+		b = append(append(b, s...), 0)
+	}
+	h.add(tag, rpmTypeStringArray, len(ss), b)
+}
+
+func (h *rpmHeader) addInt32s(tag int32, vs []int32) {
+	b := make([]byte, 0, 4*len(vs))
+	for _, v := range vs {
+		b = binary.BigEndian.AppendUint32(b, uint32(v))
+	}
+	h.add(tag, rpmTypeInt32, len(vs), b)
+}
+
+func (h *rpmHeader) addInt16s(tag int32, vs []int16) {
+	b := make([]byte, 0, 2*len(vs))
+	for _, v := range vs {
+		b = binary.BigEndian.AppendUint16(b, uint16(v))
+	}
+	h.add(tag, rpmTypeInt16, len(vs), b)
+}
+
+func (h *rpmHeader) addBin(tag int32, b []byte) {
+	h.add(tag, rpmTypeBin, len(b), b)
+}
+
+// marshal encodes the header as an immutable region with the given region tag.
+func (h *rpmHeader) marshal(regionTag int32) []byte {
+	entries := slices.Clone(h.entries)
+	slices.SortStableFunc(entries, func(a, b *rpmEntry) int { return cmp.Compare(a.tag, b.tag) })
+
+	nindex := len(entries) + 1
+	var index, store []byte
+	appendIndex := func(tag int32, typ uint32, offset int32, count uint32) {
+		index = binary.BigEndian.AppendUint32(index, uint32(tag))
+		index = binary.BigEndian.AppendUint32(index, typ)
+		index = binary.BigEndian.AppendUint32(index, uint32(offset))
+		index = binary.BigEndian.AppendUint32(index, count)
+	}
+	for _, e := range entries {
+		var align int
+		switch e.typ {
+		case rpmTypeInt16:
+			align = 2
+		case rpmTypeInt32:
+			align = 4
+		}
+		for align != 0 && len(store)%align != 0 {
+			store = append(store, 0)
+		}
+		appendIndex(e.tag, e.typ, int32(len(store)), e.count)
+		store = append(store, e.data...)
+	}
+	// The region trailer is stored at the end of the data. Its offset is negative: it points back
+	// to the start of the index, the region entry itself.
+	regionIndex := index
+	index = nil
+	appendIndex(regionTag, rpmTypeBin, int32(len(store)), 16)
+	index = append(index, regionIndex...)
+	store = binary.BigEndian.AppendUint32(store, uint32(regionTag))
+	store = binary.BigEndian.AppendUint32(store, rpmTypeBin)
+	store = binary.BigEndian.AppendUint32(store, uint32(int32(-16*nindex)))
+	store = binary.BigEndian.AppendUint32(store, 16)
+
+	b := slices.Clone(rpmHeaderMagic)
+	b = binary.BigEndian.AppendUint32(b, uint32(nindex))
+	b = binary.BigEndian.AppendUint32(b, uint32(len(store)))
+	b = append(b, index...)
+	return append(b, store...)
+}
+
+// parseRPMHeader parses the header at the start of data, and returns it and its encoded length.
+// Region entries are omitted: marshal recreates them.
+func parseRPMHeader(data []byte) (*rpmHeader, int, error) {
+	if len(data) < 16 || !bytes.Equal(data[:8], rpmHeaderMagic) {
+		return nil, 0, errors.New("invalid RPM header magic")
+	}
+	nindex := int(binary.BigEndian.Uint32(data[8:]))
+	hsize := int(binary.BigEndian.Uint32(data[12:]))
+	storeStart := 16 + 16*nindex
+	length := storeStart + hsize
+	if nindex > 1<<16 || hsize > 1<<28 || len(data) < length {
+		return nil, 0, errors.New("truncated RPM header")
+	}
+	store := data[storeStart:length]
+	h := &rpmHeader{}
+	for i := 0; i < nindex; i++ {
+		ie := data[16+16*i:]
+		e := &rpmEntry{
+			tag:   int32(binary.BigEndian.Uint32(ie)),
+			typ:   binary.BigEndian.Uint32(ie[4:]),
+			count: binary.BigEndian.Uint32(ie[12:]),
+		}
+		offset := int(int32(binary.BigEndian.Uint32(ie[8:])))
+		if e.tag == rpmTagHeaderSignatures || e.tag == rpmTagHeaderImmutable {
+			continue
+		}
+		if offset < 0 || offset > len(store) {
+			return nil, 0, fmt.Errorf("RPM header tag %v has invalid offset %v", e.tag, offset)
+		}
+		n, err := rpmEntrySize(e.typ, int(e.count), store[offset:])
+		if err != nil {
+			return nil, 0, fmt.Errorf("RPM header tag %v: %v", e.tag, err)
+		}
+		e.data = store[offset : offset+n]
+		h.entries = append(h.entries, e)
+	}
+	return h, length, nil
+}
+
+// rpmEntrySize returns the encoded size of an entry's data that starts at the beginning of b.
+func rpmEntrySize(typ uint32, count int, b []byte) (int, error) {
+	var n int
+	switch typ {
+	case 0, 1, 2, rpmTypeBin:
+		n = count
+	case rpmTypeInt16:
+		n = 2 * count
+	case rpmTypeInt32:
+		n = 4 * count
+	case 5:
+		n = 8 * count
+	case rpmTypeString, rpmTypeStringArray, rpmTypeI18NString:
+		for i := 0; i < count; i++ {
+			end := bytes.IndexByte(b[n:], 0)
+			if end < 0 {
+				return 0, errors.New("unterminated string")
+			}
+			n += end + 1
+		}
+	default:
+		return 0, fmt.Errorf("unknown type %v", typ)
+	}
+	if n < 0 || n > len(b) {
+		return 0, errors.New("data out of range")
+	}
+	return n, nil
+}
+
+// rpmLead returns the legacy lead that starts every RPM file. Modern tools only check the magic,
+// but the rest is filled in for older tools.
+func rpmLead(m *Metadata) []byte {
+	b := []byte{0xed, 0xab, 0xee, 0xdb, 3, 0}
+	b = binary.BigEndian.AppendUint16(b, 0) // Binary package.
+	b = binary.BigEndian.AppendUint16(b, 0) // Arch number. Unused.
+	name := make([]byte, 66)
+	copy(name[:65], m.Name+"-"+m.Version+"-"+m.Release)
+	b = append(b, name...)
+	b = binary.BigEndian.AppendUint16(b, 1) // Linux.
+	b = binary.BigEndian.AppendUint16(b, 5) // Signature is a header structure.
+	return append(b, make([]byte, 16)...)
+}
+
+// rpmSignatureHeader returns the signature header for the given main header and payload
+// digests. If sig isn't nil, it's included as the OpenPGP signature of the main header.
+func rpmSignatureHeader(header []byte, headerAndPayloadSize int64, headerAndPayloadMD5 []byte, payloadSize int64, sig []byte) []byte {
+	sha1Sum := sha1.Sum(header)
+	sha256Sum := sha256.Sum256(header)
+	var h rpmHeader
+	h.addString(rpmSigTagSHA1, hex.EncodeToString(sha1Sum[:]))
+	h.addString(rpmSigTagSHA256, hex.EncodeToString(sha256Sum[:]))
+	h.addInt32s(rpmSigTagSize, []int32{int32(headerAndPayloadSize)})
+	h.addBin(rpmSigTagMD5, headerAndPayloadMD5)
+	h.addInt32s(rpmSigTagPayloadSize, []int32{int32(payloadSize)})
+	if sig != nil {
+		h.addBin(rpmSigTagRSA, sig)
+	}
+	return padRPMSignatureHeader(h.marshal(rpmTagHeaderSignatures))
+}
+
+// padRPMSignatureHeader pads the signature header to a multiple of 8 bytes, as the format
+// requires for the main header that follows.
+func padRPMSignatureHeader(b []byte) []byte {
+	for len(b)%8 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func buildRPM(src, dst, arch string, m *Metadata) ([]*file, error) {
+	// The payload comes after the header, but the header includes the file list and the payload
+	// digest, so write the payload to a temp file first.
+	payload, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".payload")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		payload.Close()
+		os.Remove(payload.Name())
+	}()
+
+	compressedSHA256 := sha256.New()
+	gz, err := gzip.NewWriterLevel(io.MultiWriter(payload, compressedSHA256), gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	cw := &cpioWriter{w: gz}
+	files, err := walkSource(src, m, m.InstallDir, func(f *file, r io.Reader) error {
+		return cw.writeFile(f, r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := cmp.Or(cw.close(), gz.Close()); err != nil {
+		return nil, err
+	}
+
+	header := rpmMainHeader(arch, m, files, cw.n, hex.EncodeToString(compressedSHA256.Sum(nil))).marshal(rpmTagHeaderImmutable)
+
+	if _, err := payload.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	md := md5.New()
+	md.Write(header)
+	payloadSize, err := io.Copy(md, payload)
+	if err != nil {
+		return nil, err
+	}
+	sigHeader := rpmSignatureHeader(header, int64(len(header))+payloadSize, md.Sum(nil), cw.n, nil)
+
+	if _, err := payload.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return files, archive.WithFileCreate(dst, func(out *os.File) error {
+		for _, b := range [][]byte{rpmLead(m), sigHeader, header} {
+			if _, err := out.Write(b); err != nil {
+				return err
+			}
+		}
+		_, err := io.Copy(out, payload)
+		return err
+	})
+}
+
+type rpmDependency struct {
+	name    string
+	flags   int32
+	version string
+}
+
+func rpmMainHeader(arch string, m *Metadata, files []*file, payloadSize int64, payloadDigest string) *rpmHeader {
+	var h rpmHeader
+	h.addStrings(rpmTagHeaderI18NTable, []string{"C"})
+	h.addString(rpmTagName, m.Name)
+	h.addString(rpmTagVersion, m.Version)
+	h.addString(rpmTagRelease, m.Release)
+	h.addI18NString(rpmTagSummary, m.Summary)
+	h.addI18NString(rpmTagDescription, m.Description)
+	h.addInt32s(rpmTagBuildTime, []int32{int32(m.BuildTime.Unix())})
+	h.addString(rpmTagBuildHost, "localhost")
+	h.addString(rpmTagLicense, m.License)
+	h.addI18NString(rpmTagGroup, "Development/Languages")
+	h.addString(rpmTagURL, m.URL)
+	h.addString(rpmTagOS, "linux")
+	h.addString(rpmTagArch, arch)
+	// rpm treats a package without a source RPM as a source package.
+	h.addString(rpmTagSourceRPM, m.Name+"-"+m.Version+"-"+m.Release+".src.rpm")
+	h.addInt32s(rpmTagArchiveSize, []int32{int32(payloadSize)})
+
+	evr := m.Version + "-" + m.Release
+	h.addStrings(rpmTagProvideName, []string{m.Name, m.Name + "(" + arch + ")"})
+	h.addInt32s(rpmTagProvideFlags, []int32{rpmSenseEqual, rpmSenseEqual})
+	h.addStrings(rpmTagProvideVersion, []string{evr, evr})
+
+	requires := []rpmDependency{
+		{"rpmlib(CompressedFileNames)", rpmSenseRPMLib | rpmSenseLess | rpmSenseEqual, "3.0.4-1"},
+		{"rpmlib(FileDigests)", rpmSenseRPMLib | rpmSenseLess | rpmSenseEqual, "4.6.0-1"},
+		{"rpmlib(PayloadFilesHavePrefix)", rpmSenseRPMLib | rpmSenseLess | rpmSenseEqual, "4.0-1"},
+	}
+	if postIn := m.postInstallScript("alternatives"); postIn != "" {
+		requires = append(requires, rpmDependency{"/bin/sh", rpmSenseInterp | rpmSensePost | rpmSensePreUn, ""})
+		h.addString(rpmTagPostIn, postIn)
+		h.addString(rpmTagPostInProg, "/bin/sh")
+		h.addString(rpmTagPreUn, m.preUninstallScript("alternatives", `[ "$1" -eq 0 ]`))
+		h.addString(rpmTagPreUnProg, "/bin/sh")
+	}
+	var reqNames, reqVersions []string
+	var reqFlags []int32
+	for _, r := range requires {
+		reqNames = append(reqNames, r.name)
+		reqFlags = append(reqFlags, r.flags)
+		reqVersions = append(reqVersions, r.version)
+	}
+	h.addStrings(rpmTagRequireName, reqNames)
+	h.addInt32s(rpmTagRequireFlags, reqFlags)
+	h.addStrings(rpmTagRequireVersion, reqVersions)
+
+	var (
+		size                                     int64
+		sizes, mtimes, flags, verify, devs, inos []int32
+		modes, rdevs                             []int16
+		digests, links, users, groups, langs     []string
+		dirIndexes                               []int32
+		baseNames, dirNames                      []string
+	)
+	dirIndex := make(map[string]int32)
+	for i, f := range files {
+		if f.mode.IsRegular() {
+			size += f.size
+		}
+		fileSize := f.size
+		if f.mode&fs.ModeSymlink != 0 {
+			fileSize = int64(len(f.linkname))
+		}
+		sizes = append(sizes, int32(fileSize))
+		mtimes = append(mtimes, int32(f.modTime.Unix()))
+		flags = append(flags, 0)
+		verify = append(verify, -1)
+		devs = append(devs, 1)
+		inos = append(inos, int32(i+1))
+		modes = append(modes, int16(unixMode(f.mode)))
+		rdevs = append(rdevs, 0)
+		digests = append(digests, f.sha256)
+		links = append(links, f.linkname)
+		users = append(users, "root")
+		groups = append(groups, "root")
+		langs = append(langs, "")
+
+		dir := path.Dir(f.path) + "/"
+		di, ok := dirIndex[dir]
+		if !ok {
+			di = int32(len(dirNames))
+			dirIndex[dir] = di
+			dirNames = append(dirNames, dir)
+		}
+		dirIndexes = append(dirIndexes, di)
+		baseNames = append(baseNames, path.Base(f.path))
+	}
+	h.addInt32s(rpmTagSize, []int32{int32(size)})
+	h.addInt32s(rpmTagFileSizes, sizes)
+	h.addInt16s(rpmTagFileModes, modes)
+	h.addInt16s(rpmTagFileRDevs, rdevs)
+	h.addInt32s(rpmTagFileMTimes, mtimes)
+	h.addStrings(rpmTagFileDigests, digests)
+	h.addStrings(rpmTagFileLinkTos, links)
+	h.addInt32s(rpmTagFileFlags, flags)
+	h.addStrings(rpmTagFileUserName, users)
+	h.addStrings(rpmTagFileGroupName, groups)
+	h.addInt32s(rpmTagFileVerifyFlags, verify)
+	h.addInt32s(rpmTagFileDevices, devs)
+	h.addInt32s(rpmTagFileInodes, inos)
+	h.addStrings(rpmTagFileLangs, langs)
+	h.addInt32s(rpmTagDirIndexes, dirIndexes)
+	h.addStrings(rpmTagBaseNames, baseNames)
+	h.addStrings(rpmTagDirNames, dirNames)
+	h.addInt32s(rpmTagFileDigestAlgo, []int32{rpmDigestSHA256})
+
+	h.addString(rpmTagPayloadFormat, "cpio")
+	h.addString(rpmTagPayloadCompressor, "gzip")
+	h.addString(rpmTagPayloadFlags, "9")
+	h.addStrings(rpmTagPayloadDigest, []string{payloadDigest})
+	h.addInt32s(rpmTagPayloadDigestAlgo, []int32{rpmDigestSHA256})
+	return &h
+}
+
+// unixMode converts m to a Unix st_mode value.
+func unixMode(m fs.FileMode) uint32 {
+	mode := uint32(m.Perm())
+	switch {
+	case m.IsDir():
+		mode |= 0o040000
+	case m&fs.ModeSymlink != 0:
+		mode |= 0o120000
+	default:
+		mode |= 0o100000
+	}
+	return mode
+}
+
+// cpioWriter writes a cpio archive in the "newc" format used by RPM payloads.
+type cpioWriter struct {
+	w   io.Writer
+	n   int64
+	ino int
+}
+
+func (c *cpioWriter) write(b []byte) error {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return err
+}
+
+func (c *cpioWriter) pad() error {
+	if c.n%4 == 0 {
+		return nil
+	}
+	return c.write(make([]byte, 4-c.n%4))
+}
+
+func (c *cpioWriter) writeHeader(name string, mode uint32, mtime int64, size int64) error {
+	c.ino++
+	nlink := 1
+	if mode&0o170000 == 0o040000 {
+		nlink = 2
+	}
+	h := fmt.Sprintf("070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+		c.ino, mode, 0, 0, nlink, mtime, size, 0, 0, 0, 0, len(name)+1, 0)
+	if err := c.write([]byte(h + name + "\x00")); err != nil {
+		return err
+	}
+	return c.pad()
+}
+
+func (c *cpioWriter) writeFile(f *file, r io.Reader) error {
+	var content io.Reader = r
+	size := f.size
+	switch {
+	case f.mode.IsDir():
+		size = 0
+	case f.mode&fs.ModeSymlink != 0:
+		content = strings.NewReader(f.linkname)
+		size = int64(len(f.linkname))
+	}
+	if err := c.writeHeader("."+f.path, unixMode(f.mode), f.modTime.Unix(), size); err != nil {
+		return err
+	}
+	if content != nil {
+		n, err := io.Copy(c.w, content)
+		c.n += n
+		if err != nil {
+			return err
+		}
+		if n != size {
+			return fmt.Errorf("%q: wrote %v bytes, expected %v", f.path, n, size)
+		}
+	}
+	return c.pad()
+}
+
+func (c *cpioWriter) close() error {
+	return c.writeHeader("TRAILER!!!", 0, 0, 0)
+}
+
+// rpmParts is an RPM package split into its parts.
+type rpmParts struct {
+	lead      []byte
+	signature *rpmHeader
+	header    []byte
+	payload   []byte
+}
+
+func readRPM(data []byte) (*rpmParts, error) {
+	if len(data) < rpmLeadSize || !bytes.Equal(data[:4], []byte{0xed, 0xab, 0xee, 0xdb}) {
+		return nil, errors.New("not an RPM package")
+	}
+	p := &rpmParts{lead: data[:rpmLeadSize]}
+	rest := data[rpmLeadSize:]
+	sig, n, err := parseRPMHeader(rest)
+	if err != nil {
+		return nil, fmt.Errorf("signature header: %v", err)
+	}
+	p.signature = sig
+	if n%8 != 0 {
+		n += 8 - n%8
+	}
+	if n > len(rest) {
+		return nil, errors.New("truncated RPM signature header")
+	}
+	rest = rest[n:]
+	if _, n, err = parseRPMHeader(rest); err != nil {
+		return nil, fmt.Errorf("main header: %v", err)
+	}
+	p.header = rest[:n]
+	p.payload = rest[n:]
+	return p, nil
+}
+
+// embedRPMSignature writes the package with sig added to the signature header as the OpenPGP
+// signature of the main header, replacing any existing one.
+func embedRPMSignature(p *rpmParts, sig []byte, dst string) error {
+	var h rpmHeader
+	for _, e := range p.signature.entries {
+		if e.tag != rpmSigTagRSA {
+			h.entries = append(h.entries, e)
+		}
+	}
+	h.addBin(rpmSigTagRSA, sig)
+	sigHeader := padRPMSignatureHeader(h.marshal(rpmTagHeaderSignatures))
+	return archive.WithFileCreate(dst, func(out *os.File) error {
+		for _, b := range [][]byte{p.lead, sigHeader, p.header, p.payload} {
+			if _, err := out.Write(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
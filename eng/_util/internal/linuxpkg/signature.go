@@ -0,0 +1,111 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linuxpkg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SignedContent returns the part of the package at p that an embedded signature covers. Signing
+// this content with OpenPGP produces a detached signature that EmbedSignature can embed.
+//
+// For RPM, this is the main header, which includes the digests of the files and of the payload.
+// For DEB, this is the concatenation of the debian-binary, control, and data members, as signed
+// by debsigs.
+func SignedContent(format Format, p string) ([]byte, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case RPM:
+		parts, err := readRPM(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %q: %v", p, err)
+		}
+		return parts.header, nil
+	case DEB:
+		members, err := readAR(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %q: %v", p, err)
+		}
+		return debSignedContent(members)
+	}
+	return nil, fmt.Errorf("unsupported package format: %q", format)
+}
+
+// EmbedSignature writes a copy of the package at p to dst with sig embedded into it. sig is an
+// OpenPGP signature of the content returned by SignedContent. It may be ASCII armored: RPM
+// requires a binary signature, so armor is removed for RPM packages.
+func EmbedSignature(format Format, p string, sig []byte, dst string) error {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case RPM:
+		parts, err := readRPM(data)
+		if err != nil {
+			return fmt.Errorf("failed to read %q: %v", p, err)
+		}
+		if bytes.HasPrefix(bytes.TrimSpace(sig), []byte("-----BEGIN ")) {
+			if sig, err = dearmor(sig); err != nil {
+				return err
+			}
+		}
+		return embedRPMSignature(parts, sig, dst)
+	case DEB:
+		members, err := readAR(data)
+		if err != nil {
+			return fmt.Errorf("failed to read %q: %v", p, err)
+		}
+		return embedDEBSignature(members, sig, dst)
+	}
+	return fmt.Errorf("unsupported package format: %q", format)
+}
+
+// dearmor decodes an ASCII armored OpenPGP message (RFC 4880 section 6.2). The checksum isn't
+// verified: the signature itself is verified when the package is installed.
+func dearmor(armored []byte) ([]byte, error) {
+	s := bufio.NewScanner(bytes.NewReader(armored))
+	var inBody, inHeaders bool
+	var b64 strings.Builder
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case strings.HasPrefix(line, "-----BEGIN "):
+			inBody, inHeaders = true, true
+		case strings.HasPrefix(line, "-----END "):
+			data, err := base64.StdEncoding.DecodeString(b64.String())
+			if err != nil {
+				return nil, fmt.Errorf("invalid armored signature: %v", err)
+			}
+			return data, nil
+		case !inBody:
+		case inHeaders:
+			// Armor headers end with an empty line. Some tools omit the headers and the line.
+			if line == "" {
+				inHeaders = false
+			} else if !strings.Contains(line, ": ") {
+				inHeaders = false
+				b64.WriteString(line)
+			}
+		case strings.HasPrefix(line, "="):
+			// Checksum line.
+		default:
+			b64.WriteString(line)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("invalid armored signature: no end line")
+}
@@ -0,0 +1,302 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package macpkg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+)
+
+// The Bom ("bill of materials") lists every path in the Payload with its metadata. Installer
+// uses it to record the installed files in the receipt database ("pkgutil --files").
+//
+// The format is undocumented. This implementation follows the reverse-engineered description
+// used by bomutils (https://github.com/hogliux/bomutils): a "BOMStore" of numbered blocks, and
+// named variables pointing at B+ trees of blocks. All integers are big-endian.
+const (
+	bomHeaderSize = 512
+	// bomPathsBlockSize is the block size of the "Paths" and "HLIndex" trees.
+	bomPathsBlockSize = 4096
+	// bomSmallBlockSize is the block size of the "VIndex" and "Size64" trees.
+	bomSmallBlockSize = 128
+	// bomPathsPerLeaf is the number of paths in each leaf of the "Paths" tree. A leaf with this
+	// many 8-byte entries and its 12-byte header fits in a block.
+	bomPathsPerLeaf = 256
+)
+
+const (
+	bomTypeFile = 1
+	bomTypeDir  = 2
+	bomTypeLink = 3
+)
+
+// bomStore is the block storage of a Bom. Block 0 is always null.
+type bomStore struct {
+	blocks [][]byte
+	vars   []bomVar
+}
+
+type bomVar struct {
+	name  string
+	block uint32
+}
+
+// add stores b as a new block and returns its index.
+func (s *bomStore) add(b []byte) uint32 {
+	if len(s.blocks) == 0 {
+		s.blocks = append(s.blocks, nil)
+	}
+	s.blocks = append(s.blocks, b)
+	return uint32(len(s.blocks) - 1)
+}
+
+func (s *bomStore) addVar(name string, block uint32) {
+	s.vars = append(s.vars, bomVar{name, block})
+}
+
+// addTree adds a tree with the given root node block and returns the tree's block.
+func (s *bomStore) addTree(child uint32, blockSize, pathCount int) uint32 {
+	b := []byte("tree")
+	b = binary.BigEndian.AppendUint32(b, 1)
+	b = binary.BigEndian.AppendUint32(b, child)
+	b = binary.BigEndian.AppendUint32(b, uint32(blockSize))
+	b = binary.BigEndian.AppendUint32(b, uint32(pathCount))
+	b = append(b, 0)
+	return s.add(b)
+}
+
+// addEmptyTree adds a tree with no entries and returns the tree's block.
+func (s *bomStore) addEmptyTree(blockSize int) uint32 {
+	return s.addTree(s.add(bomPathsNode(true, nil, 0, 0, blockSize)), blockSize, 0)
+}
+
+// bomPathsNode returns a tree node. Each entry is a pair of block indexes: for a leaf, the
+// value and the key; otherwise, the child node and the last key in the child. forward and
+// backward link leaves in order. The node is padded to blockSize.
+func bomPathsNode(leaf bool, entries [][2]uint32, forward, backward uint32, blockSize int) []byte {
+	b := make([]byte, 0, blockSize)
+	var isLeaf uint16
+	if leaf {
+		isLeaf = 1
+	}
+	b = binary.BigEndian.AppendUint16(b, isLeaf)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(entries)))
+	b = binary.BigEndian.AppendUint32(b, forward)
+	b = binary.BigEndian.AppendUint32(b, backward)
+	for _, e := range entries {
+		b = binary.BigEndian.AppendUint32(b, e[0])
+		b = binary.BigEndian.AppendUint32(b, e[1])
+	}
+	if len(b) < blockSize {
+		b = b[:blockSize]
+	}
+	return b
+}
+
+// writeBom writes a Bom listing entries to w. The entries must be in Payload order, which lists
+// each directory before its contents, and the first entry must be ".".
+func writeBom(w io.Writer, entries []*entry) error {
+	if len(entries) == 0 || entries[0].path != "." {
+		return fmt.Errorf("bom must start with the root directory")
+	}
+	if len(entries) > bomPathsPerLeaf*(bomPathsBlockSize-12)/8 {
+		return fmt.Errorf("too many paths for bom: %v", len(entries))
+	}
+
+	var s bomStore
+	ids := make(map[string]uint32, len(entries))
+	pathEntries := make([][2]uint32, 0, len(entries))
+	for i, e := range entries {
+		id := uint32(i + 1)
+		ids[e.path] = id
+		var parent uint32
+		if e.path != "." {
+			var ok bool
+			if parent, ok = ids[path.Dir(e.path)]; !ok {
+				return fmt.Errorf("bom entry %q is listed before its parent directory", e.path)
+			}
+		}
+
+		info1 := binary.BigEndian.AppendUint32(nil, id)
+		info1 = binary.BigEndian.AppendUint32(info1, s.add(bomPathInfo(e)))
+		key := binary.BigEndian.AppendUint32(nil, parent)
+		key = append(key, path.Base(e.path)...)
+		key = append(key, 0)
+		pathEntries = append(pathEntries, [2]uint32{s.add(info1), s.add(key)})
+	}
+
+	// Split the paths into leaves. If there's more than one, add a root node that points at
+	// each leaf.
+	nLeaves := (len(pathEntries) + bomPathsPerLeaf - 1) / bomPathsPerLeaf
+	leaves := make([][2]uint32, nLeaves)
+	leafBlocks := make([]uint32, nLeaves)
+	for i := range leafBlocks {
+		// Reserve the blocks first so each leaf can link to the next.
+		leafBlocks[i] = s.add(nil)
+	}
+	for i := range leaves {
+		start := i * bomPathsPerLeaf
+		end := min(start+bomPathsPerLeaf, len(pathEntries))
+		var forward, backward uint32
+		if i+1 < len(leaves) {
+			forward = leafBlocks[i+1]
+		}
+		if i > 0 {
+			backward = leafBlocks[i-1]
+		}
+		s.blocks[leafBlocks[i]] = bomPathsNode(true, pathEntries[start:end], forward, backward, bomPathsBlockSize)
+		leaves[i] = [2]uint32{leafBlocks[i], pathEntries[end-1][1]}
+	}
+	root := leafBlocks[0]
+	if len(leaves) > 1 {
+		root = s.add(bomPathsNode(false, leaves, 0, 0, bomPathsBlockSize))
+	}
+	s.addVar("BomInfo", s.add(bomInfo(len(entries))))
+	s.addVar("Paths", s.addTree(root, bomPathsBlockSize, len(entries)))
+	s.addVar("HLIndex", s.addEmptyTree(bomPathsBlockSize))
+	vindex := binary.BigEndian.AppendUint32(nil, 1)
+	vindex = binary.BigEndian.AppendUint32(vindex, s.addEmptyTree(bomSmallBlockSize))
+	vindex = binary.BigEndian.AppendUint32(vindex, 0)
+	vindex = append(vindex, 0)
+	s.addVar("VIndex", s.add(vindex))
+	s.addVar("Size64", s.addEmptyTree(bomSmallBlockSize))
+
+	return s.write(w)
+}
+
+// bomInfo returns the "BomInfo" variable block.
+func bomInfo(paths int) []byte {
+	b := binary.BigEndian.AppendUint32(nil, 1)
+	b = binary.BigEndian.AppendUint32(b, uint32(paths))
+	// One info entry, all zero.
+	b = binary.BigEndian.AppendUint32(b, 1)
+	return append(b, make([]byte, 16)...)
+}
+
+// bomPathInfo returns the block describing e.
+func bomPathInfo(e *entry) []byte {
+	var typ byte
+	var size int64
+	switch {
+	case e.mode.IsDir():
+		typ = bomTypeDir
+	case e.mode&fs.ModeSymlink != 0:
+		typ = bomTypeLink
+		size = int64(len(e.linkname))
+	default:
+		typ = bomTypeFile
+		size = e.size
+	}
+	b := []byte{typ, 1}
+	// The architecture field. bomutils always writes 3.
+	b = binary.BigEndian.AppendUint16(b, 3)
+	b = binary.BigEndian.AppendUint16(b, uint16(unixMode(e.mode)))
+	// Owned by root:wheel.
+	b = binary.BigEndian.AppendUint32(b, 0)
+	b = binary.BigEndian.AppendUint32(b, 0)
+	b = binary.BigEndian.AppendUint32(b, uint32(e.modTime.Unix()))
+	b = binary.BigEndian.AppendUint32(b, uint32(size))
+	b = append(b, 1)
+	b = binary.BigEndian.AppendUint32(b, e.cksum)
+	if typ == bomTypeLink {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(e.linkname)+1))
+		b = append(b, e.linkname...)
+		b = append(b, 0)
+	} else {
+		b = binary.BigEndian.AppendUint32(b, 0)
+	}
+	return b
+}
+
+func (s *bomStore) write(w io.Writer) error {
+	// The header is followed by the blocks, then the block index, then the variables.
+	var data []byte
+	addresses := make([][2]uint32, len(s.blocks))
+	for i, b := range s.blocks {
+		if i == 0 {
+			continue
+		}
+		addresses[i] = [2]uint32{uint32(bomHeaderSize + len(data)), uint32(len(b))}
+		data = append(data, b...)
+	}
+
+	indexOffset := bomHeaderSize + len(data)
+	index := binary.BigEndian.AppendUint32(nil, uint32(len(addresses)))
+	for _, a := range addresses {
+		index = binary.BigEndian.AppendUint32(index, a[0])
+		index = binary.BigEndian.AppendUint32(index, a[1])
+	}
+	// An empty free list, with room for two entries like bomutils.
+	index = binary.BigEndian.AppendUint32(index, 2)
+	index = append(index, make([]byte, 16)...)
+
+	varsOffset := indexOffset + len(index)
+	vars := binary.BigEndian.AppendUint32(nil, uint32(len(s.vars)))
+	for _, v := range s.vars {
+		vars = binary.BigEndian.AppendUint32(vars, v.block)
+		vars = append(vars, byte(len(v.name)))
+		vars = append(vars, v.name...)
+	}
+
+	header := make([]byte, 0, bomHeaderSize)
+	header = append(header, "BOMStore"...)
+	header = binary.BigEndian.AppendUint32(header, 1)
+	header = binary.BigEndian.AppendUint32(header, uint32(len(s.blocks)-1))
+	header = binary.BigEndian.AppendUint32(header, uint32(indexOffset))
+	header = binary.BigEndian.AppendUint32(header, uint32(len(index)))
+	header = binary.BigEndian.AppendUint32(header, uint32(varsOffset))
+	header = binary.BigEndian.AppendUint32(header, uint32(len(vars)))
+	header = header[:bomHeaderSize]
+
+	for _, b := range [][]byte{header, data, index, vars} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cksumTable is the CRC table for the POSIX cksum algorithm, which the Bom uses for file
+// checksums. It's the CRC-32 polynomial, but not bit-reflected like hash/crc32.
+var cksumTable = func() (t [256]uint32) {
+	for i := range t {
+		c := uint32(i) << 24
+		for range 8 {
+			if c&0x80000000 != 0 {
+				c = c<<1 ^ 0x04c11db7
+			} else {
+				c <<= 1
+			}
+		}
+		t[i] = c
+	}
+	return t
+}()
+
+// cksum is an io.Writer that computes the POSIX cksum of the data written to it.
+type cksum struct {
+	crc uint32
+	n   int64
+}
+
+func (c *cksum) Write(p []byte) (int, error) {
+	for _, b := range p {
+		c.crc = c.crc<<8 ^ cksumTable[byte(c.crc>>24)^b]
+	}
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// sum returns the checksum of the data written so far.
+func (c *cksum) sum() uint32 {
+	crc := c.crc
+	for n := c.n; n != 0; n >>= 8 {
+		crc = crc<<8 ^ cksumTable[byte(crc>>24)^byte(n)]
+	}
+	return ^crc
+}
@@ -0,0 +1,86 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package macpkg
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// cpioWriter writes a cpio archive in the "odc" (portable ASCII) format that macOS Installer
+// expects in a component package Payload.
+type cpioWriter struct {
+	w   io.Writer
+	ino int
+}
+
+func (c *cpioWriter) writeHeader(name string, mode uint32, mtime int64, size int64) error {
+	c.ino++
+	nlink := 1
+	if mode&0o170000 == 0o040000 {
+		nlink = 2
+	}
+	_, err := fmt.Fprintf(c.w, "070707%06o%06o%06o%06o%06o%06o%06o%011o%06o%011o%s\x00",
+		0, c.ino, mode, 0, 0, nlink, 0, mtime, len(name)+1, size, name)
+	return err
+}
+
+// writeFile writes an entry for e. For regular files, the content is read from r. For symlinks,
+// the content is the link target.
+func (c *cpioWriter) writeFile(e *entry, r io.Reader) error {
+	var content io.Reader
+	var size int64
+	switch {
+	case e.mode.IsDir():
+	case e.mode&fs.ModeSymlink != 0:
+		content = strings.NewReader(e.linkname)
+		size = int64(len(e.linkname))
+	default:
+		content = r
+		size = e.size
+	}
+	// Payload paths start with "./".
+	name := e.path
+	if name != "." {
+		name = "./" + name
+	}
+	if err := c.writeHeader(name, unixMode(e.mode), e.modTime.Unix(), size); err != nil {
+		return err
+	}
+	if content == nil {
+		return nil
+	}
+	n, err := io.Copy(c.w, content)
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("%q: wrote %v bytes, expected %v", e.path, n, size)
+	}
+	return nil
+}
+
+// close writes the trailer entry. It doesn't close the underlying writer.
+func (c *cpioWriter) close() error {
+	_, err := fmt.Fprintf(c.w, "070707%06o%06o%06o%06o%06o%06o%06o%011o%06o%011o%s\x00",
+		0, 0, 0, 0, 0, 1, 0, 0, len("TRAILER!!!")+1, 0, "TRAILER!!!")
+	return err
+}
+
+// unixMode converts m to a Unix st_mode value.
+func unixMode(m fs.FileMode) uint32 {
+	mode := uint32(m.Perm())
+	switch {
+	case m.IsDir():
+		mode |= 0o040000
+	case m&fs.ModeSymlink != 0:
+		mode |= 0o120000
+	default:
+		mode |= 0o100000
+	}
+	return mode
+}
@@ -0,0 +1,371 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package macpkg creates a macOS installer package (.pkg) from a macOS Go distribution tar.gz.
+// The package is a "flat" product archive: a xar archive holding a Distribution file and one
+// component package with a Payload (gzipped cpio) and a Bom. It is implemented in pure Go so the
+// package can be created on Linux, without pkgbuild or productbuild.
+package macpkg
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/microsoft/go/_util/internal/archive"
+)
+
+// Options describes the package to create.
+type Options struct {
+	// Version is the upstream Go version without the "go" prefix, e.g. "1.23.1".
+	Version string
+	// Release is the Microsoft revision, e.g. "1".
+	Release string
+	// GOARCH is the Go architecture of the distribution.
+	GOARCH string
+
+	// Identifier is the package identifier recorded in the receipt database.
+	Identifier string
+	// Title is shown by Installer.
+	Title string
+	// InstallDir is the absolute path where the "go" directory of the archive is installed.
+	InstallDir string
+	// PathsFile, if not empty, is the name of a file created in /etc/paths.d that adds the Go bin
+	// directory to PATH in new shells.
+	PathsFile string
+}
+
+// DefaultInstallDir is the default value of Options.InstallDir. It's different from the
+// upstream installer's /usr/local/go so both can be installed.
+const DefaultInstallDir = "/usr/local/microsoft-go"
+
+// NewOptions returns default options for a package created from the Microsoft Go archive at p,
+// such as "go1.23.1-1.darwin-arm64.tar.gz", using the version and architecture in the filename.
+func NewOptions(p string) (*Options, error) {
+	n, err := archive.ParseName(p)
+	if err != nil {
+		return nil, err
+	}
+	if n.GOOS != "darwin" || n.Format != archive.TarGz {
+		return nil, fmt.Errorf("not a macOS tar.gz archive: %q", filepath.Base(p))
+	}
+	return &Options{
+		Version:    n.Version,
+		Release:    n.Release,
+		GOARCH:     n.GOARCH,
+		Identifier: "com.microsoft.go",
+		Title:      "Microsoft build of Go " + n.Version + "-" + n.Release,
+		InstallDir: DefaultInstallDir,
+		PathsFile:  "microsoft-go",
+	}, nil
+}
+
+// Filename returns the conventional filename of the package. It matches the archive naming
+// pattern so the package is found by the same tools.
+func (o *Options) Filename() string {
+	return "go" + o.Version + "-" + o.Release + ".darwin-" + o.GOARCH + ".pkg"
+}
+
+// hostArchitecture returns the Distribution hostArchitectures value for the package.
+func (o *Options) hostArchitecture() (string, error) {
+	switch o.GOARCH {
+	case "amd64":
+		return "x86_64", nil
+	case "arm64":
+		return "arm64", nil
+	}
+	return "", fmt.Errorf("unsupported architecture for macOS package: %q", o.GOARCH)
+}
+
+// entry is a file, directory, or symlink in the Payload. path is relative to the root of the
+// volume, and the root itself is ".".
+type entry struct {
+	path     string
+	mode     fs.FileMode
+	size     int64
+	modTime  time.Time
+	linkname string
+	// cksum is the POSIX cksum of a regular file's content, for the Bom.
+	cksum uint32
+}
+
+// Build creates a package at dst from the macOS Go distribution tar.gz at src. The binaries in
+// src should already be signed: Installer doesn't sign them, and notarization rejects a package
+// that contains unsigned binaries.
+func Build(src, dst string, o *Options) error {
+	hostArch, err := o.hostArchitecture()
+	if err != nil {
+		return err
+	}
+	if !path.IsAbs(o.InstallDir) || path.Clean(o.InstallDir) == "/" {
+		return fmt.Errorf("install dir must be an absolute path below the root: %q", o.InstallDir)
+	}
+	if strings.Contains(o.PathsFile, "/") {
+		return fmt.Errorf("paths file must be a file name: %q", o.PathsFile)
+	}
+	installDir := strings.TrimPrefix(path.Clean(o.InstallDir), "/")
+
+	payloadPath := dst + ".payload"
+	defer os.Remove(payloadPath)
+	var entries []*entry
+	if err := archive.WithFileCreate(payloadPath, func(f *os.File) error {
+		gw := gzip.NewWriter(f)
+		entries, err = writePayload(gw, src, installDir, o)
+		return cmp.Or(err, gw.Close())
+	}); err != nil {
+		return fmt.Errorf("failed to write payload: %v", err)
+	}
+
+	var bom bytes.Buffer
+	if err := writeBom(&bom, entries); err != nil {
+		return err
+	}
+
+	var installKBytes int64
+	for _, e := range entries {
+		installKBytes += (e.size + 1023) / 1024
+	}
+	packageInfo, err := marshalXML(&pkgInfo{
+		FormatVersion:        2,
+		Identifier:           o.Identifier,
+		Version:              o.Version + "-" + o.Release,
+		InstallLocation:      "/",
+		Auth:                 "root",
+		OverwritePermissions: true,
+		Relocatable:          false,
+		Payload:              pkgInfoPayload{NumberOfFiles: len(entries), InstallKBytes: installKBytes},
+	})
+	if err != nil {
+		return err
+	}
+	distribution, err := o.distribution(hostArch, installKBytes)
+	if err != nil {
+		return err
+	}
+
+	x := newXARWriter()
+	x.addBytes(nil, "Distribution", distribution)
+	component := x.addDir(nil, componentName)
+	x.addBytes(component, "PackageInfo", packageInfo)
+	x.addBytes(component, "Bom", bom.Bytes())
+	if err := x.addFile(component, "Payload", payloadPath); err != nil {
+		return err
+	}
+	created := time.Unix(0, 0)
+	for _, e := range entries {
+		if e.modTime.After(created) {
+			created = e.modTime
+		}
+	}
+	return archive.WithFileCreate(dst, func(f *os.File) error {
+		return x.write(f, created)
+	})
+}
+
+// componentName is the name of the component package inside the product archive.
+const componentName = "go.pkg"
+
+// writePayload writes the Payload cpio archive to w and returns its entries in order.
+func writePayload(w io.Writer, src, installDir string, o *Options) ([]*entry, error) {
+	a, err := archive.New(src)
+	if err != nil {
+		return nil, err
+	}
+	c := &cpioWriter{w: w}
+	var entries []*entry
+	seen := make(map[string]bool)
+	var newest time.Time
+
+	add := func(e *entry, r io.Reader) error {
+		var sum cksum
+		if e.mode.IsRegular() {
+			r = io.TeeReader(r, &sum)
+		}
+		if err := c.writeFile(e, r); err != nil {
+			return err
+		}
+		e.cksum = sum.sum()
+		seen[e.path] = true
+		entries = append(entries, e)
+		return nil
+	}
+	// addParents adds the directories containing p that aren't in the payload yet. Directories
+	// missing from the archive use the timestamp of their first entry.
+	var addParents func(p string, modTime time.Time) error
+	addParents = func(p string, modTime time.Time) error {
+		if p == "." {
+			return nil
+		}
+		dir := path.Dir(p)
+		if seen[dir] {
+			return nil
+		}
+		if err := addParents(dir, modTime); err != nil {
+			return err
+		}
+		return add(&entry{path: dir, mode: fs.ModeDir | 0o755, modTime: modTime}, nil)
+	}
+
+	if err := a.Walk(func(ae *archive.Entry, r io.Reader) error {
+		rel, ok := strings.CutPrefix(ae.Name, "go/")
+		if !ok {
+			if strings.TrimSuffix(ae.Name, "/") == "go" {
+				rel = ""
+			} else {
+				return fmt.Errorf("archive entry outside of the 'go' directory: %q", ae.Name)
+			}
+		}
+		p := path.Join(installDir, rel)
+		if ae.ModTime.After(newest) {
+			newest = ae.ModTime
+		}
+		if err := addParents(p, ae.ModTime); err != nil {
+			return err
+		}
+		if seen[p] {
+			if ae.IsDir() {
+				return nil
+			}
+			return fmt.Errorf("duplicate archive entry: %q", ae.Name)
+		}
+		e := &entry{
+			path:     p,
+			mode:     ae.Mode,
+			size:     ae.Size,
+			modTime:  ae.ModTime,
+			linkname: ae.Linkname,
+		}
+		switch {
+		case ae.IsDir(), ae.Mode&fs.ModeSymlink != 0:
+			e.size = 0
+		case !ae.IsRegular():
+			return fmt.Errorf("unsupported archive entry type: %q", ae.Name)
+		}
+		return add(e, r)
+	}); err != nil {
+		return nil, err
+	}
+
+	if o.PathsFile != "" {
+		content := path.Join(o.InstallDir, "bin") + "\n"
+		p := path.Join("etc/paths.d", o.PathsFile)
+		if err := addParents(p, newest); err != nil {
+			return nil, err
+		}
+		e := &entry{path: p, mode: 0o644, size: int64(len(content)), modTime: newest}
+		if err := add(e, strings.NewReader(content)); err != nil {
+			return nil, err
+		}
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("archive is empty: %q", src)
+	}
+	return entries, c.close()
+}
+
+type pkgInfo struct {
+	XMLName              xml.Name       `xml:"pkg-info"`
+	FormatVersion        int            `xml:"format-version,attr"`
+	Identifier           string         `xml:"identifier,attr"`
+	Version              string         `xml:"version,attr"`
+	InstallLocation      string         `xml:"install-location,attr"`
+	Auth                 string         `xml:"auth,attr"`
+	OverwritePermissions bool           `xml:"overwrite-permissions,attr"`
+	Relocatable          bool           `xml:"relocatable,attr"`
+	Payload              pkgInfoPayload `xml:"payload"`
+}
+
+type pkgInfoPayload struct {
+	NumberOfFiles int   `xml:"numberOfFiles,attr"`
+	InstallKBytes int64 `xml:"installKBytes,attr"`
+}
+
+type distribution struct {
+	XMLName        xml.Name           `xml:"installer-gui-script"`
+	MinSpecVersion int                `xml:"minSpecVersion,attr"`
+	Title          string             `xml:"title"`
+	Options        distOptions        `xml:"options"`
+	Domains        distDomains        `xml:"domains"`
+	ChoicesOutline distChoicesOutline `xml:"choices-outline"`
+	Choices        []distChoice       `xml:"choice"`
+	PkgRefs        []distPkgRef       `xml:"pkg-ref"`
+}
+
+type distOptions struct {
+	Customize         string `xml:"customize,attr"`
+	RequireScripts    bool   `xml:"require-scripts,attr"`
+	HostArchitectures string `xml:"hostArchitectures,attr"`
+}
+
+type distDomains struct {
+	EnableLocalSystem bool `xml:"enable_localSystem,attr"`
+}
+
+type distChoicesOutline struct {
+	Lines []distLine `xml:"line"`
+}
+
+type distLine struct {
+	Choice string     `xml:"choice,attr"`
+	Lines  []distLine `xml:"line"`
+}
+
+type distChoice struct {
+	ID      string       `xml:"id,attr"`
+	Visible *bool        `xml:"visible,attr"`
+	Title   string       `xml:"title,attr,omitempty"`
+	PkgRefs []distPkgRef `xml:"pkg-ref"`
+}
+
+type distPkgRef struct {
+	ID            string `xml:"id,attr"`
+	Version       string `xml:"version,attr,omitempty"`
+	InstallKBytes int64  `xml:"installKBytes,attr,omitempty"`
+	Path          string `xml:",chardata"`
+}
+
+// distribution returns the Distribution file, which describes the product archive.
+func (o *Options) distribution(hostArch string, installKBytes int64) ([]byte, error) {
+	visible := false
+	return marshalXML(&distribution{
+		MinSpecVersion: 2,
+		Title:          o.Title,
+		Options: distOptions{
+			Customize:         "never",
+			RequireScripts:    false,
+			HostArchitectures: hostArch,
+		},
+		Domains: distDomains{EnableLocalSystem: true},
+		ChoicesOutline: distChoicesOutline{
+			Lines: []distLine{{Choice: "default", Lines: []distLine{{Choice: o.Identifier}}}},
+		},
+		Choices: []distChoice{
+			{ID: "default", Title: o.Title},
+			{ID: o.Identifier, Visible: &visible, PkgRefs: []distPkgRef{{ID: o.Identifier}}},
+		},
+		PkgRefs: []distPkgRef{{
+			ID:            o.Identifier,
+			Version:       o.Version + "-" + o.Release,
+			InstallKBytes: installKBytes,
+			Path:          "#" + componentName,
+		}},
+	})
+}
+
+func marshalXML(v any) ([]byte, error) {
+	b, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(b, '\n')...), nil
+}
@@ -0,0 +1,277 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package macpkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/microsoft/go/_util/internal/archive"
+)
+
+func TestBuild(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "go1.23.1-2.darwin-arm64.tar.gz")
+	modTime := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	// Enough files to need more than one leaf in the Bom.
+	var names []string
+	for i := range 300 {
+		names = append(names, fmt.Sprintf("go/src/p/f%03d.go", i))
+	}
+	if err := archive.WithTarGzCreate(src, 1, func(tw *tar.Writer) error {
+		add := func(h *tar.Header, content string) error {
+			h.ModTime = modTime
+			h.Size = int64(len(content))
+			if err := tw.WriteHeader(h); err != nil {
+				return err
+			}
+			_, err := tw.Write([]byte(content))
+			return err
+		}
+		if err := add(&tar.Header{Name: "go/bin/go", Mode: 0o755, Typeflag: tar.TypeReg}, "hello\n"); err != nil {
+			return err
+		}
+		if err := add(&tar.Header{Name: "go/pkg/tool/", Mode: 0o755, Typeflag: tar.TypeDir}, ""); err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err := add(&tar.Header{Name: name, Mode: 0o644, Typeflag: tar.TypeReg}, name); err != nil {
+				return err
+			}
+		}
+		h := &tar.Header{Name: "go/bin/link", Linkname: "go", Mode: 0o777, Typeflag: tar.TypeSymlink, ModTime: modTime}
+		return tw.WriteHeader(h)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	o, err := NewOptions(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(dir, o.Filename())
+	if err := Build(src, dst, o); err != nil {
+		t.Fatal(err)
+	}
+	files := readXAR(t, dst)
+	if _, ok := files["Distribution"]; !ok {
+		t.Error("no Distribution file")
+	}
+	var info pkgInfo
+	if err := xml.Unmarshal(files["go.pkg/PackageInfo"], &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != "1.23.1-2" {
+		t.Errorf("PackageInfo version = %q, want 1.23.1-2", info.Version)
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(files["go.pkg/Payload"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := readCPIO(t, gz)
+	bom := readBom(t, files["go.pkg/Bom"])
+
+	want := []string{
+		".",
+		"./usr",
+		"./usr/local",
+		"./usr/local/microsoft-go",
+		"./usr/local/microsoft-go/bin",
+		"./usr/local/microsoft-go/bin/go",
+		"./usr/local/microsoft-go/pkg",
+		"./usr/local/microsoft-go/pkg/tool",
+		"./usr/local/microsoft-go/src",
+		"./usr/local/microsoft-go/src/p",
+	}
+	for _, name := range names {
+		want = append(want, "./usr/local/microsoft-go/"+strings.TrimPrefix(name, "go/"))
+	}
+	want = append(want,
+		"./usr/local/microsoft-go/bin/link",
+		"./etc",
+		"./etc/paths.d",
+		"./etc/paths.d/microsoft-go",
+	)
+	if !slices.Equal(payload.names, want) {
+		t.Errorf("payload names = %v, want %v", payload.names, want)
+	}
+	if !slices.Equal(bom.names, want) {
+		t.Errorf("bom names = %v, want %v", bom.names, want)
+	}
+	if got := payload.content["./usr/local/microsoft-go/bin/go"]; got != "hello\n" {
+		t.Errorf("go content = %q", got)
+	}
+	if got := payload.content["./usr/local/microsoft-go/bin/link"]; got != "go" {
+		t.Errorf("link target = %q", got)
+	}
+	if got := payload.content["./etc/paths.d/microsoft-go"]; got != "/usr/local/microsoft-go/bin\n" {
+		t.Errorf("paths.d content = %q", got)
+	}
+	// The POSIX cksum of "hello\n".
+	if got := bom.cksums["./usr/local/microsoft-go/bin/go"]; got != 3015617425 {
+		t.Errorf("go cksum = %v, want 3015617425", got)
+	}
+}
+
+// readXAR returns the content of each file in the xar archive at p, checking the checksums.
+func readXAR(t *testing.T, p string) map[string][]byte {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binary.BigEndian.Uint32(b) != xarMagic {
+		t.Fatal("bad xar magic")
+	}
+	headerSize := int(binary.BigEndian.Uint16(b[4:]))
+	compressedLen := int(binary.BigEndian.Uint64(b[8:]))
+	compressed := b[headerSize : headerSize+compressedLen]
+	heap := b[headerSize+compressedLen:]
+	if sum := sha1.Sum(compressed); !bytes.Equal(sum[:], heap[:sha1.Size]) {
+		t.Fatal("bad TOC checksum")
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var toc xarTOC
+	if err := xml.NewDecoder(zr).Decode(&toc); err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string][]byte)
+	var walk func(prefix string, fs []*xarFile)
+	walk = func(prefix string, fs []*xarFile) {
+		for _, f := range fs {
+			name := prefix + f.Name
+			if f.Type == "directory" {
+				walk(name+"/", f.Files)
+				continue
+			}
+			data := heap[f.Data.Offset : f.Data.Offset+f.Data.Length]
+			sum := sha1.Sum(data)
+			if hex.EncodeToString(sum[:]) != f.Data.ArchivedChecksum.Value {
+				t.Errorf("%v: bad checksum", name)
+			}
+			files[name] = data
+		}
+	}
+	walk("", toc.TOC.Files)
+	return files
+}
+
+type cpioContent struct {
+	names   []string
+	content map[string]string
+}
+
+func readCPIO(t *testing.T, r io.Reader) *cpioContent {
+	c := &cpioContent{content: make(map[string]string)}
+	for {
+		h := make([]byte, 76)
+		if _, err := io.ReadFull(r, h); err != nil {
+			t.Fatal(err)
+		}
+		if string(h[:6]) != "070707" {
+			t.Fatalf("bad cpio magic %q", h[:6])
+		}
+		field := func(start, n int) int64 {
+			v, err := strconv.ParseInt(string(h[start:start+n]), 8, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return v
+		}
+		nameSize, size := field(59, 6), field(65, 11)
+		rest := make([]byte, nameSize+size)
+		if _, err := io.ReadFull(r, rest); err != nil {
+			t.Fatal(err)
+		}
+		name := string(rest[:nameSize-1])
+		if name == "TRAILER!!!" {
+			return c
+		}
+		c.names = append(c.names, name)
+		c.content[name] = string(rest[nameSize:])
+	}
+}
+
+type bomContent struct {
+	names  []string
+	cksums map[string]uint32
+}
+
+// readBom lists the paths in the Bom by walking the leaves of the "Paths" tree.
+func readBom(t *testing.T, b []byte) *bomContent {
+	if string(b[:8]) != "BOMStore" {
+		t.Fatal("bad bom magic")
+	}
+	u32 := func(b []byte) uint32 { return binary.BigEndian.Uint32(b) }
+	indexOffset, varsOffset := u32(b[16:]), u32(b[24:])
+	block := func(i uint32) []byte {
+		e := b[indexOffset+4+i*8:]
+		return b[u32(e) : u32(e)+u32(e[4:])]
+	}
+	vars := b[varsOffset:]
+	var paths uint32
+	for n, v := u32(vars), vars[4:]; n > 0; n-- {
+		name := string(v[5 : 5+v[4]])
+		if name == "Paths" {
+			paths = u32(v)
+		}
+		v = v[5+v[4]:]
+	}
+	tree := block(paths)
+	if string(tree[:4]) != "tree" {
+		t.Fatal("bad Paths tree")
+	}
+	node := block(u32(tree[8:]))
+	for binary.BigEndian.Uint16(node) == 0 {
+		node = block(u32(node[12:]))
+	}
+
+	c := &bomContent{cksums: make(map[string]uint32)}
+	full := map[uint32]string{}
+	for {
+		count := int(binary.BigEndian.Uint16(node[2:]))
+		for i := range count {
+			e := node[12+i*8:]
+			info1 := block(u32(e))
+			key := block(u32(e[4:]))
+			id, parent := u32(info1), u32(key)
+			name := string(key[4 : len(key)-1])
+			if parent != 0 {
+				name = full[parent] + "/" + name
+			}
+			full[id] = name
+			if name != "." {
+				name = "./" + strings.TrimPrefix(name, "./")
+			}
+			c.names = append(c.names, name)
+			info2 := block(u32(info1[4:]))
+			c.cksums[name] = u32(info2[23:])
+		}
+		forward := u32(node[4:])
+		if forward == 0 {
+			return c
+		}
+		node = block(forward)
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package macpkg
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"os"
+	"time"
+)
+
+// A flat package is a xar archive. The format is a binary header, a zlib-compressed XML table
+// of contents (TOC), and a heap. The heap starts with the SHA-1 checksum of the compressed TOC,
+// followed by the file data at the offsets given in the TOC.
+//
+// https://github.com/mackyle/xar/wiki/xarformat
+const (
+	xarMagic      = 0x78617221 // "xar!"
+	xarHeaderSize = 28
+	xarVersion    = 1
+	// xarChecksumSHA1 is the TOC checksum algorithm.
+	xarChecksumSHA1 = 1
+)
+
+type xarTOC struct {
+	XMLName xml.Name `xml:"xar"`
+	TOC     struct {
+		Checksum     xarTOCChecksum `xml:"checksum"`
+		CreationTime string         `xml:"creation-time"`
+		Files        []*xarFile     `xml:"file"`
+	} `xml:"toc"`
+}
+
+type xarTOCChecksum struct {
+	Style  string `xml:"style,attr"`
+	Offset int64  `xml:"offset"`
+	Size   int64  `xml:"size"`
+}
+
+type xarFile struct {
+	ID    int        `xml:"id,attr"`
+	Data  *xarData   `xml:"data,omitempty"`
+	Name  string     `xml:"name"`
+	Type  string     `xml:"type"`
+	Mode  string     `xml:"mode"`
+	UID   int        `xml:"uid"`
+	GID   int        `xml:"gid"`
+	Files []*xarFile `xml:"file"`
+
+	// open returns the content of a file.
+	open func() (io.ReadCloser, error)
+}
+
+type xarData struct {
+	Length            int64       `xml:"length"`
+	Offset            int64       `xml:"offset"`
+	Size              int64       `xml:"size"`
+	Encoding          xarEncoding `xml:"encoding"`
+	ExtractedChecksum xarChecksum `xml:"extracted-checksum"`
+	ArchivedChecksum  xarChecksum `xml:"archived-checksum"`
+}
+
+type xarEncoding struct {
+	Style string `xml:"style,attr"`
+}
+
+type xarChecksum struct {
+	Style string `xml:"style,attr"`
+	Value string `xml:",chardata"`
+}
+
+// xarWriter collects the files of a xar archive, then writes it. File data is stored without
+// compression: the only large file in a package is the Payload, which is already compressed.
+type xarWriter struct {
+	files  []*xarFile
+	nextID int
+	// heap is the data files in heap order.
+	heap []*xarFile
+	// offset is the heap offset of the next file's data.
+	offset int64
+}
+
+func newXARWriter() *xarWriter {
+	// The TOC checksum is at the start of the heap.
+	return &xarWriter{nextID: 1, offset: sha1.Size}
+}
+
+// addDir adds a directory named name to parent, or to the root if parent is nil.
+func (x *xarWriter) addDir(parent *xarFile, name string) *xarFile {
+	f := &xarFile{Name: name, Type: "directory", Mode: "0755"}
+	x.add(parent, f)
+	return f
+}
+
+// addBytes adds a file named name with content b to parent, or to the root if parent is nil.
+func (x *xarWriter) addBytes(parent *xarFile, name string, b []byte) {
+	sum := sha1.Sum(b)
+	x.addData(parent, name, int64(len(b)), sum[:], func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(b)), nil
+	})
+}
+
+// addFile adds a file named name to parent, or to the root if parent is nil, with the content
+// of the file at path. The file is read once now for its checksum and again by write.
+func (x *xarWriter) addFile(parent *xarFile, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha1.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	x.addData(parent, name, n, h.Sum(nil), func() (io.ReadCloser, error) {
+		return os.Open(path)
+	})
+	return nil
+}
+
+func (x *xarWriter) addData(parent *xarFile, name string, size int64, sum []byte, open func() (io.ReadCloser, error)) {
+	checksum := xarChecksum{Style: "sha1", Value: hex.EncodeToString(sum)}
+	f := &xarFile{
+		Name: name,
+		Type: "file",
+		Mode: "0644",
+		Data: &xarData{
+			Length:            size,
+			Offset:            x.offset,
+			Size:              size,
+			Encoding:          xarEncoding{Style: "application/octet-stream"},
+			ExtractedChecksum: checksum,
+			ArchivedChecksum:  checksum,
+		},
+		open: open,
+	}
+	x.offset += size
+	x.heap = append(x.heap, f)
+	x.add(parent, f)
+}
+
+func (x *xarWriter) add(parent *xarFile, f *xarFile) {
+	f.ID = x.nextID
+	x.nextID++
+	if parent == nil {
+		x.files = append(x.files, f)
+	} else {
+		parent.Files = append(parent.Files, f)
+	}
+}
+
+// write writes the archive to w. created is recorded as the TOC creation time.
+func (x *xarWriter) write(w io.Writer, created time.Time) error {
+	var toc xarTOC
+	toc.TOC.Checksum = xarTOCChecksum{Style: "sha1", Offset: 0, Size: sha1.Size}
+	toc.TOC.CreationTime = created.UTC().Format(time.RFC3339)
+	toc.TOC.Files = x.files
+	tocXML, err := xml.MarshalIndent(&toc, "", "  ")
+	if err != nil {
+		return err
+	}
+	tocXML = append([]byte(xml.Header), tocXML...)
+
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	if _, err := zw.Write(tocXML); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	header := make([]byte, 0, xarHeaderSize)
+	header = binary.BigEndian.AppendUint32(header, xarMagic)
+	header = binary.BigEndian.AppendUint16(header, xarHeaderSize)
+	header = binary.BigEndian.AppendUint16(header, xarVersion)
+	header = binary.BigEndian.AppendUint64(header, uint64(compressed.Len()))
+	header = binary.BigEndian.AppendUint64(header, uint64(len(tocXML)))
+	header = binary.BigEndian.AppendUint32(header, xarChecksumSHA1)
+	tocSum := sha1.Sum(compressed.Bytes())
+	for _, b := range [][]byte{header, compressed.Bytes(), tocSum[:]} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range x.heap {
+		if err := copyXARData(w, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyXARData(w io.Writer, f *xarFile) error {
+	r, err := f.open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if _, err := io.CopyN(w, r, f.Data.Size); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,506 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package msi creates a Windows Installer package (MSI) from a Windows Go distribution zip. It
+// generates WiX source from the zip and runs wixl (from msitools) to compile it, so the MSI can
+// be created on Linux.
+package msi
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/microsoft/go/_util/internal/archive"
+)
+
+// Options describes the installer to create.
+type Options struct {
+	// Version is the upstream Go version without the "go" prefix, e.g. "1.23.1".
+	Version string
+	// Release is the Microsoft revision, e.g. "1".
+	Release string
+	// GOARCH is the Go architecture of the distribution.
+	GOARCH string
+
+	// InstallDir is the default install location relative to the Program Files folder, e.g.
+	// "Microsoft\Go". Users can override it at install time by setting the INSTALLDIR property.
+	InstallDir string
+	// AddToPath includes a feature, enabled by default, that adds the Go bin directory to the
+	// system PATH. Users can exclude it at install time with "REMOVE=AddToPath".
+	AddToPath bool
+}
+
+// DefaultInstallDir is the default value of Options.InstallDir.
+const DefaultInstallDir = `Microsoft\Go`
+
+// NewOptions returns default options for an installer created from the Microsoft Go archive at p,
+// such as "go1.23.1-1.windows-amd64.zip", using the version and architecture in the filename.
+func NewOptions(p string) (*Options, error) {
+	n, err := archive.ParseName(p)
+	if err != nil {
+		return nil, err
+	}
+	if n.GOOS != "windows" || n.Format != archive.Zip {
+		return nil, fmt.Errorf("not a Windows zip archive: %q", filepath.Base(p))
+	}
+	return &Options{
+		Version:    n.Version,
+		Release:    n.Release,
+		GOARCH:     n.GOARCH,
+		InstallDir: DefaultInstallDir,
+		AddToPath:  true,
+	}, nil
+}
+
+// Filename returns the conventional filename of the installer. It matches the archive naming
+// pattern so the installer is found by the same tools.
+func (o *Options) Filename() string {
+	return "go" + o.Version + "-" + o.Release + ".windows-" + o.GOARCH + ".msi"
+}
+
+type platform struct {
+	// wixl is the value for wixl's "-a" flag and the WiX Package Platform attribute.
+	wixl string
+	// programFiles is the WiX directory ID of the Program Files folder for the platform.
+	programFiles string
+}
+
+func (o *Options) platform() (*platform, error) {
+	switch o.GOARCH {
+	case "amd64":
+		return &platform{"x64", "ProgramFiles64Folder"}, nil
+	case "386":
+		return &platform{"x86", "ProgramFilesFolder"}, nil
+	}
+	return nil, fmt.Errorf("unsupported architecture for MSI: %q (wixl supports amd64 and 386)", o.GOARCH)
+}
+
+// productVersion returns the MSI ProductVersion. MSI only compares the first three fields, and
+// the third field is at most 65535, so the Go patch version and the release are combined into
+// the third field to make each release an upgrade of the previous one:
+//
+//   - A release candidate like "1.24rc1" is rc*100 + release, below 1000.
+//   - A Go release like "1.24.0" is (patch+1)*1000 + release, so it's above all its release
+//     candidates.
+func (o *Options) productVersion() (string, error) {
+	r, err := releaseNumber(o.Release)
+	if err != nil {
+		return "", err
+	}
+	v, rcStr, isRC := strings.Cut(o.Version, "rc")
+	parts := strings.Split(v, ".")
+	if isRC && len(parts) != 2 || !isRC && len(parts) > 3 {
+		return "", fmt.Errorf("unsupported version for MSI: %q", o.Version)
+	}
+	for len(parts) < 3 {
+		parts = append(parts, "0")
+	}
+	var nums [3]int
+	for i, p := range parts {
+		if nums[i], err = strconv.Atoi(p); err != nil || nums[i] < 0 {
+			return "", fmt.Errorf("unsupported version for MSI: %q", o.Version)
+		}
+	}
+	if nums[0] > 255 || nums[1] > 255 || nums[2] > 63 {
+		return "", fmt.Errorf("version out of range for MSI: %q", o.Version)
+	}
+	third := (nums[2]+1)*1000 + r
+	if isRC {
+		rc, err := strconv.Atoi(rcStr)
+		if err != nil || rc < 1 || rc > 9 {
+			return "", fmt.Errorf("unsupported release candidate for MSI: %q", o.Version)
+		}
+		if r > 99 {
+			return "", fmt.Errorf("release out of range for an MSI release candidate, must be 0 to 99: %q", o.Release)
+		}
+		third = rc*100 + r
+	}
+	return fmt.Sprintf("%v.%v.%v", nums[0], nums[1], third), nil
+}
+
+// ciBuildNumber matches the build number of a CI build, like "20241018.1".
+var ciBuildNumber = regexp.MustCompile(`^[0-9]{8}\.[0-9]+$`)
+
+// releaseNumber returns the number stored in the ProductVersion for release. A Microsoft revision
+// like "1" is used as is, and must be 0 to 999. A CI build, named with a build number like
+// "20241018.1" that doesn't fit, gets 0: lower than any revision, so a released MSI upgrades it.
+// CI builds of the same Go version have the same ProductVersion and replace each other.
+func releaseNumber(release string) (int, error) {
+	if ciBuildNumber.MatchString(release) {
+		return 0, nil
+	}
+	r, err := strconv.Atoi(release)
+	if err != nil || r < 0 || r > 999 {
+		return 0, fmt.Errorf("release out of range for MSI, must be 0 to 999 or a CI build number: %q", release)
+	}
+	return r, nil
+}
+
+// Build creates an MSI at dst from the Windows Go distribution zip at src. It requires wixl on
+// PATH. The wxs source and the extracted files are kept in workDir, which must be empty.
+func Build(src, dst, workDir string, o *Options) error {
+	p, err := o.platform()
+	if err != nil {
+		return err
+	}
+	filesDir, err := filepath.Abs(filepath.Join(workDir, "files"))
+	if err != nil {
+		return err
+	}
+	a, err := archive.New(src)
+	if err != nil {
+		return err
+	}
+	if _, err := a.Extract(filesDir, func(e *archive.Entry) bool { return e.IsRegular() }); err != nil {
+		return err
+	}
+	wxsPath := filepath.Join(workDir, strings.TrimSuffix(filepath.Base(dst), ".msi")+".wxs")
+	if err := archive.WithFileCreate(wxsPath, func(f *os.File) error {
+		return WriteWXS(f, src, filesDir, o)
+	}); err != nil {
+		return err
+	}
+
+	cmd := exec.Command("wixl", "-a", p.wixl, "-o", dst, wxsPath)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to run %v: %v", cmd, err)
+	}
+	return nil
+}
+
+// WriteWXS writes WiX source for an installer of the zip at src to w. The File elements refer to
+// files under filesDir, where src is expected to be extracted.
+func WriteWXS(w io.Writer, src, filesDir string, o *Options) error {
+	p, err := o.platform()
+	if err != nil {
+		return err
+	}
+	version, err := o.productVersion()
+	if err != nil {
+		return err
+	}
+	a, err := archive.New(src)
+	if err != nil {
+		return err
+	}
+	if a.Format != archive.Zip {
+		return fmt.Errorf("expected a zip archive: %q", src)
+	}
+	entries, err := a.List()
+	if err != nil {
+		return err
+	}
+
+	installDirs := strings.FieldsFunc(o.InstallDir, func(r rune) bool { return r == '\\' || r == '/' })
+	if len(installDirs) == 0 {
+		return fmt.Errorf("invalid install dir: %q", o.InstallDir)
+	}
+
+	goDir := &wxsDirectory{ID: "INSTALLDIR", Name: installDirs[len(installDirs)-1]}
+	upgradeCode := guid("UpgradeCode/" + o.GOARCH)
+	feature := &wxsFeature{
+		ID:              "Go",
+		Title:           "Go",
+		Description:     "The Go toolchain and standard library.",
+		Level:           1,
+		Absent:          "disallow",
+		ConfigurableDir: "INSTALLDIR",
+	}
+
+	dirs := map[string]*wxsDirectory{"go": goDir}
+	var dirFor func(rel string) (*wxsDirectory, error)
+	dirFor = func(rel string) (*wxsDirectory, error) {
+		if d, ok := dirs[rel]; ok {
+			return d, nil
+		}
+		parent, err := dirFor(path.Dir(rel))
+		if err != nil {
+			return nil, err
+		}
+		d := &wxsDirectory{ID: id("d", rel), Name: path.Base(rel)}
+		parent.Directories = append(parent.Directories, d)
+		dirs[rel] = d
+		return d, nil
+	}
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name, "/")
+		if name != "go" && !strings.HasPrefix(name, "go/") {
+			return fmt.Errorf("entry isn't in the 'go' directory: %q", e.Name)
+		}
+		if e.IsDir() {
+			if _, err := dirFor(name); err != nil {
+				return err
+			}
+			continue
+		}
+		if !e.IsRegular() || name == "go" {
+			return fmt.Errorf("unsupported entry type for %q: %v", e.Name, e.Mode)
+		}
+		d, err := dirFor(path.Dir(name))
+		if err != nil {
+			return err
+		}
+		// One component per file, with the file as its key path, is the MSI best practice. The
+		// component GUID must be stable across versions for the same install path.
+		c := &wxsComponent{
+			ID:   id("c", name),
+			GUID: guid(upgradeCode + "/" + name),
+			File: &wxsFile{
+				ID:      id("f", name),
+				Name:    path.Base(name),
+				Source:  filepath.Join(filesDir, filepath.FromSlash(name)),
+				KeyPath: "yes",
+			},
+		}
+		d.Components = append(d.Components, c)
+		feature.ComponentRefs = append(feature.ComponentRefs, wxsComponentRef{ID: c.ID})
+	}
+	// MSI only creates directories that contain a component, so add a component to create each
+	// empty directory.
+	dirNames := make([]string, 0, len(dirs))
+	for rel := range dirs {
+		dirNames = append(dirNames, rel)
+	}
+	slices.Sort(dirNames)
+	for _, rel := range dirNames {
+		d := dirs[rel]
+		if len(d.Components) == 0 && len(d.Directories) == 0 {
+			c := &wxsComponent{ID: id("c", rel+"/"), GUID: guid(upgradeCode + "/" + rel + "/"), CreateFolder: &struct{}{}}
+			d.Components = append(d.Components, c)
+			feature.ComponentRefs = append(feature.ComponentRefs, wxsComponentRef{ID: c.ID})
+		}
+	}
+
+	features := []*wxsFeature{feature}
+	if o.AddToPath {
+		c := &wxsComponent{
+			ID:   "AddToPath",
+			GUID: guid(upgradeCode + "/AddToPath"),
+			RegistryValue: &wxsRegistryValue{
+				Root:    "HKLM",
+				Key:     `Software\Microsoft\Go`,
+				Name:    "AddToPath",
+				Type:    "integer",
+				Value:   "1",
+				KeyPath: "yes",
+			},
+			Environment: &wxsEnvironment{
+				ID:        "PATH",
+				Name:      "PATH",
+				Value:     "[INSTALLDIR]bin",
+				Permanent: "no",
+				Part:      "last",
+				Action:    "set",
+				System:    "yes",
+			},
+		}
+		goDir.Components = append(goDir.Components, c)
+		features = append(features, &wxsFeature{
+			ID:            "AddToPath",
+			Title:         "Add to PATH",
+			Description:   `Add the Go bin directory to the system PATH.`,
+			Level:         1,
+			ComponentRefs: []wxsComponentRef{{ID: c.ID}},
+		})
+	}
+
+	// Nest the install dir under the Program Files folder.
+	top := goDir
+	for i := len(installDirs) - 2; i >= 0; i-- {
+		top = &wxsDirectory{
+			ID:          id("d", "installdir/"+strings.Join(installDirs[:i+1], "/")),
+			Name:        installDirs[i],
+			Directories: []*wxsDirectory{top},
+		}
+	}
+
+	displayVersion := o.Version + "-" + o.Release
+	wix := &wxs{
+		Xmlns: "http://schemas.microsoft.com/wix/2006/wi",
+		Product: wxsProduct{
+			ID:           guid("Product/" + o.GOARCH + "/" + displayVersion),
+			Name:         "Microsoft Go " + displayVersion + " " + o.GOARCH,
+			Language:     "1033",
+			Version:      version,
+			Manufacturer: "Microsoft Corporation",
+			UpgradeCode:  upgradeCode,
+			Package: wxsPackage{
+				InstallerVersion: "500",
+				Compressed:       "yes",
+				InstallScope:     "perMachine",
+				Platform:         p.wixl,
+				Description:      "Microsoft Go " + displayVersion,
+				Manufacturer:     "Microsoft Corporation",
+			},
+			Media: wxsMedia{ID: "1", Cabinet: "go.cab", EmbedCab: "yes"},
+			MajorUpgrade: wxsMajorUpgrade{
+				// CI builds of the same Go version share a ProductVersion: replace rather than
+				// install next to each other.
+				AllowSameVersionUpgrades: "yes",
+				DowngradeErrorMessage:    "A newer version of Microsoft Go is already installed.",
+			},
+			Properties: []wxsProperty{
+				{ID: "ARPURLINFOABOUT", Value: "https://github.com/microsoft/go"},
+			},
+			Directory: wxsDirectory{
+				ID:   "TARGETDIR",
+				Name: "SourceDir",
+				Directories: []*wxsDirectory{
+					{ID: p.programFiles, Directories: []*wxsDirectory{top}},
+				},
+			},
+			Features: features,
+		},
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(wix); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// id returns a WiX identifier for rel with the given prefix. Identifiers are limited to 72
+// characters and a small character set, so a hash of the path is used.
+func id(prefix, rel string) string {
+	sum := sha1.Sum([]byte(rel))
+	return prefix + hex.EncodeToString(sum[:16])
+}
+
+// guidNamespace is the name-based UUID namespace for the GUIDs of Microsoft Go installers.
+var guidNamespace = [16]byte{
+	0x6b, 0x3d, 0x9a, 0x0e, 0x52, 0x41, 0x4c, 0x1f, 0x9a, 0x8e, 0x3c, 0x2d, 0x58, 0x47, 0xb0, 0x91,
+}
+
+// guid returns a stable version 5 (SHA-1 name-based) UUID for name, formatted for WiX.
+func guid(name string) string {
+	h := sha1.New()
+	h.Write(guidNamespace[:])
+	h.Write([]byte(name))
+	u := h.Sum(nil)[:16]
+	u[6] = (u[6] & 0x0f) | 0x50
+	u[8] = (u[8] & 0x3f) | 0x80
+	s := strings.ToUpper(hex.EncodeToString(u))
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+type wxs struct {
+	XMLName xml.Name   `xml:"Wix"`
+	Xmlns   string     `xml:"xmlns,attr"`
+	Product wxsProduct `xml:"Product"`
+}
+
+type wxsProduct struct {
+	ID           string          `xml:"Id,attr"`
+	Name         string          `xml:"Name,attr"`
+	Language     string          `xml:"Language,attr"`
+	Version      string          `xml:"Version,attr"`
+	Manufacturer string          `xml:"Manufacturer,attr"`
+	UpgradeCode  string          `xml:"UpgradeCode,attr"`
+	Package      wxsPackage      `xml:"Package"`
+	Media        wxsMedia        `xml:"Media"`
+	MajorUpgrade wxsMajorUpgrade `xml:"MajorUpgrade"`
+	Properties   []wxsProperty   `xml:"Property"`
+	Directory    wxsDirectory    `xml:"Directory"`
+	Features     []*wxsFeature   `xml:"Feature"`
+}
+
+type wxsPackage struct {
+	InstallerVersion string `xml:"InstallerVersion,attr"`
+	Compressed       string `xml:"Compressed,attr"`
+	InstallScope     string `xml:"InstallScope,attr"`
+	Platform         string `xml:"Platform,attr"`
+	Description      string `xml:"Description,attr"`
+	Manufacturer     string `xml:"Manufacturer,attr"`
+}
+
+type wxsMedia struct {
+	ID       string `xml:"Id,attr"`
+	Cabinet  string `xml:"Cabinet,attr"`
+	EmbedCab string `xml:"EmbedCab,attr"`
+}
+
+type wxsMajorUpgrade struct {
+	AllowSameVersionUpgrades string `xml:"AllowSameVersionUpgrades,attr,omitempty"`
+	DowngradeErrorMessage    string `xml:"DowngradeErrorMessage,attr"`
+}
+
+type wxsProperty struct {
+	ID    string `xml:"Id,attr"`
+	Value string `xml:"Value,attr"`
+}
+
+type wxsDirectory struct {
+	ID          string          `xml:"Id,attr"`
+	Name        string          `xml:"Name,attr,omitempty"`
+	Components  []*wxsComponent `xml:"Component"`
+	Directories []*wxsDirectory `xml:"Directory"`
+}
+
+type wxsComponent struct {
+	ID            string            `xml:"Id,attr"`
+	GUID          string            `xml:"Guid,attr"`
+	File          *wxsFile          `xml:"File"`
+	CreateFolder  *struct{}         `xml:"CreateFolder"`
+	RegistryValue *wxsRegistryValue `xml:"RegistryValue"`
+	Environment   *wxsEnvironment   `xml:"Environment"`
+}
+
+type wxsFile struct {
+	ID      string `xml:"Id,attr"`
+	Name    string `xml:"Name,attr"`
+	Source  string `xml:"Source,attr"`
+	KeyPath string `xml:"KeyPath,attr"`
+}
+
+type wxsRegistryValue struct {
+	Root    string `xml:"Root,attr"`
+	Key     string `xml:"Key,attr"`
+	Name    string `xml:"Name,attr"`
+	Type    string `xml:"Type,attr"`
+	Value   string `xml:"Value,attr"`
+	KeyPath string `xml:"KeyPath,attr"`
+}
+
+type wxsEnvironment struct {
+	ID        string `xml:"Id,attr"`
+	Name      string `xml:"Name,attr"`
+	Value     string `xml:"Value,attr"`
+	Permanent string `xml:"Permanent,attr"`
+	Part      string `xml:"Part,attr"`
+	Action    string `xml:"Action,attr"`
+	System    string `xml:"System,attr"`
+}
+
+type wxsFeature struct {
+	ID              string            `xml:"Id,attr"`
+	Title           string            `xml:"Title,attr"`
+	Description     string            `xml:"Description,attr"`
+	Level           int               `xml:"Level,attr"`
+	Absent          string            `xml:"Absent,attr,omitempty"`
+	ConfigurableDir string            `xml:"ConfigurableDirectory,attr,omitempty"`
+	ComponentRefs   []wxsComponentRef `xml:"ComponentRef"`
+}
+
+type wxsComponentRef struct {
+	ID string `xml:"Id,attr"`
+}
@@ -0,0 +1,166 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package msi
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/microsoft/go/_util/internal/archive"
+)
+
+func TestWriteWXS(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "go1.23.1-2.windows-amd64.zip")
+	if err := archive.WithZipCreate(src, func(zw *zip.Writer) error {
+		for _, name := range []string{"go/bin/go.exe", "go/bin/gofmt.exe", "go/src/fmt/print.go", "go/pkg/empty/"} {
+			if _, err := zw.Create(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, addToPath := range []bool{true, false} {
+		o, err := NewOptions(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		o.AddToPath = addToPath
+
+		var b bytes.Buffer
+		if err := WriteWXS(&b, src, "files", o); err != nil {
+			t.Fatal(err)
+		}
+		var got wxs
+		if err := xml.Unmarshal(b.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Product.Version != "1.23.2002" {
+			t.Errorf("Version = %q, want 1.23.2002", got.Product.Version)
+		}
+
+		ids := make(map[string]bool)
+		var files, components int
+		var walk func(d *wxsDirectory)
+		walk = func(d *wxsDirectory) {
+			if ids[d.ID] {
+				t.Errorf("duplicate ID %q", d.ID)
+			}
+			ids[d.ID] = true
+			for _, c := range d.Components {
+				if ids[c.ID] {
+					t.Errorf("duplicate ID %q", c.ID)
+				}
+				ids[c.ID] = true
+				components++
+				if c.File != nil {
+					files++
+				}
+			}
+			for _, sub := range d.Directories {
+				walk(sub)
+			}
+		}
+		walk(&got.Product.Directory)
+
+		wantComponents, wantFeatures := 4, 1
+		if addToPath {
+			wantComponents, wantFeatures = 5, 2
+		}
+		if files != 3 || components != wantComponents {
+			t.Errorf("AddToPath=%v: %v files, %v components; want 3 files, %v components", addToPath, files, components, wantComponents)
+		}
+		if len(got.Product.Features) != wantFeatures {
+			t.Errorf("AddToPath=%v: %v features, want %v", addToPath, len(got.Product.Features), wantFeatures)
+		}
+	}
+}
+
+func TestProductVersion(t *testing.T) {
+	tests := []struct {
+		version, release, want string
+	}{
+		{"1.23.1", "1", "1.23.2001"},
+		{"1.23.12", "3", "1.23.13003"},
+		{"1.24.0", "1", "1.24.1001"},
+		{"1.24", "1", "1.24.1001"},
+		{"1.24rc1", "1", "1.24.101"},
+		{"1.24rc2", "2", "1.24.202"},
+		{"1.23.1", "20241018.1", "1.23.2000"},
+		{"1.24rc1", "20241018.1", "1.24.100"},
+	}
+	for _, tt := range tests {
+		o := &Options{Version: tt.version, Release: tt.release}
+		got, err := o.productVersion()
+		if err != nil {
+			t.Errorf("productVersion(%q, %q) error: %v", tt.version, tt.release, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("productVersion(%q, %q) = %q, want %q", tt.version, tt.release, got, tt.want)
+		}
+	}
+}
+
+func TestProductVersionError(t *testing.T) {
+	tests := []struct {
+		version, release string
+	}{
+		{"1.23.1", "1000"},
+		{"1.23.1", "dev"},
+		{"1.24rc1", "100"},
+		{"1.24rc0", "1"},
+		{"1.24.1rc1", "1"},
+		{"1.2.3.4", "1"},
+		{"1.23.1", "-1"},
+		{"1.23.1", "1.1"},
+		{"1.23.1", ""},
+		{"1.23.64", "1"},
+		{"beta", "1"},
+	}
+	for _, tt := range tests {
+		o := &Options{Version: tt.version, Release: tt.release}
+		if got, err := o.productVersion(); err == nil {
+			t.Errorf("productVersion(%q, %q) = %q, want error", tt.version, tt.release, got)
+		}
+	}
+}
+
+// TestProductVersionOrder checks that each build upgrades the builds before it, in particular that
+// the Go release upgrades its release candidates.
+func TestProductVersionOrder(t *testing.T) {
+	builds := []struct{ version, release string }{
+		{"1.23.12", "2"},
+		{"1.24rc1", "20241018.1"},
+		{"1.24rc1", "1"},
+		{"1.24rc2", "1"},
+		{"1.24.0", "20241108.3"},
+		{"1.24.0", "1"},
+		{"1.24.0", "2"},
+		{"1.24.1", "1"},
+	}
+	var prev [3]int
+	for i, b := range builds {
+		v, err := (&Options{Version: b.version, Release: b.release}).productVersion()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cur [3]int
+		if _, err := fmt.Sscanf(v, "%d.%d.%d", &cur[0], &cur[1], &cur[2]); err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 && slices.Compare(cur[:], prev[:]) <= 0 {
+			t.Errorf("%v-%v has ProductVersion %v, want it above the previous build's %v", b.version, b.release, cur, prev)
+		}
+		prev = cur
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provenance
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/microsoft/go/_util/internal/signer"
+)
+
+// PayloadType is the DSSE payload type of an in-toto statement.
+const PayloadType = "application/vnd.in-toto+json"
+
+// Ext is added to an archive's name to get the name of its attestation file. The file contains
+// one DSSE envelope on a single line, the in-toto JSON Lines bundle format.
+const Ext = ".intoto.jsonl"
+
+// Envelope is a DSSE envelope.
+type Envelope struct {
+	PayloadType string `json:"payloadType"`
+	// Payload is the base64 statement JSON.
+	Payload    string      `json:"payload"`
+	Signatures []Signature `json:"signatures"`
+}
+
+type Signature struct {
+	KeyID string `json:"keyid"`
+	// Sig is the base64 signature of the PAE of the payload.
+	Sig string `json:"sig"`
+}
+
+// pae is the DSSE pre-authentication encoding: the message that is actually signed.
+func pae(payloadType string, payload []byte) []byte {
+	b := []byte("DSSEv1 " + strconv.Itoa(len(payloadType)) + " " + payloadType + " " + strconv.Itoa(len(payload)) + " ")
+	return append(b, payload...)
+}
+
+// Sign encodes s and signs it with each signer. With no signers, the envelope is unsigned.
+func Sign(s *Statement, signers ...signer.Signer) (*Envelope, error) {
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	e := &Envelope{
+		PayloadType: PayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []Signature{},
+	}
+	for _, sg := range signers {
+		sig, err := sg.Sign(pae(PayloadType, payload))
+		if err != nil {
+			return nil, fmt.Errorf("signing with key %v: %v", sg.KeyID(), err)
+		}
+		e.Signatures = append(e.Signatures, Signature{KeyID: sg.KeyID(), Sig: base64.StdEncoding.EncodeToString(sig)})
+	}
+	return e, nil
+}
+
+// Write writes e to path as a single line.
+func (e *Envelope) Write(path string) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o666)
+}
+
+// Read reads the envelope at path.
+func Read(path string) (*Envelope, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var e Envelope
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return &e, nil
+}
+
+// Verify checks that e is signed by at least one of keys and returns the statement.
+func (e *Envelope) Verify(keys []ed25519.PublicKey) (*Statement, error) {
+	if e.PayloadType != PayloadType {
+		return nil, fmt.Errorf("payload type is %q, want %q", e.PayloadType, PayloadType)
+	}
+	payload, err := base64.StdEncoding.DecodeString(e.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload: %v", err)
+	}
+	if !e.signedBy(keys, pae(e.PayloadType, payload)) {
+		return nil, errors.New("no valid signature from a trusted key")
+	}
+	var s Statement
+	if err := json.Unmarshal(payload, &s); err != nil {
+		return nil, fmt.Errorf("invalid statement: %v", err)
+	}
+	if s.Type != StatementType {
+		return nil, fmt.Errorf("statement type is %q, want %q", s.Type, StatementType)
+	}
+	if s.PredicateType != PredicateType {
+		return nil, fmt.Errorf("predicate type is %q, want %q", s.PredicateType, PredicateType)
+	}
+	return &s, nil
+}
+
+func (e *Envelope) signedBy(keys []ed25519.PublicKey, msg []byte) bool {
+	for _, s := range e.Signatures {
+		sig, err := base64.StdEncoding.DecodeString(s.Sig)
+		if err != nil {
+			continue
+		}
+		for _, k := range keys {
+			// The key ID is only a hint, so try every key.
+			if ed25519.Verify(k, msg, sig) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// VerifyArchive checks that e is signed by at least one of keys and that the archive at path is
+// one of the subjects of the statement. Returns the statement.
+func VerifyArchive(path string, e *Envelope, keys []ed25519.PublicKey) (*Statement, error) {
+	s, err := e.Verify(keys)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := s.matchesSubject(path)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%v doesn't match any subject of the attestation", path)
+	}
+	return s, nil
+}
@@ -0,0 +1,198 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package provenance creates and verifies SLSA v1 build provenance for Go archives, as in-toto
+// statements in DSSE envelopes.
+package provenance
+
+import (
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/microsoft/go/_util/internal/checksum"
+)
+
+const (
+	// StatementType is the in-toto statement type.
+	StatementType = "https://in-toto.io/Statement/v1"
+	// PredicateType is the SLSA provenance v1 predicate type.
+	PredicateType = "https://slsa.dev/provenance/v1"
+	// BuildType describes how the archives are built: by the 'build' command in this repo.
+	BuildType = "https://github.com/microsoft/go/eng/_util/cmd/build@v1"
+)
+
+// subjectAlgorithms are the digests recorded for each subject.
+var subjectAlgorithms = []checksum.Algorithm{checksum.SHA256, checksum.SHA512}
+
+// Statement is an in-toto statement with SLSA provenance.
+type Statement struct {
+	Type          string     `json:"_type"`
+	Subject       []Resource `json:"subject"`
+	PredicateType string     `json:"predicateType"`
+	Predicate     Predicate  `json:"predicate"`
+}
+
+// Resource is an in-toto resource descriptor.
+type Resource struct {
+	Name   string            `json:"name,omitempty"`
+	URI    string            `json:"uri,omitempty"`
+	Digest map[string]string `json:"digest"`
+}
+
+// Predicate is a SLSA v1 provenance predicate.
+type Predicate struct {
+	BuildDefinition BuildDefinition `json:"buildDefinition"`
+	RunDetails      RunDetails      `json:"runDetails"`
+}
+
+type BuildDefinition struct {
+	BuildType          string             `json:"buildType"`
+	ExternalParameters ExternalParameters `json:"externalParameters"`
+	// ResolvedDependencies are the materials of the build: the source repo, the Go submodule, and
+	// each patch applied to the submodule.
+	ResolvedDependencies []Resource `json:"resolvedDependencies"`
+}
+
+type ExternalParameters struct {
+	// GOEXPERIMENT is the GOEXPERIMENT the toolchain was built with.
+	GOEXPERIMENT string `json:"goexperiment,omitempty"`
+	// BuildID is BUILD_BUILDNUMBER, or "dev" for a local build.
+	BuildID string `json:"buildID"`
+}
+
+type RunDetails struct {
+	Builder  Builder  `json:"builder"`
+	Metadata Metadata `json:"metadata"`
+}
+
+type Builder struct {
+	ID string `json:"id"`
+}
+
+type Metadata struct {
+	InvocationID string     `json:"invocationId,omitempty"`
+	FinishedOn   *time.Time `json:"finishedOn,omitempty"`
+}
+
+// Options describe the build that produced an archive.
+type Options struct {
+	BuilderID    string
+	InvocationID string
+	BuildID      string
+	GOEXPERIMENT string
+
+	// SourceURI and SourceCommit identify the commit of this repo that was built.
+	SourceURI    string
+	SourceCommit string
+	// SubmoduleURI and SubmoduleCommit identify the upstream Go commit the patches apply to.
+	SubmoduleURI    string
+	SubmoduleCommit string
+	// Patches are the patches applied to the submodule, in order.
+	Patches []Resource
+
+	FinishedOn time.Time
+}
+
+// New returns a statement for the archive at path built as described by o.
+func New(path string, o *Options) (*Statement, error) {
+	sums, err := new(checksum.Engine).Files([]string{path}, subjectAlgorithms...)
+	if err != nil {
+		return nil, err
+	}
+	var deps []Resource
+	if o.SourceCommit != "" {
+		deps = append(deps, Resource{URI: "git+" + o.SourceURI, Digest: map[string]string{"gitCommit": o.SourceCommit}})
+	}
+	if o.SubmoduleCommit != "" {
+		deps = append(deps, Resource{URI: "git+" + o.SubmoduleURI, Digest: map[string]string{"gitCommit": o.SubmoduleCommit}})
+	}
+	deps = append(deps, o.Patches...)
+	s := &Statement{
+		Type:          StatementType,
+		Subject:       []Resource{{Name: filepath.Base(path), Digest: digest(sums[0])}},
+		PredicateType: PredicateType,
+		Predicate: Predicate{
+			BuildDefinition: BuildDefinition{
+				BuildType: BuildType,
+				ExternalParameters: ExternalParameters{
+					GOEXPERIMENT: o.GOEXPERIMENT,
+					BuildID:      o.BuildID,
+				},
+				ResolvedDependencies: deps,
+			},
+			RunDetails: RunDetails{
+				Builder: Builder{ID: o.BuilderID},
+				Metadata: Metadata{
+					InvocationID: o.InvocationID,
+				},
+			},
+		},
+	}
+	if !o.FinishedOn.IsZero() {
+		t := o.FinishedOn.UTC().Truncate(time.Second)
+		s.Predicate.RunDetails.Metadata.FinishedOn = &t
+	}
+	return s, nil
+}
+
+// Patches returns a resource for each "*.patch" file in dir, in the order they're applied.
+func Patches(dir string) ([]Resource, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.patch"))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(files)
+	sums, err := new(checksum.Engine).Files(files, checksum.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	patches := make([]Resource, 0, len(files))
+	for i, f := range files {
+		patches = append(patches, Resource{
+			Name:   "patches/" + filepath.Base(f),
+			Digest: digest(sums[i]),
+		})
+	}
+	return patches, nil
+}
+
+func digest(sums checksum.Sums) map[string]string {
+	d := make(map[string]string, len(sums))
+	for a, sum := range sums {
+		d[string(a)] = sum
+	}
+	return d
+}
+
+// matchesSubject checks that the file at path matches one of the subjects of s by name and by
+// every digest this package supports.
+func (s *Statement) matchesSubject(path string) (bool, error) {
+	name := filepath.Base(path)
+	for _, sub := range s.Subject {
+		if sub.Name != name {
+			continue
+		}
+		var algs []checksum.Algorithm
+		for a := range sub.Digest {
+			if alg, err := checksum.ParseAlgorithm(a); err == nil {
+				algs = append(algs, alg)
+			}
+		}
+		if len(algs) == 0 {
+			return false, nil
+		}
+		sums, err := new(checksum.Engine).Files([]string{path}, algs...)
+		if err != nil {
+			return false, err
+		}
+		for a, sum := range sums[0] {
+			if sub.Digest[string(a)] != sum {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,124 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provenance
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/go/_util/internal/signer"
+)
+
+func TestSignVerify(t *testing.T) {
+	dir := t.TempDir()
+	patchDir := filepath.Join(dir, "patches")
+	if err := os.Mkdir(patchDir, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"0002-b.patch", "0001-a.patch", "README.md"} {
+		if err := os.WriteFile(filepath.Join(patchDir, name), []byte(name), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	patches, err := Patches(patchDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patches) != 2 || patches[0].Name != "patches/0001-a.patch" || len(patches[0].Digest["sha256"]) != 64 {
+		t.Fatalf("patches = %v", patches)
+	}
+
+	archive := filepath.Join(dir, "go1.23.1-1.linux-amd64.tar.gz")
+	if err := os.WriteFile(archive, []byte("archive"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(archive, &Options{
+		BuilderID:       "local",
+		BuildID:         "dev",
+		GOEXPERIMENT:    "systemcrypto",
+		SourceURI:       "https://github.com/microsoft/go",
+		SourceCommit:    "0123456789abcdef0123456789abcdef01234567",
+		SubmoduleURI:    "https://github.com/golang/go",
+		SubmoduleCommit: "89abcdef0123456789abcdef0123456789abcdef",
+		Patches:         patches,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.Predicate.BuildDefinition.ResolvedDependencies); n != 4 {
+		t.Errorf("got %v resolved dependencies, want 4", n)
+	}
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := Sign(s, signer.NewKeySigner(priv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	attestation := archive + Ext
+	if err := env.Write(attestation); err != nil {
+		t.Fatal(err)
+	}
+	env, err = Read(attestation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := VerifyArchive(archive, env, []ed25519.PublicKey{otherPub, pub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Predicate.BuildDefinition.ExternalParameters.GOEXPERIMENT != "systemcrypto" {
+		t.Errorf("GOEXPERIMENT = %q", got.Predicate.BuildDefinition.ExternalParameters.GOEXPERIMENT)
+	}
+
+	if _, err := VerifyArchive(archive, env, []ed25519.PublicKey{otherPub}); err == nil {
+		t.Error("verified with untrusted key")
+	}
+	unsigned, err := Sign(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyArchive(archive, unsigned, []ed25519.PublicKey{pub}); err == nil {
+		t.Error("verified unsigned envelope")
+	}
+
+	// Changing the statement breaks the signature.
+	tampered := *got
+	tampered.Predicate.BuildDefinition.ExternalParameters.BuildID = "1234"
+	payload, err := json.Marshal(&tampered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tamperedEnv := *env
+	tamperedEnv.Payload = base64.StdEncoding.EncodeToString(payload)
+	if _, err := VerifyArchive(archive, &tamperedEnv, []ed25519.PublicKey{pub}); err == nil {
+		t.Error("verified tampered statement")
+	}
+
+	// Changing the archive breaks the subject digest.
+	if err := os.WriteFile(archive, []byte("changed"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyArchive(archive, env, []ed25519.PublicKey{pub}); err == nil {
+		t.Error("verified changed archive")
+	}
+	renamed := filepath.Join(dir, "other.tar.gz")
+	if err := os.WriteFile(renamed, []byte("archive"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyArchive(renamed, env, []ed25519.PublicKey{pub}); err == nil {
+		t.Error("verified archive with a different name")
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package signer signs metadata documents such as release metadata and provenance attestations.
+// The Signer interface lets the same document be signed with a local key during development or by
+// a command that uses a signing service in the official pipeline.
+package signer
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// Signer signs messages.
+type Signer interface {
+	// KeyID identifies the key that verifies the signatures.
+	KeyID() string
+	// Sign returns the signature of msg.
+	Sign(msg []byte) ([]byte, error)
+}
+
+// KeySigner is a Signer that uses a local Ed25519 private key.
+type KeySigner struct {
+	key ed25519.PrivateKey
+	id  string
+}
+
+// NewKeySigner returns a Signer that signs with key.
+func NewKeySigner(key ed25519.PrivateKey) *KeySigner {
+	return &KeySigner{
+		key: key,
+		id:  KeyID(key.Public().(ed25519.PublicKey)),
+	}
+}
+
+// LoadKeySigner returns a Signer that signs with the private key in the PEM file at path.
+func LoadKeySigner(path string) (*KeySigner, error) {
+	key, err := LoadPrivateKey(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeySigner(key), nil
+}
+
+func (s *KeySigner) KeyID() string { return s.id }
+
+func (s *KeySigner) Sign(msg []byte) ([]byte, error) {
+	return ed25519.Sign(s.key, msg), nil
+}
+
+// CommandSigner is a Signer that runs a command to sign each message, for example a tool that
+// calls a remote signing service. The command reads the message from stdin and writes the raw
+// signature to stdout.
+type CommandSigner struct {
+	// ID is the key ID of the key the command signs with.
+	ID string
+	// Args is the command line to run.
+	Args []string
+}
+
+func (s *CommandSigner) KeyID() string { return s.ID }
+
+func (s *CommandSigner) Sign(msg []byte) ([]byte, error) {
+	if len(s.Args) == 0 {
+		return nil, errors.New("no signing command")
+	}
+	cmd := exec.Command(s.Args[0], s.Args[1:]...)
+	cmd.Stdin = bytes.NewReader(msg)
+	cmd.Stderr = os.Stderr
+	sig, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("signing command %v failed: %v", s.Args, err)
+	}
+	if len(sig) == 0 {
+		return nil, fmt.Errorf("signing command %v returned no signature", s.Args)
+	}
+	return sig, nil
+}
+
+// KeyID returns the TUF key ID of pub: the hex SHA-256 of the canonical JSON of the key object.
+func KeyID(pub ed25519.PublicKey) string {
+	// The keys are in sorted order, and the hex string can't contain characters that need escaping,
+	// so this is canonical JSON.
+	key := `{"keytype":"ed25519","keyval":{"public":"` + hex.EncodeToString(pub) + `"},"scheme":"ed25519"}`
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateKey creates a new Ed25519 key pair, and writes the private key to privPath and the
+// public key to pubPath as PEM files.
+func GenerateKey(privPath, pubPath string) error {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0o666)
+}
+
+// LoadPrivateKey reads a PKCS #8 Ed25519 private key from the PEM file at path.
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	der, err := readPEM(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%v: not an Ed25519 private key", path)
+	}
+	return priv, nil
+}
+
+// LoadPublicKey reads a PKIX Ed25519 public key from the PEM file at path.
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	der, err := readPEM(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%v: not an Ed25519 public key", path)
+	}
+	return pub, nil
+}
+
+func readPEM(path, blockType string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New(path + ": no PEM data found")
+	}
+	if block.Type != blockType {
+		return nil, fmt.Errorf("%v: PEM block type is %q, want %q", path, block.Type, blockType)
+	}
+	return block.Bytes, nil
+}
@@ -0,0 +1,40 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package signer
+
+import (
+	"crypto/ed25519"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerateKey(t *testing.T) {
+	dir := t.TempDir()
+	privPath, pubPath := filepath.Join(dir, "test.key"), filepath.Join(dir, "test.pub")
+	if err := GenerateKey(privPath, pubPath); err != nil {
+		t.Fatal(err)
+	}
+	s, err := LoadKeySigner(privPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := LoadPublicKey(pubPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.KeyID() != KeyID(pub) {
+		t.Errorf("signer key ID %v, want %v", s.KeyID(), KeyID(pub))
+	}
+	sig, err := s.Sign([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ed25519.Verify(pub, []byte("hello"), sig) {
+		t.Error("signature doesn't verify")
+	}
+	if _, err := LoadPublicKey(privPath); err == nil {
+		t.Error("loaded private key file as public key")
+	}
+}
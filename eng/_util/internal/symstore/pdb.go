@@ -0,0 +1,186 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package symstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// msfMagic starts a PDB 7.0 (MSF 7.0) file.
+const msfMagic = "Microsoft C/C++ MSF 7.00\r\n\x1aDS\x00\x00\x00"
+
+const (
+	pdbInfoStream = 1
+	dbiStream     = 3
+)
+
+// msf reads the streams of a multi-stream file.
+type msf struct {
+	r         io.ReaderAt
+	blockSize uint32
+	numBlocks uint32
+	// sizes and blocks are the size and block numbers of each stream.
+	sizes  []uint32
+	blocks [][]uint32
+}
+
+// openMSF reads the stream directory of the MSF r, which is size bytes long. Sizes and block
+// numbers in the file are checked against the number of blocks before they're used, so a corrupt
+// file can't cause a large allocation.
+func openMSF(r io.ReaderAt, size int64) (*msf, error) {
+	h := make([]byte, 56)
+	if _, err := r.ReadAt(h, 0); err != nil {
+		return nil, fmt.Errorf("reading MSF header: %v", err)
+	}
+	if string(h[:32]) != msfMagic {
+		return nil, errors.New("not a PDB 7.0 file")
+	}
+	m := &msf{r: r, blockSize: binary.LittleEndian.Uint32(h[32:])}
+	switch m.blockSize {
+	case 512, 1024, 2048, 4096:
+	default:
+		return nil, fmt.Errorf("invalid MSF block size %v", m.blockSize)
+	}
+	m.numBlocks = binary.LittleEndian.Uint32(h[40:])
+	if int64(m.numBlocks)*int64(m.blockSize) > size {
+		return nil, fmt.Errorf("invalid MSF block count %v, file is only %v bytes", m.numBlocks, size)
+	}
+	dirSize := binary.LittleEndian.Uint32(h[44:])
+	blockMapAddr := binary.LittleEndian.Uint32(h[52:])
+	if blockMapAddr >= m.numBlocks {
+		return nil, fmt.Errorf("invalid MSF block map block %v", blockMapAddr)
+	}
+	// The block map is one block.
+	if m.blockCount(dirSize)*4 > int(m.blockSize) {
+		return nil, fmt.Errorf("invalid MSF stream directory size %v", dirSize)
+	}
+
+	// The block map lists the blocks of the stream directory.
+	dirBlocks, err := m.readUint32s(int64(blockMapAddr)*int64(m.blockSize), m.blockCount(dirSize))
+	if err != nil {
+		return nil, fmt.Errorf("reading MSF block map: %v", err)
+	}
+	dir, err := m.read(dirBlocks, dirSize)
+	if err != nil {
+		return nil, fmt.Errorf("reading MSF stream directory: %v", err)
+	}
+	u32 := func() (uint32, error) {
+		if len(dir) < 4 {
+			return 0, errors.New("truncated MSF stream directory")
+		}
+		v := binary.LittleEndian.Uint32(dir)
+		dir = dir[4:]
+		return v, nil
+	}
+	n, err := u32()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(n)*4 > uint64(len(dir)) {
+		return nil, fmt.Errorf("invalid MSF stream count %v", n)
+	}
+	m.sizes = make([]uint32, n)
+	for i := range m.sizes {
+		if m.sizes[i], err = u32(); err != nil {
+			return nil, err
+		}
+		if m.sizes[i] != 0xFFFFFFFF && m.blockCount(m.sizes[i]) > int(m.numBlocks) {
+			return nil, fmt.Errorf("invalid MSF stream %v size %v", i, m.sizes[i])
+		}
+	}
+	m.blocks = make([][]uint32, n)
+	for i, size := range m.sizes {
+		// Deleted streams have size 0xFFFFFFFF and no blocks.
+		if size == 0xFFFFFFFF {
+			continue
+		}
+		for range m.blockCount(size) {
+			b, err := u32()
+			if err != nil {
+				return nil, err
+			}
+			m.blocks[i] = append(m.blocks[i], b)
+		}
+	}
+	return m, nil
+}
+
+func (m *msf) blockCount(size uint32) int {
+	return int((uint64(size) + uint64(m.blockSize) - 1) / uint64(m.blockSize))
+}
+
+func (m *msf) readUint32s(offset int64, n int) ([]uint32, error) {
+	b := make([]byte, n*4)
+	if _, err := m.r.ReadAt(b, offset); err != nil {
+		return nil, err
+	}
+	vs := make([]uint32, n)
+	for i := range vs {
+		vs[i] = binary.LittleEndian.Uint32(b[i*4:])
+	}
+	return vs, nil
+}
+
+// read reads size bytes from the given blocks.
+func (m *msf) read(blocks []uint32, size uint32) ([]byte, error) {
+	if len(blocks) != m.blockCount(size) {
+		return nil, fmt.Errorf("%v MSF blocks for %v bytes", len(blocks), size)
+	}
+	b := make([]byte, 0, size)
+	for _, block := range blocks {
+		if block >= m.numBlocks {
+			return nil, fmt.Errorf("invalid MSF block %v", block)
+		}
+		n := min(m.blockSize, size-uint32(len(b)))
+		chunk := make([]byte, n)
+		if _, err := m.r.ReadAt(chunk, int64(block)*int64(m.blockSize)); err != nil {
+			return nil, err
+		}
+		b = append(b, chunk...)
+	}
+	return b, nil
+}
+
+// stream returns the content of stream i, or nil if it doesn't exist.
+func (m *msf) stream(i int) ([]byte, error) {
+	if i >= len(m.sizes) || m.sizes[i] == 0xFFFFFFFF {
+		return nil, nil
+	}
+	return m.read(m.blocks[i], m.sizes[i])
+}
+
+// ReadPDBKey reads the GUID and age that identify the PDB file r, which is size bytes long. The GUID is from the PDB info
+// stream. The age is from the DBI stream if there is one, because that's the age the linker
+// writes to the PE file, otherwise from the PDB info stream.
+func ReadPDBKey(r io.ReaderAt, size int64) (Key, error) {
+	var k Key
+	m, err := openMSF(r, size)
+	if err != nil {
+		return k, err
+	}
+	info, err := m.stream(pdbInfoStream)
+	if err != nil {
+		return k, fmt.Errorf("reading PDB info stream: %v", err)
+	}
+	// Version, Signature, Age, GUID.
+	if len(info) < 28 {
+		return k, errors.New("PDB info stream is missing or truncated")
+	}
+	k.Age = binary.LittleEndian.Uint32(info[8:])
+	copy(k.GUID[:], info[12:28])
+
+	dbi, err := m.stream(dbiStream)
+	if err != nil {
+		return k, fmt.Errorf("reading DBI stream: %v", err)
+	}
+	// VersionSignature, VersionHeader, Age.
+	if len(dbi) >= 12 {
+		k.Age = binary.LittleEndian.Uint32(dbi[8:])
+	}
+	return k, nil
+}
@@ -0,0 +1,62 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package symstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// corruptPDBs returns copies of testPDB with one size or block number out of range.
+func corruptPDBs() map[string][]byte {
+	const blockSize = 512
+	corrupt := func(offset int, v uint32) []byte {
+		b := testPDB(testKey)
+		binary.LittleEndian.PutUint32(b[offset:], v)
+		return b
+	}
+	return map[string][]byte{
+		"block count":          corrupt(40, 0xFFFFFFFF),
+		"directory size":       corrupt(44, 0xFFFFFFFF),
+		"block map block":      corrupt(52, 7),
+		"stream size":          corrupt(5*blockSize+8, 0xFFFFFFF0),
+		"stream block":         corrupt(5*blockSize+20, 100),
+		"directory block":      corrupt(6*blockSize, 100),
+		"stream count":         corrupt(5*blockSize, 0x40000000),
+		"stream size no block": corrupt(5*blockSize+8, 1000),
+	}
+}
+
+func TestReadPDBKey(t *testing.T) {
+	b := testPDB(testKey)
+	k, err := ReadPDBKey(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k != testKey {
+		t.Errorf("ReadPDBKey = %v, want %v", k, testKey)
+	}
+	if _, err := ReadPDBKey(bytes.NewReader(b), int64(len(b)/2)); err == nil {
+		t.Error("ReadPDBKey succeeded with a truncated file")
+	}
+	for name, b := range corruptPDBs() {
+		if k, err := ReadPDBKey(bytes.NewReader(b), int64(len(b))); err == nil {
+			t.Errorf("ReadPDBKey with invalid %v = %v, want error", name, k)
+		}
+	}
+}
+
+func FuzzReadPDBKey(f *testing.F) {
+	b := testPDB(testKey)
+	f.Add(b)
+	f.Add(b[:len(b)/2])
+	for _, b := range corruptPDBs() {
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, _ = ReadPDBKey(bytes.NewReader(data), int64(len(data)))
+	})
+}
@@ -0,0 +1,116 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package symstore
+
+import (
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	imageDirectoryEntryDebug = 6
+	imageDebugTypeCodeView   = 2
+	debugDirectorySize       = 28
+)
+
+// ErrNoCodeView is returned when a PE file has no CodeView debug information, so it doesn't
+// refer to a PDB.
+var ErrNoCodeView = errors.New("no CodeView debug directory entry")
+
+// CodeView is the PDB reference in the debug directory of a PE file.
+type CodeView struct {
+	Key Key
+	// PDBPath is the PDB path recorded when the PE file was linked, often an absolute path on the
+	// build machine.
+	PDBPath string
+}
+
+// PDBName returns the base name of PDBPath, which is the name the debugger looks up in a symbol
+// store.
+func (c *CodeView) PDBName() string {
+	return c.PDBPath[strings.LastIndexAny(c.PDBPath, `/\`)+1:]
+}
+
+// ReadCodeView reads the RSDS CodeView record from the debug directory of the PE file r.
+func ReadCodeView(r io.ReaderAt) (*CodeView, error) {
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// debug/pe accepts more than the 16 data directories the header struct has room for, and
+	// only keeps the first 16.
+	var dirs []pe.DataDirectory
+	switch h := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		dirs = h.DataDirectory[:min(h.NumberOfRvaAndSizes, uint32(len(h.DataDirectory)))]
+	case *pe.OptionalHeader64:
+		dirs = h.DataDirectory[:min(h.NumberOfRvaAndSizes, uint32(len(h.DataDirectory)))]
+	default:
+		return nil, errors.New("PE file has no optional header")
+	}
+	if len(dirs) <= imageDirectoryEntryDebug || dirs[imageDirectoryEntryDebug].Size == 0 {
+		return nil, ErrNoCodeView
+	}
+	debugDir := dirs[imageDirectoryEntryDebug]
+	// A debug directory has a few entries. Don't trust a corrupt size to allocate the buffer.
+	if debugDir.Size > 64*1024 {
+		return nil, fmt.Errorf("invalid debug directory size %v", debugDir.Size)
+	}
+	offset, err := rvaToOffset(f, debugDir.VirtualAddress)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, debugDir.Size)
+	if _, err := r.ReadAt(b, offset); err != nil {
+		return nil, fmt.Errorf("reading debug directory: %v", err)
+	}
+	for ; len(b) >= debugDirectorySize; b = b[debugDirectorySize:] {
+		if binary.LittleEndian.Uint32(b[12:]) != imageDebugTypeCodeView {
+			continue
+		}
+		size := binary.LittleEndian.Uint32(b[16:])
+		pointer := binary.LittleEndian.Uint32(b[24:])
+		return readRSDS(r, int64(pointer), size)
+	}
+	return nil, ErrNoCodeView
+}
+
+func rvaToOffset(f *pe.File, rva uint32) (int64, error) {
+	for _, s := range f.Sections {
+		if rva >= s.VirtualAddress && rva < s.VirtualAddress+max(s.VirtualSize, s.Size) {
+			return int64(rva - s.VirtualAddress + s.Offset), nil
+		}
+	}
+	return 0, fmt.Errorf("RVA %#x is not in any section", rva)
+}
+
+// readRSDS reads a CodeView PDB 7.0 record: "RSDS", the GUID, the age, and the PDB path.
+func readRSDS(r io.ReaderAt, offset int64, size uint32) (*CodeView, error) {
+	if size < 24 || size > 64*1024 {
+		return nil, fmt.Errorf("invalid CodeView record size %v", size)
+	}
+	b := make([]byte, size)
+	if _, err := r.ReadAt(b, offset); err != nil {
+		return nil, fmt.Errorf("reading CodeView record: %v", err)
+	}
+	if string(b[:4]) != "RSDS" {
+		return nil, fmt.Errorf("unsupported CodeView signature %q", b[:4])
+	}
+	var c CodeView
+	copy(c.Key.GUID[:], b[4:20])
+	c.Key.Age = binary.LittleEndian.Uint32(b[20:])
+	path, _, _ := strings.Cut(string(b[24:]), "\x00")
+	if path == "" {
+		return nil, errors.New("CodeView record has no PDB path")
+	}
+	c.PDBPath = path
+	return &c, nil
+}
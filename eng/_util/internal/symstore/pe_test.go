@@ -0,0 +1,59 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package symstore
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestReadCodeViewDataDirectories(t *testing.T) {
+	const pdbPath = `C:\go.pdb`
+	tests := []struct {
+		dirs    uint32
+		wantErr error
+	}{
+		{16, nil},
+		// More data directories than the header struct has room for.
+		{17, nil},
+		{20, nil},
+		{imageDirectoryEntryDebug + 1, nil},
+		// The list ends before the debug directory.
+		{imageDirectoryEntryDebug, ErrNoCodeView},
+		{0, ErrNoCodeView},
+	}
+	for _, tt := range tests {
+		c, err := ReadCodeView(bytes.NewReader(testPEDirs(testKey, pdbPath, tt.dirs)))
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ReadCodeView with %v data directories: error %v, want %v", tt.dirs, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ReadCodeView with %v data directories: %v", tt.dirs, err)
+			continue
+		}
+		if c.Key != testKey || c.PDBPath != pdbPath {
+			t.Errorf("ReadCodeView with %v data directories = %+v, want %v %q", tt.dirs, c, testKey, pdbPath)
+		}
+	}
+}
+
+func FuzzReadCodeView(f *testing.F) {
+	for _, dirs := range []uint32{0, imageDirectoryEntryDebug, 16, 17} {
+		b := testPEDirs(testKey, `C:\go.pdb`, dirs)
+		f.Add(b)
+		// Truncated files exercise reads past the end of the file.
+		f.Add(b[:len(b)/2])
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		c, err := ReadCodeView(bytes.NewReader(data))
+		if err == nil && c.PDBPath == "" {
+			t.Error("ReadCodeView succeeded with an empty PDB path")
+		}
+	})
+}
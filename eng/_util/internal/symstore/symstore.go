@@ -0,0 +1,236 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package symstore lays out PDB files in a symbol store directory, "<name>/<GUID+age>/<name>",
+// where a debugger finds the PDB of a PE file using the PDB reference in the PE file's debug
+// directory. The PE and PDB files are read in pure Go, without Windows tools.
+package symstore
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/microsoft/go/_util/internal/archive"
+	"github.com/microsoft/go/_util/internal/checksum"
+)
+
+// IndexFilename is the name of the index in the root of the store.
+const IndexFilename = "index.json"
+
+// Key identifies a PDB file and the PE files built with it.
+type Key struct {
+	GUID [16]byte
+	Age  uint32
+}
+
+// String returns the key as used in a symbol store path: the GUID as uppercase hex without
+// dashes, in the usual mixed-endian GUID order, followed by the age in uppercase hex.
+func (k Key) String() string {
+	g := k.GUID
+	return fmt.Sprintf("%08X%04X%04X%X%X",
+		binary.LittleEndian.Uint32(g[0:4]),
+		binary.LittleEndian.Uint16(g[4:6]),
+		binary.LittleEndian.Uint16(g[6:8]),
+		g[8:16],
+		k.Age)
+}
+
+// Index lists the PDBs in a symbol store.
+type Index struct {
+	Entries []*Entry `json:"entries"`
+}
+
+// Entry is a PDB in the store.
+type Entry struct {
+	// Name is the PDB name the debugger looks up.
+	Name string `json:"name"`
+	Key  string `json:"key"`
+	// Path is the slash-separated path of the PDB in the store.
+	Path string `json:"path"`
+	// Binary is the name of the PE file that refers to the PDB, if known.
+	Binary string `json:"binary,omitempty"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// reference is a PE file's reference to a PDB.
+type reference struct {
+	pdbName string
+	binary  string
+}
+
+// Build copies each PDB in pdbs into a symbol store at dir and writes the index.
+//
+// binaries are PE files or zip archives that contain PE files. If any are given, each PDB must be
+// referenced by one of the PE files, and is stored under the PDB name the PE file refers to.
+// Otherwise, PDBs are stored under their own file name.
+//
+// dir must not exist or be empty, so the store only contains the given PDBs.
+func Build(dir string, pdbs, binaries []string) (*Index, error) {
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return nil, fmt.Errorf("symbol store dir %q is not empty", dir)
+	}
+	refs := make(map[Key]reference)
+	for _, b := range binaries {
+		if err := readReferences(b, refs); err != nil {
+			return nil, err
+		}
+	}
+
+	idx := &Index{}
+	seen := make(map[string]string)
+	for _, p := range pdbs {
+		key, err := readPDBFileKey(p)
+		if err != nil {
+			return nil, err
+		}
+		e := &Entry{Name: filepath.Base(p), Key: key.String()}
+		if len(binaries) > 0 {
+			ref, ok := refs[key]
+			if !ok {
+				return nil, fmt.Errorf("%v: no binary refers to PDB with key %v", p, e.Key)
+			}
+			e.Name, e.Binary = ref.pdbName, ref.binary
+		}
+		e.Path = path.Join(e.Name, e.Key, e.Name)
+		if other, ok := seen[e.Path]; ok {
+			return nil, fmt.Errorf("%v and %v have the same store path %v", other, p, e.Path)
+		}
+		seen[e.Path] = p
+		if e.Size, e.SHA256, err = copyFile(filepath.Join(dir, filepath.FromSlash(e.Path)), p); err != nil {
+			return nil, err
+		}
+		idx.Entries = append(idx.Entries, e)
+	}
+	slices.SortFunc(idx.Entries, func(a, b *Entry) int { return strings.Compare(a.Path, b.Path) })
+
+	b, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, IndexFilename), append(b, '\n'), 0o666); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+func readPDBFileKey(p string) (Key, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return Key{}, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return Key{}, err
+	}
+	k, err := ReadPDBKey(f, fi.Size())
+	if err != nil {
+		return Key{}, fmt.Errorf("%v: %v", p, err)
+	}
+	return k, nil
+}
+
+// readReferences adds the PDB references of the PE file or zip archive at p to refs.
+func readReferences(p string, refs map[Key]reference) error {
+	add := func(name string, r io.ReaderAt) error {
+		cv, err := ReadCodeView(r)
+		if errors.Is(err, ErrNoCodeView) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %v", name, err)
+		}
+		refs[cv.Key] = reference{pdbName: cv.PDBName(), binary: path.Base(name)}
+		return nil
+	}
+	if !strings.HasSuffix(p, ".zip") {
+		return archive.WithFileOpen(p, func(f *os.File) error {
+			return add(p, f)
+		})
+	}
+	a, err := archive.New(p)
+	if err != nil {
+		return err
+	}
+	return a.Walk(func(e *archive.Entry, r io.Reader) error {
+		if r == nil || !(strings.HasSuffix(e.Name, ".exe") || strings.HasSuffix(e.Name, ".dll")) {
+			return nil
+		}
+		// debug/pe needs an io.ReaderAt.
+		content, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return add(e.Name, bytes.NewReader(content))
+	})
+}
+
+// copyFile copies src to dst, returning the size and SHA-256 of the content.
+func copyFile(dst, src string) (int64, string, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, "", err
+	}
+	h := checksum.NewHasher(checksum.SHA256)
+	if err := archive.CopyToFile(dst, io.TeeReader(f, h)); err != nil {
+		return 0, "", err
+	}
+	return info.Size(), h.Sums()[checksum.SHA256], nil
+}
+
+// WriteBundle creates a tar at dst with the content of the store at dir, for signing and
+// publishing as one file. The bundle isn't a zip or tar.gz, so tools that treat each of those as
+// a Go distribution archive ignore it.
+func WriteBundle(dst, dir string) error {
+	return archive.WithFileCreate(dst, func(f *os.File) error {
+		tw := tar.NewWriter(f)
+		if err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(dir, p)
+			if err != nil {
+				return err
+			}
+			return archive.WithFileOpen(p, func(f *os.File) error {
+				info, err := f.Stat()
+				if err != nil {
+					return err
+				}
+				if err := tw.WriteHeader(&tar.Header{
+					Name:     filepath.ToSlash(rel),
+					Mode:     0o644,
+					Size:     info.Size(),
+					ModTime:  info.ModTime(),
+					Typeflag: tar.TypeReg,
+					Format:   tar.FormatPAX,
+				}); err != nil {
+					return err
+				}
+				_, err = io.Copy(tw, f)
+				return err
+			})
+		}); err != nil {
+			return err
+		}
+		return tw.Close()
+	})
+}
@@ -0,0 +1,230 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package symstore
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/go/_util/internal/archive"
+)
+
+var testKey = Key{
+	GUID: [16]byte{0x33, 0x22, 0x11, 0x00, 0x55, 0x44, 0x77, 0x66, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+	Age:  0x1a,
+}
+
+func TestKeyString(t *testing.T) {
+	if got, want := testKey.String(), "00112233445566778899AABBCCDDEEFF1A"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	dir := t.TempDir()
+	pdbPath := filepath.Join(dir, "go.exe.windows-amd64.pdb")
+	if err := os.WriteFile(pdbPath, testPDB(testKey), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	exe := testPE(testKey, `D:\a\_work\1\s\eng\artifacts\symbols\go.exe.windows-amd64.pdb`)
+
+	cv, err := ReadCodeView(bytes.NewReader(exe))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cv.Key != testKey || cv.PDBName() != "go.exe.windows-amd64.pdb" {
+		t.Errorf("CodeView = %v %q", cv.Key, cv.PDBName())
+	}
+
+	// Find the binary inside a zip archive.
+	zipPath := filepath.Join(dir, "go1.23.1-1.windows-amd64.zip")
+	if err := archive.WithZipCreate(zipPath, func(zw *zip.Writer) error {
+		w, err := zw.Create("go/bin/go.exe")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(exe)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	storeDir := filepath.Join(dir, "store")
+	idx, err := Build(storeDir, []string{pdbPath}, []string{zipPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "go.exe.windows-amd64.pdb/00112233445566778899AABBCCDDEEFF1A/go.exe.windows-amd64.pdb"
+	if len(idx.Entries) != 1 || idx.Entries[0].Path != want || idx.Entries[0].Binary != "go.exe" {
+		t.Fatalf("entries = %+v", idx.Entries)
+	}
+	if _, err := os.Stat(filepath.Join(storeDir, filepath.FromSlash(want))); err != nil {
+		t.Error(err)
+	}
+	b, err := os.ReadFile(filepath.Join(storeDir, IndexFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written Index
+	if err := json.Unmarshal(b, &written); err != nil {
+		t.Fatal(err)
+	}
+	if len(written.Entries) != 1 || written.Entries[0].Size != int64(len(testPDB(testKey))) {
+		t.Errorf("index = %s", b)
+	}
+
+	bundle := filepath.Join(dir, "go1.23.1-1.windows-amd64.symbols.tar")
+	if err := WriteBundle(bundle, storeDir); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	if err := archive.WithFileOpen(bundle, func(f *os.File) error {
+		return archive.EachTarEntry(tar.NewReader(f), func(h *tar.Header, _ io.Reader) error {
+			names = append(names, h.Name)
+			return nil
+		})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != want || names[1] != IndexFilename {
+		t.Errorf("bundle names = %v", names)
+	}
+
+	// A PDB that no binary refers to is an error.
+	other := testKey
+	other.Age++
+	otherPath := filepath.Join(dir, "other.pdb")
+	if err := os.WriteFile(otherPath, testPDB(other), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Build(filepath.Join(dir, "store2"), []string{otherPath}, []string{zipPath}); err == nil {
+		t.Error("Build succeeded with an unreferenced PDB")
+	}
+	// Without binaries, the PDB's own name is used.
+	idx, err = Build(filepath.Join(dir, "store3"), []string{otherPath}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := idx.Entries[0].Path; got != "other.pdb/00112233445566778899AABBCCDDEEFF1B/other.pdb" {
+		t.Errorf("path = %v", got)
+	}
+}
+
+// testPDB returns a minimal MSF 7.0 file with a PDB info stream and a DBI stream header.
+func testPDB(k Key) []byte {
+	const blockSize = 512
+	blocks := make([][]byte, 7)
+	for i := range blocks {
+		blocks[i] = make([]byte, blockSize)
+	}
+	le := binary.LittleEndian
+	// Stream 1, PDB info: Version, Signature, Age, GUID. The age here is deliberately stale: the
+	// DBI stream's age is the one that counts.
+	info := blocks[3]
+	le.PutUint32(info[0:], 20000404)
+	le.PutUint32(info[8:], 1)
+	copy(info[12:], k.GUID[:])
+	// Stream 3, DBI: VersionSignature, VersionHeader, Age.
+	dbi := blocks[4]
+	le.PutUint32(dbi[0:], 0xFFFFFFFF)
+	le.PutUint32(dbi[4:], 19990903)
+	le.PutUint32(dbi[8:], k.Age)
+	// The stream directory: 4 streams with sizes, then the blocks of each stream.
+	dir := []uint32{4, 0, 28, 0xFFFFFFFF, 12, 3, 4}
+	for i, v := range dir {
+		le.PutUint32(blocks[5][i*4:], v)
+	}
+	// The block map lists the directory's block.
+	le.PutUint32(blocks[6], 5)
+
+	sb := blocks[0]
+	copy(sb, msfMagic)
+	le.PutUint32(sb[32:], blockSize)
+	le.PutUint32(sb[36:], 1)
+	le.PutUint32(sb[40:], uint32(len(blocks)))
+	le.PutUint32(sb[44:], uint32(len(dir)*4))
+	le.PutUint32(sb[52:], 6)
+	return bytes.Join(blocks, nil)
+}
+
+// testPE returns a minimal PE32+ file with a debug directory that refers to a PDB.
+func testPE(k Key, pdbPath string) []byte {
+	return testPEDirs(k, pdbPath, 16)
+}
+
+// testPEDirs is like testPE, but the optional header has dirs data directories. The standard
+// number is 16: a smaller number truncates the list, and a larger one adds empty directories.
+func testPEDirs(k Key, pdbPath string, dirs uint32) []byte {
+	const (
+		sectionRVA    = 0x1000
+		sectionOffset = 0x200
+		sectionSize   = 0x200
+	)
+	var b bytes.Buffer
+	le := binary.LittleEndian
+	dos := make([]byte, 0x40)
+	copy(dos, "MZ")
+	le.PutUint32(dos[0x3c:], 0x40)
+	b.Write(dos)
+	b.WriteString("PE\x00\x00")
+	write := func(v any) {
+		if err := binary.Write(&b, le, v); err != nil {
+			panic(err)
+		}
+	}
+	// The PE32+ optional header is 112 bytes followed by the data directories.
+	ohSize := 112 + 8*int(dirs)
+	write(pe.FileHeader{
+		Machine:              pe.IMAGE_FILE_MACHINE_AMD64,
+		NumberOfSections:     1,
+		SizeOfOptionalHeader: uint16(ohSize),
+		Characteristics:      pe.IMAGE_FILE_EXECUTABLE_IMAGE,
+	})
+	rsds := append([]byte("RSDS"), k.GUID[:]...)
+	rsds = le.AppendUint32(rsds, k.Age)
+	rsds = append(append(rsds, pdbPath...), 0)
+	oh := pe.OptionalHeader64{
+		Magic:               0x20b,
+		SectionAlignment:    0x1000,
+		FileAlignment:       0x200,
+		SizeOfImage:         0x2000,
+		SizeOfHeaders:       0x200,
+		NumberOfRvaAndSizes: dirs,
+	}
+	oh.DataDirectory[imageDirectoryEntryDebug] = pe.DataDirectory{VirtualAddress: sectionRVA, Size: debugDirectorySize}
+	var ohb bytes.Buffer
+	if err := binary.Write(&ohb, le, oh); err != nil {
+		panic(err)
+	}
+	ohb.Write(make([]byte, max(0, ohSize-ohb.Len())))
+	b.Write(ohb.Bytes()[:ohSize])
+	var name [8]uint8
+	copy(name[:], ".rdata")
+	write(pe.SectionHeader32{
+		Name:             name,
+		VirtualSize:      sectionSize,
+		VirtualAddress:   sectionRVA,
+		SizeOfRawData:    sectionSize,
+		PointerToRawData: sectionOffset,
+	})
+	b.Write(make([]byte, sectionOffset-b.Len()))
+
+	section := make([]byte, sectionSize)
+	le.PutUint32(section[12:], imageDebugTypeCodeView)
+	le.PutUint32(section[16:], uint32(len(rsds)))
+	le.PutUint32(section[20:], sectionRVA+debugDirectorySize)
+	le.PutUint32(section[24:], sectionOffset+debugDirectorySize)
+	copy(section[debugDirectorySize:], rsds)
+	b.Write(section)
+	return b.Bytes()
+}
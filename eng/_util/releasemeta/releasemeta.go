@@ -0,0 +1,235 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package releasemeta creates and verifies signed release metadata: a versioned document that
+// lists every file in a release with its length and hashes, and an expiry. The format is modeled
+// on TUF targets metadata.
+//
+// The aka.ms links to the latest release may be updated between the download of a file and the
+// download of its checksum file. An installer that downloads the metadata first can check every
+// file it downloads against one consistent, signed snapshot of the release instead.
+package releasemeta
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/microsoft/go/_util/internal/checksum"
+	"github.com/microsoft/go/_util/internal/signer"
+)
+
+// SpecVersion is the TUF specification version the metadata is modeled on.
+const SpecVersion = "1.0.31"
+
+// Filename is the conventional name of the latest release metadata file. The file is also
+// published as "<version>.targets.json", a consistent snapshot that is never updated.
+const Filename = "targets.json"
+
+// VersionedFilename returns the name of the consistent snapshot of the given metadata version.
+func VersionedFilename(version int) string {
+	return strconv.Itoa(version) + "." + Filename
+}
+
+// DefaultAlgorithms are the hash algorithms included for each file by default.
+var DefaultAlgorithms = []checksum.Algorithm{checksum.SHA256, checksum.SHA512}
+
+// Metadata is a signed release metadata document.
+type Metadata struct {
+	Signed     Targets     `json:"signed"`
+	Signatures []Signature `json:"signatures"`
+}
+
+// Targets is the signed part of the metadata.
+type Targets struct {
+	Type        string `json:"_type"`
+	SpecVersion string `json:"spec_version"`
+	// Version increases each time metadata is published, so clients can reject an older
+	// document.
+	Version int `json:"version"`
+	// Expires is when clients stop trusting the document.
+	Expires time.Time `json:"expires"`
+	// Targets maps each file name to its description.
+	Targets map[string]*Target `json:"targets"`
+}
+
+// Target describes one file.
+type Target struct {
+	Length int64 `json:"length"`
+	// Hashes maps each hash algorithm name, as used by the checksum package, to the hex hash.
+	Hashes map[string]string `json:"hashes"`
+}
+
+// Signature is a signature of the canonical JSON of the signed part of the metadata.
+type Signature struct {
+	KeyID string `json:"keyid"`
+	// Sig is the hex signature.
+	Sig string `json:"sig"`
+}
+
+// New creates unsigned metadata for files. Each file is listed by its base name, so the names
+// must be unique. The files are hashed concurrently with each of algs, or DefaultAlgorithms if
+// none are given.
+func New(files []string, version int, expires time.Time, algs ...checksum.Algorithm) (*Targets, error) {
+	if len(algs) == 0 {
+		algs = DefaultAlgorithms
+	}
+	if version < 1 {
+		return nil, fmt.Errorf("metadata version must be at least 1, got %v", version)
+	}
+	t := &Targets{
+		Type:        "targets",
+		SpecVersion: SpecVersion,
+		Version:     version,
+		Expires:     expires.UTC().Truncate(time.Second),
+		Targets:     make(map[string]*Target, len(files)),
+	}
+	var e checksum.Engine
+	sums, err := e.Files(files, algs...)
+	if err != nil {
+		return nil, err
+	}
+	for i, f := range files {
+		name := filepath.Base(f)
+		if _, ok := t.Targets[name]; ok {
+			return nil, fmt.Errorf("duplicate file name %q", name)
+		}
+		info, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		target := &Target{Length: info.Size(), Hashes: make(map[string]string, len(algs))}
+		for a, sum := range sums[i] {
+			target.Hashes[string(a)] = sum
+		}
+		t.Targets[name] = target
+	}
+	return t, nil
+}
+
+// Sign signs t with each signer.
+func Sign(t *Targets, signers ...signer.Signer) (*Metadata, error) {
+	if len(signers) == 0 {
+		return nil, errors.New("no signers")
+	}
+	msg, err := canonicalJSON(t)
+	if err != nil {
+		return nil, err
+	}
+	m := &Metadata{Signed: *t}
+	for _, s := range signers {
+		sig, err := s.Sign(msg)
+		if err != nil {
+			return nil, fmt.Errorf("signing with key %v: %v", s.KeyID(), err)
+		}
+		m.Signatures = append(m.Signatures, Signature{KeyID: s.KeyID(), Sig: hex.EncodeToString(sig)})
+	}
+	return m, nil
+}
+
+// Write writes m to dir as Filename and as the versioned snapshot.
+func (m *Metadata) Write(dir string) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	for _, name := range []string{Filename, VersionedFilename(m.Signed.Version)} {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, b, 0o666); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote release metadata %q with %v files\n", p, len(m.Signed.Targets))
+	}
+	return nil
+}
+
+// canonicalJSON encodes v as canonical JSON, as used by TUF: object keys are sorted, there's no
+// insignificant whitespace, strings only escape '"' and '\', and numbers must be integers.
+func canonicalJSON(v any) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return canonicalize(b)
+}
+
+// canonicalize re-encodes the JSON document b as canonical JSON.
+func canonicalize(b []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var generic any
+	if err := d.Decode(&generic); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := writeCanonical(&buf, generic); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeCanonical(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case json.Number:
+		if _, err := strconv.ParseInt(string(v), 10, 64); err != nil {
+			return fmt.Errorf("canonical JSON only allows integers, got %v", v)
+		}
+		buf.WriteString(string(v))
+	case string:
+		buf.WriteByte('"')
+		for i := 0; i < len(v); i++ {
+			if v[i] == '"' || v[i] == '\\' {
+				buf.WriteByte('\\')
+			}
+			buf.WriteByte(v[i])
+		}
+		buf.WriteByte('"')
+	case []any:
+		buf.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonical(buf, e); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonical(buf, k); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := writeCanonical(buf, v[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("unexpected JSON value type %T", v)
+	}
+	return nil
+}
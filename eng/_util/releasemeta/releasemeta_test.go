@@ -0,0 +1,169 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package releasemeta
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/microsoft/go/_util/internal/checksum"
+	"github.com/microsoft/go/_util/internal/signer"
+)
+
+func TestSignVerify(t *testing.T) {
+	dir := t.TempDir()
+	var files []string
+	for _, name := range []string{"go1.23.1-1.linux-amd64.tar.gz", "go1.23.1-1.windows-amd64.zip"} {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(name), 0o666); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, p)
+	}
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, otherPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	targets, err := New(files, 3, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Sign(targets, signer.NewKeySigner(priv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Write(dir); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, Filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versioned, err := os.ReadFile(filepath.Join(dir, "3.targets.json")); err != nil || !bytes.Equal(versioned, data) {
+		t.Errorf("versioned snapshot differs: %v", err)
+	}
+
+	v := &Verifier{Keys: []ed25519.PublicKey{pub}, Now: now}
+	got, err := v.Verify(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != 3 || len(got.Targets) != 2 {
+		t.Errorf("got version %v with %v targets, want 3 with 2", got.Version, len(got.Targets))
+	}
+	for _, f := range files {
+		if err := got.VerifyFile(filepath.Base(f), f); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := got.VerifyReader(filepath.Base(files[0]), strings.NewReader("changed")); err == nil {
+		t.Error("changed file verified")
+	}
+	if err := got.VerifyReader(filepath.Base(files[0]), strings.NewReader(filepath.Base(files[0])+"x")); err == nil {
+		t.Error("longer file verified")
+	}
+	if err := got.VerifyReader("other.zip", strings.NewReader("")); !errors.Is(err, ErrUnknownTarget) {
+		t.Errorf("unknown file error = %v, want ErrUnknownTarget", err)
+	}
+
+	// Reformatting the document doesn't break the signature, but changing it does.
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(compact.Bytes()); err != nil {
+		t.Errorf("compacted document: %v", err)
+	}
+	tampered := bytes.Replace(data, []byte(`"version": 3`), []byte(`"version": 4`), 1)
+	if _, err := v.Verify(tampered); err == nil {
+		t.Error("tampered document verified")
+	}
+
+	tests := []struct {
+		name string
+		v    *Verifier
+		want error
+	}{
+		{"untrusted key", &Verifier{Keys: []ed25519.PublicKey{otherPub}, Now: now}, nil},
+		{"threshold", &Verifier{Keys: []ed25519.PublicKey{pub, otherPub}, Threshold: 2, Now: now}, nil},
+		{"expired", &Verifier{Keys: []ed25519.PublicKey{pub}, Now: now.Add(2 * time.Hour)}, ErrExpired},
+		{"rollback", &Verifier{Keys: []ed25519.PublicKey{pub}, MinVersion: 4, Now: now}, ErrRollback},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.v.Verify(data)
+			if err == nil {
+				t.Fatal("verified")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	m, err = Sign(targets, signer.NewKeySigner(priv), signer.NewKeySigner(otherPriv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tests[1].v.Verify(data); err != nil {
+		t.Errorf("two signatures: %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "a.zip")
+	if err := os.WriteFile(p, []byte("a"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	targets, err := New([]string{p}, 1, time.Now(), checksum.BLAKE2b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := checksum.File(p, checksum.BLAKE2b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := targets.Targets["a.zip"]; got.Length != 1 || len(got.Hashes) != 1 || got.Hashes["blake2b"] != want {
+		t.Errorf("target = %+v", got)
+	}
+	if _, err := New([]string{p, p}, 1, time.Now()); err == nil {
+		t.Error("duplicate names accepted")
+	}
+	if _, err := New([]string{p}, 0, time.Now()); err == nil {
+		t.Error("version 0 accepted")
+	}
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	got, err := canonicalize([]byte(`{ "b": [1, "x\"\\<y>\n"], "a": {"d": null, "c": true} }`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "{\"a\":{\"c\":true,\"d\":null},\"b\":[1,\"x\\\"\\\\<y>\n\"]}"
+	if string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if _, err := canonicalize([]byte(`{"a": 1.5}`)); err == nil {
+		t.Error("float accepted")
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright (c) Microsoft Corporation.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package releasemeta
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/microsoft/go/_util/internal/checksum"
+	"github.com/microsoft/go/_util/internal/signer"
+)
+
+var (
+	// ErrExpired is returned when the metadata has expired.
+	ErrExpired = errors.New("release metadata has expired")
+	// ErrRollback is returned when the metadata is older than the minimum version.
+	ErrRollback = errors.New("release metadata version is older than the minimum version")
+	// ErrUnknownTarget is returned when a file isn't listed in the metadata.
+	ErrUnknownTarget = errors.New("file is not listed in the release metadata")
+)
+
+// Verifier checks release metadata.
+type Verifier struct {
+	// Keys are the trusted public keys.
+	Keys []ed25519.PublicKey
+	// Threshold is the number of distinct trusted keys that must have signed the metadata. If
+	// zero, one signature is required.
+	Threshold int
+	// MinVersion is the lowest accepted metadata version, for example the version of the last
+	// metadata the client trusted. This prevents rollback to an older release.
+	MinVersion int
+	// Now is the time used to check expiry. If zero, the current time is used.
+	Now time.Time
+}
+
+// Verify checks the signatures, type, version, and expiry of the metadata document data, and
+// returns the signed part if it's valid.
+func (v *Verifier) Verify(data []byte) (*Targets, error) {
+	var m struct {
+		Signed     json.RawMessage `json:"signed"`
+		Signatures []Signature     `json:"signatures"`
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid release metadata: %v", err)
+	}
+	if m.Signed == nil {
+		return nil, errors.New("invalid release metadata: no signed part")
+	}
+	// Check the signatures of the received bytes before trusting anything in them.
+	msg, err := canonicalize(m.Signed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid release metadata: %v", err)
+	}
+	keys := make(map[string]ed25519.PublicKey, len(v.Keys))
+	for _, k := range v.Keys {
+		keys[signer.KeyID(k)] = k
+	}
+	valid := make(map[string]bool)
+	for _, s := range m.Signatures {
+		k, ok := keys[s.KeyID]
+		if !ok {
+			continue
+		}
+		sig, err := hex.DecodeString(s.Sig)
+		if err == nil && ed25519.Verify(k, msg, sig) {
+			valid[s.KeyID] = true
+		}
+	}
+	threshold := max(v.Threshold, 1)
+	if len(valid) < threshold {
+		return nil, fmt.Errorf("release metadata has %v valid signatures from trusted keys, need %v", len(valid), threshold)
+	}
+
+	var t Targets
+	if err := json.Unmarshal(m.Signed, &t); err != nil {
+		return nil, fmt.Errorf("invalid release metadata: %v", err)
+	}
+	if t.Type != "targets" {
+		return nil, fmt.Errorf("release metadata type is %q, want %q", t.Type, "targets")
+	}
+	if t.Version < v.MinVersion {
+		return nil, fmt.Errorf("%w: got %v, minimum %v", ErrRollback, t.Version, v.MinVersion)
+	}
+	now := v.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if !now.Before(t.Expires) {
+		return nil, fmt.Errorf("%w: expired at %v", ErrExpired, t.Expires)
+	}
+	return &t, nil
+}
+
+// VerifyFile checks that the file at path matches the target called name.
+func (t *Targets) VerifyFile(name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return t.VerifyReader(name, f)
+}
+
+// VerifyReader checks that the content of r matches the target called name. It reads at most
+// one byte more than the target's length, so a server can't send endless data.
+func (t *Targets) VerifyReader(name string, r io.Reader) error {
+	target, ok := t.Targets[name]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownTarget, name)
+	}
+	var algs []checksum.Algorithm
+	for a := range target.Hashes {
+		// Ignore hashes this package doesn't support: another one may be enough.
+		if alg, err := checksum.ParseAlgorithm(a); err == nil {
+			algs = append(algs, alg)
+		}
+	}
+	if len(algs) == 0 {
+		return fmt.Errorf("%v: no supported hashes in release metadata", name)
+	}
+	h := checksum.NewHasher(algs...)
+	n, err := io.Copy(h, io.LimitReader(r, target.Length+1))
+	if err != nil {
+		return err
+	}
+	if n != target.Length {
+		return fmt.Errorf("%v: length %v doesn't match release metadata length %v", name, n, target.Length)
+	}
+	for a, sum := range h.Sums() {
+		if sum != target.Hashes[string(a)] {
+			return fmt.Errorf("%v: %w for %v", name, checksum.ErrMismatch, a)
+		}
+	}
+	return nil
+}